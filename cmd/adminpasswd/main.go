@@ -0,0 +1,19 @@
+// Command adminpasswd prints a password hash for the ADMIN_USERS_FILE
+//
+//	go run ./cmd/adminpasswd <password>
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bimakw/api-gateway/internal/adminauth"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: adminpasswd <password>")
+		os.Exit(2)
+	}
+	fmt.Println(adminauth.HashPassword(os.Args[1]))
+}
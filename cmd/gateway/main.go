@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,56 +12,100 @@ import (
 	"time"
 
 	"github.com/bimakw/api-gateway/config"
+	"github.com/bimakw/api-gateway/internal/adminauth"
+	"github.com/bimakw/api-gateway/internal/admission"
 	"github.com/bimakw/api-gateway/internal/apikey"
+	"github.com/bimakw/api-gateway/internal/audit"
 	"github.com/bimakw/api-gateway/internal/circuitbreaker"
 	"github.com/bimakw/api-gateway/internal/handler"
 	"github.com/bimakw/api-gateway/internal/health"
 	"github.com/bimakw/api-gateway/internal/metrics"
 	"github.com/bimakw/api-gateway/internal/middleware"
 	"github.com/bimakw/api-gateway/internal/proxy"
+	"github.com/bimakw/api-gateway/internal/proxyproto"
 	"github.com/bimakw/api-gateway/internal/ratelimit"
+	"github.com/bimakw/api-gateway/internal/redisclient"
 	"github.com/bimakw/api-gateway/internal/retry"
-	"github.com/redis/go-redis/v9"
+	"github.com/bimakw/api-gateway/internal/server"
+	"github.com/bimakw/api-gateway/internal/servicestate"
+	"github.com/bimakw/api-gateway/internal/version"
 )
 
 func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
+		Level: parseLogLevel(cfg.Logging.Level),
 	}))
 	slog.SetDefault(logger)
 
-	cfg := config.Load()
-
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%s", cfg.Redis.Host, cfg.Redis.Port),
-		Password: cfg.Redis.Password,
-		DB:       cfg.Redis.DB,
-	})
+	redisClient, err := redisclient.New(cfg.Redis)
+	if err != nil {
+		logger.Error("Invalid Redis configuration", "error", err)
+		os.Exit(1)
+	}
 
 	ctx := context.Background()
 	if err := redisClient.Ping(ctx).Err(); err != nil {
 		logger.Error("Failed to connect to Redis", "error", err)
 		os.Exit(1)
 	}
-	logger.Info("Connected to Redis")
+	logger.Info("Connected to Redis", "mode", cfg.Redis.Mode)
+
+	buildInfo := version.Get()
+	logger.Info("Gateway build", "version", buildInfo.Version, "commit", buildInfo.Commit, "build_date", buildInfo.BuildDate)
+
+	metrics.Get().SetRoutePatterns(cfg.Metrics.RoutePatterns)
+	metrics.Get().SetStandardFormat(cfg.Metrics.StandardFormat)
+	metrics.Get().SetRedisPoolStats(func() metrics.RedisPoolStats {
+		return redisclient.PoolStats(redisClient)
+	})
 
 	rateLimiter := ratelimit.New(redisClient, cfg.RateLimit.RequestsPerMinute, cfg.RateLimit.WindowDuration)
+	if err := rateLimiter.SetAlgorithm(cfg.RateLimit.Algorithm); err != nil {
+		logger.Error("Invalid rate limit configuration", "error", err)
+		os.Exit(1)
+	}
 	apiKeyMgr := apikey.NewManager(redisClient)
+	if cfg.APIKey.FallbackEnabled {
+		apiKeyMgr.EnableFallback(cfg.APIKey.FallbackTTL, nil)
+		logger.Info("API key fallback enabled", "ttl", cfg.APIKey.FallbackTTL)
+	}
 
 	healthChecker := health.NewChecker(
-		cfg.Services,
-		25*time.Second,
-		4*time.Second,
+		cfg.AllServices(),
+		health.Config{
+			Interval:           25 * time.Second,
+			Timeout:            4 * time.Second,
+			HealthyThreshold:   cfg.Health.HealthyThreshold,
+			UnhealthyThreshold: cfg.Health.UnhealthyThreshold,
+			DegradedThreshold:  cfg.Health.DegradedThreshold,
+		},
 		logger,
 	)
 
 	go healthChecker.Start(ctx)
 
 	cbConfig := circuitbreaker.Config{
-		MaxFailures:         cfg.CircuitBreaker.MaxFailures,
-		ResetTimeout:        time.Duration(cfg.CircuitBreaker.ResetTimeoutSeconds) * time.Second,
-		HalfOpenMaxRequests: cfg.CircuitBreaker.HalfOpenMaxRequests,
-		SuccessThreshold:    cfg.CircuitBreaker.SuccessThreshold,
+		MaxFailures:            cfg.CircuitBreaker.MaxFailures,
+		ResetTimeout:           time.Duration(cfg.CircuitBreaker.ResetTimeoutSeconds) * time.Second,
+		HalfOpenMaxRequests:    cfg.CircuitBreaker.HalfOpenMaxRequests,
+		SuccessThreshold:       cfg.CircuitBreaker.SuccessThreshold,
+		SingleProbe:            cfg.CircuitBreaker.SingleProbe,
+		ResetBackoffMultiplier: cfg.CircuitBreaker.ResetBackoffMultiplier,
+		ResetTimeoutMax:        time.Duration(cfg.CircuitBreaker.ResetTimeoutMaxSeconds) * time.Second,
+		WarmUp:                 time.Duration(cfg.CircuitBreaker.WarmUpSeconds) * time.Second,
+		OnStateChange: func(name string, from, to circuitbreaker.State) {
+			metrics.Get().UpdateCircuitBreakerState(name, to.String())
+			if to == circuitbreaker.StateOpen {
+				metrics.Get().IncrementCircuitBreakerTrips(name)
+			}
+		},
+		OnOutcome: metrics.Get().RecordCircuitBreakerOutcome,
 	}
 
 	retryConfig := retry.Config{
@@ -69,13 +114,26 @@ func main() {
 		MaxDelay:     time.Duration(cfg.Retry.MaxDelayMs) * time.Millisecond,
 		Multiplier:   cfg.Retry.Multiplier,
 		JitterFactor: cfg.Retry.JitterFactor,
+		JitterMode:   retry.JitterMode(cfg.Retry.JitterMode),
+
+		RetryOnTooEarly: cfg.Retry.RetryOnTooEarly,
 	}
 
-	reverseProxy, err := proxy.New(cfg.Services, cbConfig, retryConfig, logger)
+	reverseProxy, err := proxy.New(cfg.Services, cfg.Proxy, cbConfig, retryConfig, logger)
 	if err != nil {
 		logger.Error("Failed to create reverse proxy", "error", err)
 		os.Exit(1)
 	}
+	proxy.LogRoutes(logger, reverseProxy.Routes())
+
+	if cfg.Proxy.WarmUp {
+		warmCtx, cancelWarm := context.WithTimeout(ctx, cfg.Proxy.WarmUpTimeout)
+		warmed := reverseProxy.WarmUp(warmCtx, cfg.Proxy.WarmUpConnections)
+		cancelWarm()
+		logger.Info("Backend connections warmed", "connections", warmed, "per_backend", cfg.Proxy.WarmUpConnections)
+	}
+
+	go servicestate.NewStore(redisClient).Sync(ctx, servicestate.DefaultSyncInterval, reverseProxy.SetDisabledServices, logger)
 
 	healthChecker.RegisterCallback(func(serviceName, instanceURL string, healthy bool) {
 		reverseProxy.UpdateBackendHealth(serviceName, instanceURL, healthy)
@@ -90,28 +148,44 @@ func main() {
 		"max_retries", cfg.Retry.MaxRetries,
 		"initial_delay_ms", cfg.Retry.InitialDelayMs,
 		"max_delay_ms", cfg.Retry.MaxDelayMs,
+		"jitter_mode", cfg.Retry.JitterMode,
 	)
 
-	if cfg.Admin.Enabled && cfg.Admin.Password == "" {
-		logger.Error("Admin auth is enabled but ADMIN_PASSWORD is not set")
-		os.Exit(1)
-	}
-
+	var adminStore *adminauth.Store
 	if cfg.Admin.Enabled {
-		logger.Info("Admin authentication enabled", "username", cfg.Admin.Username)
+		adminStore, err = loadAdminStore(cfg.Admin)
+		if err != nil {
+			logger.Error("Failed to configure admin authentication", "error", err)
+			os.Exit(1)
+		}
+
+		if cfg.Admin.UsersFile != "" {
+			logger.Info("Admin authentication enabled", "users_file", cfg.Admin.UsersFile)
+		} else {
+			logger.Info("Admin authentication enabled", "username", cfg.Admin.Username)
+		}
 	} else {
 		logger.Warn("Admin authentication is DISABLED - admin endpoints are not protected!")
 	}
 
-	handlers := handler.New(cfg, apiKeyMgr, healthChecker, reverseProxy)
+	handlers := handler.New(cfg, apiKeyMgr, healthChecker, reverseProxy, redisClient)
+	if cfg.Admin.AuditRedis {
+		handlers.SetAuditLog(audit.New(logger, redisClient, cfg.Admin.AuditMaxEntries))
+	} else {
+		handlers.SetAuditLog(audit.New(logger, nil, 0))
+	}
 
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("GET /health", handlers.Health)
 	mux.HandleFunc("GET /info", handlers.Info)
+	mux.HandleFunc("GET /version", handlers.Version)
 	mux.HandleFunc("GET /services/health", handlers.ServicesHealth)
 	mux.HandleFunc("POST /admin/apikeys", handlers.CreateAPIKey)
 	mux.HandleFunc("GET /admin/apikeys", handlers.ListAPIKeys)
+	mux.HandleFunc("PATCH /admin/apikeys/{id}", handlers.UpdateAPIKey)
+	mux.HandleFunc("POST /admin/apikeys/{id}/rotate", handlers.RotateAPIKey)
+	mux.HandleFunc("POST /admin/apikeys/revoke", handlers.RevokeAPIKeys)
 	mux.HandleFunc("POST /admin/apikeys/{id}/revoke", handlers.RevokeAPIKey)
 	mux.HandleFunc("DELETE /admin/apikeys/{id}", handlers.DeleteAPIKey)
 
@@ -119,45 +193,77 @@ func main() {
 	mux.HandleFunc("POST /admin/circuit-breakers/{name}/reset", handlers.ResetCircuitBreaker)
 	mux.HandleFunc("POST /admin/circuit-breakers/reset", handlers.ResetAllCircuitBreakers)
 
-	mux.HandleFunc("GET /metrics", metrics.Handler())
+	mux.HandleFunc("POST /admin/services/{name}/disable", handlers.DisableService)
+	mux.HandleFunc("POST /admin/services/{name}/enable", handlers.EnableService)
+	mux.HandleFunc("DELETE /admin/services/{name}", handlers.RemoveService)
+	mux.HandleFunc("POST /admin/services/{name}/health", handlers.SetServiceHealth)
+	mux.HandleFunc("POST /admin/services/{name}/health/auto", handlers.AutoServiceHealth)
 
-	mux.Handle("/", reverseProxy)
+	mux.HandleFunc("GET /admin/metrics/services", handlers.GetServiceMetrics)
+	mux.HandleFunc("GET /admin/metrics/rate", handlers.GetRequestRate)
+	mux.HandleFunc("GET /admin/metrics/in-flight", handlers.GetInFlight)
+	mux.HandleFunc("GET /admin/config", handlers.GetConfig)
 
-	middlewares := []middleware.Middleware{
-		middleware.Recover(logger),
-		middleware.Metrics(),
-		middleware.Logger(logger),
-		middleware.CORS([]string{"*"}),
-	}
+	// Catch-all so unknown admin paths are auth-gated and never proxied
+	mux.HandleFunc("/admin/", handlers.AdminNotFound)
 
-	if cfg.Admin.Enabled {
-		middlewares = append(middlewares, middleware.AdminAuth(cfg.Admin.Username, cfg.Admin.Password, logger))
-	}
+	mux.HandleFunc("GET /metrics", metrics.Handler())
 
-	middlewares = append(middlewares,
-		middleware.APIKeyAuth(apiKeyMgr, false),
-		middleware.RateLimit(rateLimiter, cfg.RateLimit.BurstSize),
-	)
+	if cfg.Admission.Enabled {
+		admissionCtrl := admission.New(admission.Config{
+			MaxInFlight:  cfg.Admission.MaxInFlight,
+			MaxQueue:     cfg.Admission.MaxQueue,
+			QueueTimeout: cfg.Admission.QueueTimeout,
+		})
+		mux.Handle("/", middleware.Admission(admissionCtrl)(reverseProxy))
+		logger.Info("Admission control enabled",
+			"max_in_flight", cfg.Admission.MaxInFlight,
+			"max_queue", cfg.Admission.MaxQueue,
+			"queue_timeout", cfg.Admission.QueueTimeout,
+		)
+	} else {
+		mux.Handle("/", reverseProxy)
+	}
 
+	middlewares := buildMiddlewares(cfg, middlewareDeps{
+		logger:       logger,
+		apiKeyMgr:    apiKeyMgr,
+		adminStore:   adminStore,
+		rateLimiter:  rateLimiter,
+		reverseProxy: reverseProxy,
+		routes:       mux,
+	})
 	finalHandler := middleware.Chain(mux, middlewares...)
 
-	addr := fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port)
-	server := &http.Server{
-		Addr:         addr,
-		Handler:      finalHandler,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 25 * time.Second,
-		IdleTimeout:  90 * time.Second,
+	srv := server.New(cfg.Server, finalHandler)
+
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		logger.Error("Failed to listen", "addr", srv.Addr, "error", err)
+		os.Exit(1)
+	}
+	if cfg.Server.ProxyProtocol {
+		ln = proxyproto.NewListener(ln, proxyproto.DefaultHeaderTimeout)
 	}
 
 	go func() {
-		logger.Info("Starting API Gateway", "addr", addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Info("Starting API Gateway", "addr", srv.Addr, "tls", cfg.Server.TLSEnabled(), "proxy_protocol", cfg.Server.ProxyProtocol)
+		if err := server.Serve(srv, ln, cfg.Server); err != nil && err != http.ErrServerClosed {
 			logger.Error("Server error", "error", err)
 			os.Exit(1)
 		}
 	}()
 
+	redirectSrv := server.NewRedirectServer(cfg.Server)
+	if redirectSrv != nil {
+		go func() {
+			logger.Info("Starting HTTP to HTTPS redirect", "addr", redirectSrv.Addr)
+			if err := redirectSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("Redirect server error", "error", err)
+			}
+		}()
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
@@ -167,13 +273,41 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 25*time.Second)
 	defer cancel()
 
-	if err := server.Shutdown(ctx); err != nil {
+	if err := srv.Shutdown(ctx); err != nil {
 		logger.Error("Server forced to shutdown", "error", err)
 	}
 
+	if redirectSrv != nil {
+		redirectSrv.Shutdown(ctx)
+	}
+
 	healthChecker.Stop()
 
 	redisClient.Close()
 
 	logger.Info("Server exited")
 }
+
+func parseLogLevel(level string) slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
+
+// loadAdminStore reads admin users from ADMIN_USERS_FILE, falling back to the
+// single ADMIN_USERNAME/ADMIN_PASSWORD account with the admin role
+func loadAdminStore(cfg config.AdminConfig) (*adminauth.Store, error) {
+	if cfg.UsersFile != "" {
+		return adminauth.LoadStore(cfg.UsersFile)
+	}
+	if cfg.Password == "" {
+		return nil, fmt.Errorf("ADMIN_PASSWORD is not set")
+	}
+	return adminauth.NewStore([]adminauth.User{{
+		Username:     cfg.Username,
+		PasswordHash: adminauth.HashPassword(cfg.Password),
+		Role:         adminauth.RoleAdmin,
+	}})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/bimakw/api-gateway/config"
+	"github.com/bimakw/api-gateway/internal/adminauth"
+	"github.com/bimakw/api-gateway/internal/apikey"
+	"github.com/bimakw/api-gateway/internal/middleware"
+	"github.com/bimakw/api-gateway/internal/proxy"
+	"github.com/bimakw/api-gateway/internal/ratelimit"
+)
+
+// middlewareDeps holds the components the middleware chain is built from
+type middlewareDeps struct {
+	logger       *slog.Logger
+	apiKeyMgr    *apikey.Manager
+	adminStore   *adminauth.Store
+	rateLimiter  *ratelimit.RateLimiter
+	reverseProxy *proxy.ReverseProxy
+	// routes is the gateway mux; requests it doesn't hand to reverseProxy
+	// (health, metrics, admin) belong to no service. nil = all are proxied.
+	routes *http.ServeMux
+}
+
+// proxied reports whether routes hands r to the reverse proxy, i.e. whether
+// the request is matched by the "/" catch-all rather than a gateway endpoint
+func (d middlewareDeps) proxied(r *http.Request) bool {
+	if d.routes == nil {
+		return true
+	}
+	_, pattern := d.routes.Handler(r)
+	return pattern == "/"
+}
+
+// serviceFor resolves the service handling a proxied request. Gateway
+// endpoints resolve to none, so the default service's key scopes don't apply
+// to /health or /admin.
+func (d middlewareDeps) serviceFor(r *http.Request) (string, bool) {
+	if !d.proxied(r) {
+		return "", false
+	}
+	return d.reverseProxy.ServiceNameFor(r.URL.Path)
+}
+
+// corsOriginsFor returns the CORS origins of the service handling a proxied
+// request; gateway endpoints keep the global policy. A preflight is routed by
+// the method it asks about, since that's the request it clears.
+func (d middlewareDeps) corsOriginsFor(r *http.Request) ([]string, bool) {
+	if method := r.Header.Get("Access-Control-Request-Method"); r.Method == http.MethodOptions && method != "" {
+		r = r.Clone(r.Context())
+		r.Method = method
+	}
+	if !d.proxied(r) {
+		return nil, false
+	}
+	return d.reverseProxy.CORSOriginsFor(r.URL.Path)
+}
+
+// buildMiddlewares assembles the request chain, leaving out middleware that
+// cfg.Middleware disables. Panic recovery, request framing checks, request
+// attributes and authentication are always included; the concurrency cap,
+// when configured, comes first and the header size check follows the
+// framing checks. Admin authentication runs last, behind the rate limiter.
+func buildMiddlewares(cfg *config.Config, deps middlewareDeps) []middleware.Middleware {
+	var middlewares []middleware.Middleware
+	if cfg.Server.MaxConcurrentRequests > 0 {
+		middlewares = append(middlewares, middleware.ConcurrencyLimit(cfg.Server.MaxConcurrentRequests, deps.logger))
+	}
+	middlewares = append(middlewares,
+		middleware.Recover(deps.logger),
+		middleware.RejectSmuggling(deps.logger),
+	)
+	if cfg.Server.MaxHeaderBytes > 0 {
+		middlewares = append(middlewares, middleware.MaxHeaderSize(cfg.Server.MaxHeaderBytes, deps.logger))
+	}
+	middlewares = append(middlewares,
+		middleware.Attributes(deps.serviceFor),
+	)
+
+	if cfg.Middleware.Metrics {
+		middlewares = append(middlewares, middleware.Metrics())
+	}
+	if cfg.Middleware.Logging {
+		middlewares = append(middlewares, middleware.Logger(deps.logger, middleware.LoggerConfig{
+			SlowRequestThreshold: cfg.Logging.SlowRequestThreshold,
+			SampleRate:           cfg.Logging.SampleRate,
+			Format:               cfg.Logging.Format,
+		}))
+	}
+	if cfg.Middleware.CORS {
+		middlewares = append(middlewares, middleware.CORS([]string{"*"}, deps.corsOriginsFor))
+	}
+
+	if cfg.Logging.LogBodies {
+		deps.logger.Warn("Request/response body logging is enabled",
+			"max_body_bytes", cfg.Logging.MaxBodyBytes,
+			"sample_rate", cfg.Logging.BodySampleRate,
+			"errors_only", cfg.Logging.BodyErrorsOnly,
+		)
+		middlewares = append(middlewares, middleware.BodyLogger(deps.logger, middleware.BodyLogConfig{
+			MaxBodyBytes:  cfg.Logging.MaxBodyBytes,
+			RedactHeaders: cfg.Logging.RedactHeaders,
+			SampleRate:    cfg.Logging.BodySampleRate,
+			ErrorsOnly:    cfg.Logging.BodyErrorsOnly,
+		}))
+	}
+
+	middlewares = append(middlewares,
+		middleware.APIKeyAuth(deps.apiKeyMgr, middleware.APIKeyAuthConfig{
+			Required:      cfg.APIKey.Required,
+			ExemptPaths:   cfg.APIKey.ExemptPaths,
+			RequiredPaths: cfg.APIKey.RequiredPaths,
+			Proxied:       deps.proxied,
+		}),
+		middleware.APIKeyScope(deps.serviceFor),
+	)
+
+	if cfg.Middleware.RateLimit {
+		middlewares = append(middlewares, middleware.RateLimit(deps.rateLimiter, middleware.RateLimitConfig{
+			BurstSize:     cfg.RateLimit.BurstSize,
+			WarnThreshold: cfg.RateLimit.WarnThreshold,
+
+			AnonymousRPM:     cfg.RateLimit.AnonymousRPM,
+			AuthenticatedRPM: cfg.RateLimit.AuthenticatedRPM,
+
+			ExemptPaths: cfg.RateLimit.ExemptPaths,
+		}))
+	}
+
+	// After the rate limiter, so failed admin logins use up the quota of the
+	// connection they arrive on
+	if cfg.Admin.Enabled {
+		middlewares = append(middlewares, middleware.AdminAuth(deps.adminStore, deps.logger))
+	}
+
+	return middlewares
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/bimakw/api-gateway/config"
+	"github.com/bimakw/api-gateway/internal/adminauth"
+	"github.com/bimakw/api-gateway/internal/apikey"
+	"github.com/bimakw/api-gateway/internal/circuitbreaker"
+	"github.com/bimakw/api-gateway/internal/clock"
+	"github.com/bimakw/api-gateway/internal/middleware"
+	"github.com/bimakw/api-gateway/internal/proxy"
+	"github.com/bimakw/api-gateway/internal/ratelimit"
+	"github.com/bimakw/api-gateway/internal/retry"
+)
+
+// newTestChain builds the gateway chain from cfg around a handler that always
+// answers 200
+func newTestChain(t *testing.T, cfg *config.Config) http.Handler {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	reverseProxy, err := proxy.New(nil, config.ProxyConfig{}, circuitbreaker.DefaultConfig(), retry.Config{}, logger)
+	if err != nil {
+		t.Fatalf("proxy.New() error = %v", err)
+	}
+
+	adminStore, err := adminauth.NewStore([]adminauth.User{
+		{Username: "admin", PasswordHash: adminauth.HashPassword("secret"), Role: adminauth.RoleAdmin},
+	})
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	// A stopped clock: buckets never refill, however slow the request
+	limiter := ratelimit.New(client, 60, time.Minute)
+	limiter.SetClock(clock.NewFake(time.Unix(0, 0)))
+
+	middlewares := buildMiddlewares(cfg, middlewareDeps{
+		logger:       logger,
+		apiKeyMgr:    apikey.NewManager(client),
+		adminStore:   adminStore,
+		rateLimiter:  limiter,
+		reverseProxy: reverseProxy,
+	})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return middleware.Chain(ok, middlewares...)
+}
+
+func TestBuildMiddlewaresHonorsToggles(t *testing.T) {
+	tests := []struct {
+		name       string
+		toggles    config.MiddlewareConfig
+		wantHeader map[string]bool
+	}{
+		{
+			name:       "all enabled",
+			toggles:    config.MiddlewareConfig{CORS: true, Metrics: true, Logging: true, RateLimit: true},
+			wantHeader: map[string]bool{"X-RateLimit-Remaining": true, "Access-Control-Allow-Origin": true},
+		},
+		{
+			name:       "rate limit disabled",
+			toggles:    config.MiddlewareConfig{CORS: true, Metrics: true, Logging: true},
+			wantHeader: map[string]bool{"X-RateLimit-Remaining": false, "Access-Control-Allow-Origin": true},
+		},
+		{
+			name:       "CORS disabled",
+			toggles:    config.MiddlewareConfig{Metrics: true, Logging: true, RateLimit: true},
+			wantHeader: map[string]bool{"X-RateLimit-Remaining": true, "Access-Control-Allow-Origin": false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Middleware: tt.toggles}
+			cfg.RateLimit.BurstSize = 10
+			handler := newTestChain(t, cfg)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/anything", nil)
+			req.Header.Set("Origin", "https://app.example.com")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			for header, want := range tt.wantHeader {
+				if got := rec.Header().Get(header) != ""; got != want {
+					t.Errorf("%s present = %v, want %v", header, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFailedAdminLoginsAreRateLimited(t *testing.T) {
+	cfg := &config.Config{Middleware: config.MiddlewareConfig{RateLimit: true}}
+	cfg.Admin.Enabled = true
+	cfg.RateLimit.BurstSize = 3
+	cfg.RateLimit.ExemptPaths = []string{"/health", "/metrics"}
+	handler := newTestChain(t, cfg)
+
+	// Each guess claims a different forwarded address; the connection's counts
+	guesses := 0
+	send := func(password string) int {
+		guesses++
+		req := httptest.NewRequest(http.MethodGet, "/admin/config", nil)
+		req.RemoteAddr = "203.0.113.20:1234"
+		req.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(guesses))
+		req.SetBasicAuth("admin", password)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i := 0; i < cfg.RateLimit.BurstSize; i++ {
+		if code := send("guess"); code != http.StatusUnauthorized {
+			t.Fatalf("guess %d status = %d, want 401", i+1, code)
+		}
+	}
+	// Once the quota is used up even the right password has to wait
+	if code := send("guess"); code != http.StatusTooManyRequests {
+		t.Errorf("guess beyond burst status = %d, want 429", code)
+	}
+	if code := send("secret"); code != http.StatusTooManyRequests {
+		t.Errorf("login beyond burst status = %d, want 429", code)
+	}
+}
+
+func TestScopedKeysReachGatewayEndpoints(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(backend.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	reverseProxy, err := proxy.New([]config.ServiceConfig{
+		{Name: "user-service", PathPrefix: "/api/users", TargetURL: backend.URL},
+	}, config.ProxyConfig{
+		DefaultService: &config.ServiceConfig{Name: "web", PathPrefix: "/", TargetURL: backend.URL},
+	}, circuitbreaker.DefaultConfig(), retry.Config{}, logger)
+	if err != nil {
+		t.Fatalf("proxy.New() error = %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.Handle("/", reverseProxy)
+
+	apiKeyMgr := apikey.NewManager(client)
+	created, err := apiKeyMgr.CreateKey(context.Background(), &apikey.CreateKeyRequest{
+		Name:            "scoped",
+		AllowedServices: []string{"user-service"},
+	})
+	if err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+
+	handler := middleware.Chain(mux, buildMiddlewares(&config.Config{}, middlewareDeps{
+		logger:       logger,
+		apiKeyMgr:    apiKeyMgr,
+		rateLimiter:  ratelimit.New(client, 60, time.Minute),
+		reverseProxy: reverseProxy,
+		routes:       mux,
+	})...)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   int
+	}{
+		{"gateway endpoint", http.MethodGet, "/health", http.StatusOK},
+		{"allowed service", http.MethodGet, "/api/users/1", http.StatusOK},
+		{"default service", http.MethodGet, "/home", http.StatusForbidden},
+		// Not a gateway route: the mux proxies it to the default service
+		{"proxied method on gateway path", http.MethodPost, "/health", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("X-API-Key", created.RawKey)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestServiceCORSOnlyAppliesToProxiedRoutes(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(backend.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	reverseProxy, err := proxy.New(nil, config.ProxyConfig{
+		DefaultService: &config.ServiceConfig{Name: "web", PathPrefix: "/", TargetURL: backend.URL,
+			CORSAllowedOrigins: []string{"https://app.example.com"}},
+	}, circuitbreaker.DefaultConfig(), retry.Config{}, logger)
+	if err != nil {
+		t.Fatalf("proxy.New() error = %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.Handle("/", reverseProxy)
+
+	cfg := &config.Config{Middleware: config.MiddlewareConfig{CORS: true}}
+	handler := middleware.Chain(mux, buildMiddlewares(cfg, middlewareDeps{
+		logger:       logger,
+		apiKeyMgr:    apikey.NewManager(client),
+		rateLimiter:  ratelimit.New(client, 60, time.Minute),
+		reverseProxy: reverseProxy,
+		routes:       mux,
+	})...)
+
+	tests := []struct {
+		name      string
+		preflight bool
+		path      string
+		origin    string
+		want      string
+	}{
+		// Gateway endpoints keep the global policy
+		{"gateway endpoint", false, "/health", "https://other.example.com", "https://other.example.com"},
+		{"gateway endpoint preflight", true, "/health", "https://other.example.com", "https://other.example.com"},
+		{"proxied disallowed origin", false, "/home", "https://other.example.com", ""},
+		{"proxied preflight disallowed origin", true, "/home", "https://other.example.com", ""},
+		{"proxied allowed origin", false, "/home", "https://app.example.com", "https://app.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.preflight {
+				req = httptest.NewRequest(http.MethodOptions, tt.path, nil)
+				req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			}
+			req.Header.Set("Origin", tt.origin)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.want {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequiredAPIKeyExemptsGatewayEndpoints(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(backend.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	reverseProxy, err := proxy.New(nil, config.ProxyConfig{
+		DefaultService: &config.ServiceConfig{Name: "web", PathPrefix: "/", TargetURL: backend.URL},
+	}, circuitbreaker.DefaultConfig(), retry.Config{}, logger)
+	if err != nil {
+		t.Fatalf("proxy.New() error = %v", err)
+	}
+	adminStore, err := adminauth.NewStore([]adminauth.User{
+		{Username: "admin", PasswordHash: adminauth.HashPassword("secret"), Role: adminauth.RoleAdmin},
+	})
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", ok)
+	mux.HandleFunc("GET /metrics", ok)
+	mux.HandleFunc("GET /admin/keys", ok)
+	mux.Handle("/", reverseProxy)
+
+	cfg := &config.Config{}
+	cfg.APIKey.Required = true
+	cfg.Admin.Enabled = true
+	handler := middleware.Chain(mux, buildMiddlewares(cfg, middlewareDeps{
+		logger:       logger,
+		apiKeyMgr:    apikey.NewManager(client),
+		adminStore:   adminStore,
+		rateLimiter:  ratelimit.New(client, 60, time.Minute),
+		reverseProxy: reverseProxy,
+		routes:       mux,
+	})...)
+
+	tests := []struct {
+		name  string
+		path  string
+		admin bool
+		want  int
+	}{
+		{"health", "/health", false, http.StatusOK},
+		{"metrics", "/metrics", false, http.StatusOK},
+		{"admin with credentials", "/admin/keys", true, http.StatusOK},
+		{"proxied without key", "/home", false, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.admin {
+				req.SetBasicAuth("admin", "secret")
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
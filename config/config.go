@@ -1,6 +1,7 @@
 package config
 
 import (
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -11,17 +12,122 @@ type Config struct {
 	Server         ServerConfig
 	Redis          RedisConfig
 	RateLimit      RateLimitConfig
+	Admission      AdmissionConfig
+	APIKey         APIKeyConfig
 	CircuitBreaker CircuitBreakerConfig
 	Retry          RetryConfig
 	Admin          AdminConfig
+	Health         HealthConfig
+	Logging        LoggingConfig
+	Metrics        MetricsConfig
+	Middleware     MiddlewareConfig
+	Proxy          ProxyConfig
 	Services       []ServiceConfig
 }
 
+// MiddlewareConfig switches optional middleware on or off, e.g. CORS and rate
+// limiting for internal deployments. Panic recovery and authentication
+// always run.
+type MiddlewareConfig struct {
+	CORS      bool
+	Metrics   bool
+	Logging   bool
+	RateLimit bool
+}
+
+type ProxyConfig struct {
+	// DefaultService receives requests that match no service prefix (nil = 404)
+	DefaultService *ServiceConfig
+	// LoadBalanceStrategy applies to services that don't set their own Strategy
+	LoadBalanceStrategy string
+	// SlowStart ramps a backend that turns healthy up to its full share of
+	// traffic over this window (0 = full share immediately)
+	SlowStart time.Duration
+	// DisableDiagnosticHeaders stops X-Gateway-Service, X-Gateway-Upstream,
+	// X-Backend and X-Retry-Count from being added to proxied responses
+	DisableDiagnosticHeaders bool
+	// ExposeCircuitState adds X-Circuit-State (closed, half-open, open) to
+	// proxied responses; off by default since it reveals internal state
+	ExposeCircuitState bool
+	// BodyBufferThreshold is the largest request body buffered in memory for
+	// retries; larger bodies spill to a temp file in BodySpillDir
+	BodyBufferThreshold int64
+	BodySpillDir        string
+	// MaxResponseBytes caps backend response bodies; larger buffered responses
+	// become a 502 and streams are cut off at the limit (0 = unlimited)
+	MaxResponseBytes int64
+	// WarmUp opens WarmUpConnections idle connections to each backend at
+	// startup, waiting at most WarmUpTimeout before serving traffic
+	WarmUp            bool
+	WarmUpConnections int
+	WarmUpTimeout     time.Duration
+	// ErrorFormat shapes errors the gateway itself answers with, such as an
+	// open circuit or an unreachable backend: "json" (envelope, the default),
+	// "minimal" (status only, no body) or "template" (ErrorTemplate, a
+	// text/template, sent as ErrorContentType)
+	ErrorFormat      string
+	ErrorTemplate    string
+	ErrorContentType string
+	// MaxBackendConns caps the requests in flight to each backend; a request
+	// finding no free slot waits up to BackendConnWait, then gets a 503
+	// (0 = unlimited)
+	MaxBackendConns int
+	BackendConnWait time.Duration
+}
+
+type HealthConfig struct {
+	// CheckRedis adds a Redis ping to the gateway's own /health response
+	CheckRedis bool
+	// RedisRequired makes /health return 503 when Redis is unreachable
+	RedisRequired bool
+	RedisTimeout  time.Duration
+	// Consecutive backend probes needed before an instance flips status
+	HealthyThreshold   int
+	UnhealthyThreshold int
+	// DegradedThreshold flags instances whose passing probes are slower (0 = off)
+	DegradedThreshold time.Duration
+}
+
+type LoggingConfig struct {
+	Level string // debug, info, warn, error
+
+	// LogBodies enables debug-level request/response header and body logging
+	LogBodies     bool
+	MaxBodyBytes  int
+	RedactHeaders []string
+	// BodySampleRate logs bodies of this fraction of requests (0 = all) and
+	// BodyErrorsOnly of none; 5xx responses are always logged
+	BodySampleRate float64
+	BodyErrorsOnly bool
+
+	// SlowRequestThreshold logs only requests at least this slow (0 = log all)
+	SlowRequestThreshold time.Duration
+	// SampleRate is the fraction of faster requests still logged in slow-only mode
+	SampleRate float64
+	// Format of access logs: json, clf or combined
+	Format string
+}
+
+type MetricsConfig struct {
+	// RoutePatterns are templates like /api/users/:id used to label request metrics
+	RoutePatterns []string
+	// StandardFormat emits Prometheus client-style names and a duration histogram
+	StandardFormat bool
+}
+
 type CircuitBreakerConfig struct {
 	MaxFailures         int
 	ResetTimeoutSeconds int
 	HalfOpenMaxRequests int
 	SuccessThreshold    int
+	// SingleProbe admits one half-open probe and decides on its outcome,
+	// ignoring HalfOpenMaxRequests and SuccessThreshold
+	SingleProbe bool
+	// Exponential reset timeout backoff after failed half-open probes
+	ResetBackoffMultiplier float64
+	ResetTimeoutMaxSeconds int
+	// WarmUpSeconds ignores backend failures for this long after startup
+	WarmUpSeconds int
 }
 
 type RetryConfig struct {
@@ -30,30 +136,113 @@ type RetryConfig struct {
 	MaxDelayMs      int
 	Multiplier      float64
 	JitterFactor    float64
+	JitterMode      string // symmetric | none | full | equal
+	RetryOnTooEarly bool
 }
 
 type AdminConfig struct {
 	Username string
 	Password string
 	Enabled  bool
+	// UsersFile lists multiple admin users with hashed passwords and roles;
+	// when set it replaces Username/Password
+	UsersFile string
+	// AuditRedis also keeps the latest AuditMaxEntries admin audit entries
+	// in Redis; they're always logged
+	AuditRedis      bool
+	AuditMaxEntries int
 }
 
 type ServerConfig struct {
 	Host string
 	Port string
+
+	// TLS termination is enabled when both cert and key files are set
+	TLSCertFile string
+	TLSKeyFile  string
+	// HTTPRedirectPort, when set with TLS, serves HTTP→HTTPS redirects on this port
+	HTTPRedirectPort string
+
+	// H2C enables cleartext HTTP/2 alongside HTTP/1.1. Only prior knowledge
+	// is supported; Upgrade: h2c requests are served over HTTP/1.1.
+	H2C bool
+
+	// ProxyProtocol expects every connection to start with a PROXY protocol
+	// v1/v2 header, as sent by L4 load balancers, and takes the client address
+	// from it. Only enable it when all traffic arrives through such a balancer.
+	ProxyProtocol bool
+
+	// MaxConcurrentRequests sheds requests with 503 once this many are in
+	// flight (0 = unlimited)
+	MaxConcurrentRequests int
+
+	// MaxHeaderBytes rejects requests whose request line and headers exceed
+	// this many bytes with a JSON 431 (0 = only net/http's 1 MB limit)
+	MaxHeaderBytes int
+}
+
+func (s *ServerConfig) TLSEnabled() bool {
+	return s.TLSCertFile != "" && s.TLSKeyFile != ""
 }
 
 type RedisConfig struct {
+	Mode     string // "standalone" (default), "sentinel", or "cluster"
 	Host     string
 	Port     string
 	Password string
 	DB       int
+
+	// Sentinel mode
+	SentinelAddrs  []string
+	SentinelMaster string
+
+	// Cluster mode
+	ClusterAddrs []string
+
+	// Connection pool tuning (0 = go-redis defaults)
+	PoolSize     int
+	MinIdleConns int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
 }
 
 type RateLimitConfig struct {
 	RequestsPerMinute int
 	BurstSize         int
 	WindowDuration    time.Duration
+	// WarnThreshold is the remaining-token fraction below which responses
+	// carry X-RateLimit-Warning (0 = disabled)
+	WarnThreshold float64
+	// AnonymousRPM and AuthenticatedRPM override RequestsPerMinute for
+	// requests without and with an API key (0 = RequestsPerMinute)
+	AnonymousRPM     int
+	AuthenticatedRPM int
+	// ExemptPaths are path prefixes the limiter skips entirely
+	ExemptPaths []string
+	// Algorithm is "token-bucket" (bursts pass at once) or "leaky" (bursts
+	// are queued and released at the rate)
+	Algorithm string
+}
+
+// APIKeyConfig controls where API keys are required. ExemptPaths and
+// RequiredPaths are path prefixes that override Required.
+type APIKeyConfig struct {
+	Required      bool
+	ExemptPaths   []string
+	RequiredPaths []string
+	// FallbackEnabled keeps keys validated within FallbackTTL working while
+	// Redis is unreachable; unknown keys are still rejected
+	FallbackEnabled bool
+	FallbackTTL     time.Duration
+}
+
+// AdmissionConfig bounds concurrent proxied requests; when saturated,
+// higher-priority API keys are admitted first and low-priority ones shed
+type AdmissionConfig struct {
+	Enabled      bool
+	MaxInFlight  int
+	MaxQueue     int
+	QueueTimeout time.Duration
 }
 
 type BackendConfig struct {
@@ -67,7 +256,72 @@ type ServiceConfig struct {
 	TargetURL  string          // deprecated: use Backends for multiple instances
 	Backends   []BackendConfig // multiple backend instances
 	StripPath  bool
-	Strategy   string // load balancing strategy: "round-robin", "random"
+	Strategy   string // load balancing strategy: "round-robin", "random", "weighted-random", "ip-hash" (empty = ProxyConfig.LoadBalanceStrategy)
+	// AllowedContentTypes restricts request body media types (empty = allow all)
+	AllowedContentTypes []string
+	// RewriteRedirects points backend Location headers back at the gateway
+	RewriteRedirects bool
+	// InjectFields are set as top-level string fields on JSON request bodies,
+	// overwriting any client-supplied values
+	InjectFields map[string]string
+	// DisableRetry makes the gateway send exactly one attempt to this service
+	DisableRetry bool
+	// MaxResponseBytes overrides ProxyConfig.MaxResponseBytes (0 = inherit, <0 = unlimited)
+	MaxResponseBytes int64
+	// UpstreamAuth is sent to the backend in place of the client's Authorization
+	UpstreamAuth UpstreamAuth
+	// VersionParam names a query parameter (e.g. "version") whose value picks a
+	// backend from VersionBackends; absent or unknown values use the primary backends
+	VersionParam    string
+	VersionBackends map[string]string // param value -> backend URL
+	// CORSAllowedOrigins replaces the global CORS policy for this service (empty = global)
+	CORSAllowedOrigins []string
+	// AllowedPaths, if set, limits the exposed paths; BlockedPaths are refused.
+	// Entries are path prefixes or globs like /api/users/*/profile.
+	AllowedPaths []string
+	BlockedPaths []string
+	// AllowedMethods limits the HTTP methods forwarded to this service (empty = all)
+	AllowedMethods []string
+	// RetryOnBodyMatch retries responses with RetryOnBodyStatus (0 = 200) whose
+	// body matches this regexp, for backends reporting transient errors in-band
+	RetryOnBodyMatch  string
+	RetryOnBodyStatus int
+	// CBWarmUpSeconds overrides CircuitBreakerConfig.WarmUpSeconds (0 = inherit)
+	CBWarmUpSeconds int
+	// ServeStale answers GETs with the last successful response, marked with
+	// a Warning header, while the circuit is open or the backend returns 502
+	ServeStale bool
+	// NormalizePath decodes percent-encoded unreserved characters in the
+	// forwarded path and collapses repeated slashes; LowercasePath also
+	// lower-cases it
+	NormalizePath bool
+	LowercasePath bool
+	// GRPCWeb translates gRPC-Web requests into gRPC and reaches the
+	// backends over HTTP/2 (h2c for plain http URLs)
+	GRPCWeb bool
+	// HealthCheckMethod is the HTTP method of health probes (empty = GET)
+	HealthCheckMethod string
+	// HostHeader is sent as Host to the backends instead of their own host,
+	// for virtual-hosted backends; PreserveHost forwards the client's Host
+	// instead (HostHeader wins when both are set)
+	HostHeader   string
+	PreserveHost bool
+	// NegotiateEncoding decodes gzip and deflate responses for clients whose
+	// Accept-Encoding doesn't allow them, re-encoding as gzip where accepted
+	NegotiateEncoding bool
+	// TrailingSlash is "preserve" (default), "strip" to forward "/x/" as "/x",
+	// or "redirect" to answer "/x/" with a 308 to "/x"
+	TrailingSlash string
+	// MaxBackendConns overrides ProxyConfig.MaxBackendConns (0 = inherit, <0 = unlimited)
+	MaxBackendConns int
+}
+
+// UpstreamAuth holds static credentials the gateway presents to a backend
+type UpstreamAuth struct {
+	Type     string // "bearer" | "basic" (empty = pass the client's header through)
+	Token    string
+	Username string
+	Password string
 }
 
 func (s *ServiceConfig) GetBackends() []BackendConfig {
@@ -87,28 +341,153 @@ func (s *ServiceConfig) GetStrategy() string {
 	return s.Strategy
 }
 
-func Load() *Config {
-	return &Config{
+func (s *ServiceConfig) GetHealthCheckMethod() string {
+	if s.HealthCheckMethod == "" {
+		return "GET"
+	}
+	return strings.ToUpper(s.HealthCheckMethod)
+}
+
+// AllServices returns the configured services followed by the default service, if any
+func (c *Config) AllServices() []ServiceConfig {
+	if c.Proxy.DefaultService == nil {
+		return c.Services
+	}
+	services := make([]ServiceConfig, 0, len(c.Services)+1)
+	services = append(services, c.Services...)
+	return append(services, *c.Proxy.DefaultService)
+}
+
+const redactedValue = "[REDACTED]"
+
+// Redacted returns a copy of the config that is safe to expose: passwords are
+// masked and credentials embedded in backend URLs are hidden
+func (c *Config) Redacted() *Config {
+	out := *c
+	out.Redis.Password = redactSecret(c.Redis.Password)
+	out.Admin.Password = redactSecret(c.Admin.Password)
+
+	out.Services = make([]ServiceConfig, len(c.Services))
+	for i, svc := range c.Services {
+		out.Services[i] = svc.redacted()
+	}
+	if c.Proxy.DefaultService != nil {
+		svc := c.Proxy.DefaultService.redacted()
+		out.Proxy.DefaultService = &svc
+	}
+	return &out
+}
+
+func (s ServiceConfig) redacted() ServiceConfig {
+	s.TargetURL = redactURL(s.TargetURL)
+	s.UpstreamAuth.Token = redactSecret(s.UpstreamAuth.Token)
+	s.UpstreamAuth.Password = redactSecret(s.UpstreamAuth.Password)
+	if s.VersionBackends != nil {
+		variants := make(map[string]string, len(s.VersionBackends))
+		for value, u := range s.VersionBackends {
+			variants[value] = redactURL(u)
+		}
+		s.VersionBackends = variants
+	}
+	if s.Backends != nil {
+		backends := make([]BackendConfig, len(s.Backends))
+		for i, b := range s.Backends {
+			backends[i] = BackendConfig{URL: redactURL(b.URL), Weight: b.Weight}
+		}
+		s.Backends = backends
+	}
+	return s
+}
+
+// redactSecret masks non-empty values so an unset secret stays visibly unset
+func redactSecret(v string) string {
+	if v == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// Load reads the configuration from the environment, taking secrets from
+// DefaultSecretSources
+func Load() (*Config, error) {
+	return LoadWithSecrets(DefaultSecretSources...)
+}
+
+// LoadWithSecrets is Load with passwords and tokens looked up in sources, in
+// order, e.g. to add a vault client behind the environment
+func LoadWithSecrets(sources ...SecretSource) (*Config, error) {
+	secrets := &secretResolver{sources: sources}
+	cfg := &Config{
 		Server: ServerConfig{
 			Host: getEnv("HOST", "0.0.0.0"),
 			Port: getEnv("PORT", "8081"),
+
+			TLSCertFile:      getEnv("TLS_CERT_FILE", ""),
+			TLSKeyFile:       getEnv("TLS_KEY_FILE", ""),
+			HTTPRedirectPort: getEnv("TLS_HTTP_REDIRECT_PORT", ""),
+			H2C:              getEnvBool("H2C_ENABLED", false),
+			ProxyProtocol:    getEnvBool("PROXY_PROTOCOL_ENABLED", false),
+
+			MaxConcurrentRequests: getEnvInt("MAX_CONCURRENT_REQUESTS", 0),
+			MaxHeaderBytes:        getEnvInt("MAX_HEADER_BYTES", 32768),
 		},
 		Redis: RedisConfig{
+			Mode:     getEnv("REDIS_MODE", "standalone"),
 			Host:     getEnv("REDIS_HOST", "localhost"),
 			Port:     getEnv("REDIS_PORT", "6379"),
-			Password: getEnv("REDIS_PASSWORD", ""),
+			Password: secrets.get("REDIS_PASSWORD", ""),
 			DB:       getEnvInt("REDIS_DB", 0),
+
+			SentinelAddrs:  getEnvList("REDIS_SENTINEL_ADDRS"),
+			SentinelMaster: getEnv("REDIS_SENTINEL_MASTER", "mymaster"),
+			ClusterAddrs:   getEnvList("REDIS_CLUSTER_ADDRS"),
+
+			PoolSize:     getEnvInt("REDIS_POOL_SIZE", 0),
+			MinIdleConns: getEnvInt("REDIS_MIN_IDLE_CONNS", 0),
+			DialTimeout:  time.Duration(getEnvInt("REDIS_DIAL_TIMEOUT_MS", 0)) * time.Millisecond,
+			ReadTimeout:  time.Duration(getEnvInt("REDIS_READ_TIMEOUT_MS", 0)) * time.Millisecond,
 		},
 		RateLimit: RateLimitConfig{
 			RequestsPerMinute: getEnvInt("RATE_LIMIT_RPM", 60),
 			BurstSize:         getEnvInt("RATE_LIMIT_BURST", 10),
 			WindowDuration:    time.Minute,
+			WarnThreshold:     getEnvFloat("RATE_LIMIT_WARN_THRESHOLD", 0.1),
+			AnonymousRPM:      getEnvInt("ANONYMOUS_RATE_LIMIT_RPM", 0),
+			AuthenticatedRPM:  getEnvInt("AUTHENTICATED_RATE_LIMIT_RPM", 0),
+			ExemptPaths:       getEnvListOr("RATE_LIMIT_EXEMPT_PATHS", []string{"/health", "/metrics"}),
+			Algorithm:         getEnv("RATE_LIMIT_ALGORITHM", "token-bucket"),
+		},
+		Admission: AdmissionConfig{
+			Enabled:      getEnvBool("ADMISSION_ENABLED", false),
+			MaxInFlight:  getEnvInt("ADMISSION_MAX_IN_FLIGHT", 100),
+			MaxQueue:     getEnvInt("ADMISSION_MAX_QUEUE", 50),
+			QueueTimeout: time.Duration(getEnvInt("ADMISSION_QUEUE_TIMEOUT_MS", 1000)) * time.Millisecond,
+		},
+		APIKey: APIKeyConfig{
+			Required:      getEnvBool("API_KEY_REQUIRED", false),
+			ExemptPaths:   getEnvList("API_KEY_EXEMPT_PATHS"),
+			RequiredPaths: getEnvList("API_KEY_REQUIRED_PATHS"),
+
+			FallbackEnabled: getEnvBool("API_KEY_FALLBACK_ENABLED", false),
+			FallbackTTL:     time.Duration(getEnvInt("API_KEY_FALLBACK_TTL_SECONDS", 60)) * time.Second,
 		},
 		CircuitBreaker: CircuitBreakerConfig{
-			MaxFailures:         getEnvInt("CB_MAX_FAILURES", 5),
-			ResetTimeoutSeconds: getEnvInt("CB_RESET_TIMEOUT_SECONDS", 30),
-			HalfOpenMaxRequests: getEnvInt("CB_HALF_OPEN_MAX_REQUESTS", 3),
-			SuccessThreshold:    getEnvInt("CB_SUCCESS_THRESHOLD", 2),
+			MaxFailures:            getEnvInt("CB_MAX_FAILURES", 5),
+			ResetTimeoutSeconds:    getEnvInt("CB_RESET_TIMEOUT_SECONDS", 30),
+			HalfOpenMaxRequests:    getEnvInt("CB_HALF_OPEN_MAX_REQUESTS", 3),
+			SuccessThreshold:       getEnvInt("CB_SUCCESS_THRESHOLD", 2),
+			SingleProbe:            getEnvBool("CB_SINGLE_PROBE", false),
+			ResetBackoffMultiplier: getEnvFloat("CB_RESET_BACKOFF_MULTIPLIER", 1),
+			ResetTimeoutMaxSeconds: getEnvInt("CB_RESET_TIMEOUT_MAX_SECONDS", 0),
+			WarmUpSeconds:          getEnvInt("CB_WARMUP_SECONDS", 0),
 		},
 		Retry: RetryConfig{
 			MaxRetries:     getEnvInt("RETRY_MAX_RETRIES", 3),
@@ -116,36 +495,145 @@ func Load() *Config {
 			MaxDelayMs:     getEnvInt("RETRY_MAX_DELAY_MS", 5000),
 			Multiplier:     getEnvFloat("RETRY_MULTIPLIER", 2.0),
 			JitterFactor:   getEnvFloat("RETRY_JITTER_FACTOR", 0.1),
+			JitterMode:     getEnv("RETRY_JITTER_MODE", "symmetric"),
+
+			RetryOnTooEarly: getEnvBool("RETRY_ON_TOO_EARLY", true),
 		},
 		Admin: AdminConfig{
-			Username: getEnv("ADMIN_USERNAME", "admin"),
-			Password: getEnv("ADMIN_PASSWORD", ""),
-			Enabled:  getEnvBool("ADMIN_AUTH_ENABLED", true),
+			Username:  getEnv("ADMIN_USERNAME", "admin"),
+			Password:  secrets.get("ADMIN_PASSWORD", ""),
+			Enabled:   getEnvBool("ADMIN_AUTH_ENABLED", true),
+			UsersFile: getEnv("ADMIN_USERS_FILE", ""),
+
+			AuditRedis:      getEnvBool("ADMIN_AUDIT_REDIS", false),
+			AuditMaxEntries: getEnvInt("ADMIN_AUDIT_MAX_ENTRIES", 10000),
 		},
-		Services: loadServicesFromEnv(),
-	}
-}
+		Health: HealthConfig{
+			CheckRedis:         getEnvBool("HEALTH_CHECK_REDIS", false),
+			RedisRequired:      getEnvBool("HEALTH_REDIS_REQUIRED", false),
+			RedisTimeout:       time.Duration(getEnvInt("HEALTH_REDIS_TIMEOUT_MS", 500)) * time.Millisecond,
+			HealthyThreshold:   getEnvInt("HEALTH_CHECK_HEALTHY_THRESHOLD", 1),
+			UnhealthyThreshold: getEnvInt("HEALTH_CHECK_UNHEALTHY_THRESHOLD", 1),
+			DegradedThreshold:  time.Duration(getEnvInt("HEALTH_CHECK_DEGRADED_MS", 0)) * time.Millisecond,
+		},
+		Logging: LoggingConfig{
+			Level:         getEnv("LOG_LEVEL", "info"),
+			LogBodies:     getEnvBool("LOG_BODIES", false),
+			MaxBodyBytes:  getEnvInt("LOG_MAX_BODY_BYTES", 4096),
+			RedactHeaders: getEnvList("LOG_REDACT_HEADERS"),
+
+			BodySampleRate: getEnvFloat("LOG_BODY_SAMPLE_RATE", 0),
+			BodyErrorsOnly: getEnvBool("LOG_BODY_ERRORS_ONLY", false),
 
-func loadServicesFromEnv() []ServiceConfig {
-	services := []ServiceConfig{
-		{
-			Name:       "auth-service",
-			PathPrefix: "/api/auth",
-			TargetURL:  getEnv("AUTH_SERVICE_URL", "http://localhost:8080"),
-			Backends:   parseBackendsEnv("AUTH_SERVICE_BACKENDS"),
-			Strategy:   getEnv("AUTH_SERVICE_STRATEGY", "round-robin"),
-			StripPath:  false,
+			SlowRequestThreshold: time.Duration(getEnvInt("LOG_SLOW_REQUEST_MS", 0)) * time.Millisecond,
+			SampleRate:           getEnvFloat("LOG_SAMPLE_RATE", 0),
+			Format:               getEnv("LOG_FORMAT", "json"),
+		},
+		Metrics: MetricsConfig{
+			RoutePatterns:  getEnvList("METRICS_ROUTE_PATTERNS"),
+			StandardFormat: getEnvBool("METRICS_STANDARD_FORMAT", false),
 		},
-		{
-			Name:       "user-service",
-			PathPrefix: "/api/users",
-			TargetURL:  getEnv("USER_SERVICE_URL", "http://localhost:8082"),
-			Backends:   parseBackendsEnv("USER_SERVICE_BACKENDS"),
-			Strategy:   getEnv("USER_SERVICE_STRATEGY", "round-robin"),
-			StripPath:  false,
+		Middleware: MiddlewareConfig{
+			CORS:      getEnvBool("MIDDLEWARE_CORS_ENABLED", true),
+			Metrics:   getEnvBool("MIDDLEWARE_METRICS_ENABLED", true),
+			Logging:   getEnvBool("MIDDLEWARE_LOGGING_ENABLED", true),
+			RateLimit: getEnvBool("MIDDLEWARE_RATE_LIMIT_ENABLED", true),
 		},
+		Proxy: ProxyConfig{
+			DefaultService:      loadDefaultServiceFromEnv(secrets),
+			LoadBalanceStrategy: getEnv("LB_STRATEGY", "round-robin"),
+			SlowStart:           time.Duration(getEnvInt("LB_SLOW_START_SECONDS", 0)) * time.Second,
+
+			DisableDiagnosticHeaders: getEnvBool("PROXY_DISABLE_DIAGNOSTIC_HEADERS", false),
+			ExposeCircuitState:       getEnvBool("PROXY_EXPOSE_CIRCUIT_STATE", false),
+
+			BodyBufferThreshold: int64(getEnvInt("PROXY_BODY_BUFFER_BYTES", 4<<20)),
+			BodySpillDir:        getEnv("PROXY_BODY_SPILL_DIR", ""),
+			MaxResponseBytes:    int64(getEnvInt("PROXY_MAX_RESPONSE_BYTES", 0)),
+
+			WarmUp:            getEnvBool("PROXY_WARMUP_ENABLED", false),
+			WarmUpConnections: getEnvInt("PROXY_WARMUP_CONNECTIONS", 2),
+			WarmUpTimeout:     time.Duration(getEnvInt("PROXY_WARMUP_TIMEOUT_MS", 5000)) * time.Millisecond,
+
+			ErrorFormat:      getEnv("PROXY_ERROR_FORMAT", "json"),
+			ErrorTemplate:    os.Getenv("PROXY_ERROR_TEMPLATE"),
+			ErrorContentType: getEnv("PROXY_ERROR_CONTENT_TYPE", "application/json"),
+
+			MaxBackendConns: getEnvInt("PROXY_MAX_BACKEND_CONNS", 0),
+			BackendConnWait: time.Duration(getEnvInt("PROXY_BACKEND_CONN_WAIT_MS", 100)) * time.Millisecond,
+		},
+		Services: loadServicesFromEnv(secrets),
+	}
+	if err := secrets.err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func loadServicesFromEnv(secrets *secretResolver) []ServiceConfig {
+	return []ServiceConfig{
+		loadServiceFromEnv("AUTH_SERVICE", "auth-service", "/api/auth", "http://localhost:8080", secrets),
+		loadServiceFromEnv("USER_SERVICE", "user-service", "/api/users", "http://localhost:8082", secrets),
+	}
+}
+
+// loadServiceFromEnv reads the <prefix>_* settings of one service, e.g.
+// USER_SERVICE_URL, falling back to defaultURL when <prefix>_URL is unset
+func loadServiceFromEnv(prefix, name, pathPrefix, defaultURL string, secrets *secretResolver) ServiceConfig {
+	env := prefix + "_"
+	return ServiceConfig{
+		Name:                name,
+		PathPrefix:          pathPrefix,
+		TargetURL:           getEnv(env+"URL", defaultURL),
+		Backends:            parseBackendsEnv(env + "BACKENDS"),
+		Strategy:            getEnv(env+"STRATEGY", ""),
+		StripPath:           false,
+		AllowedContentTypes: getEnvList(env + "CONTENT_TYPES"),
+		RewriteRedirects:    getEnvBool(env+"REWRITE_REDIRECTS", false),
+		InjectFields:        getEnvMap(env + "INJECT_FIELDS"),
+		DisableRetry:        getEnvBool(env+"DISABLE_RETRY", false),
+		MaxResponseBytes:    int64(getEnvInt(env+"MAX_RESPONSE_BYTES", 0)),
+		UpstreamAuth:        loadUpstreamAuth(prefix, secrets),
+		VersionParam:        os.Getenv(env + "VERSION_PARAM"),
+		VersionBackends:     getEnvMap(env + "VERSION_BACKENDS"),
+		CORSAllowedOrigins:  getEnvList(env + "CORS_ORIGINS"),
+		CBWarmUpSeconds:     getEnvInt(env+"CB_WARMUP_SECONDS", 0),
+		AllowedPaths:        getEnvList(env + "ALLOWED_PATHS"),
+		BlockedPaths:        getEnvList(env + "BLOCKED_PATHS"),
+		AllowedMethods:      getEnvList(env + "ALLOWED_METHODS"),
+		RetryOnBodyMatch:    os.Getenv(env + "RETRY_ON_BODY_MATCH"),
+		RetryOnBodyStatus:   getEnvInt(env+"RETRY_ON_BODY_STATUS", 0),
+		ServeStale:          getEnvBool(env+"SERVE_STALE", false),
+		NormalizePath:       getEnvBool(env+"NORMALIZE_PATH", false),
+		LowercasePath:       getEnvBool(env+"LOWERCASE_PATH", false),
+		GRPCWeb:             getEnvBool(env+"GRPC_WEB", false),
+		HealthCheckMethod:   os.Getenv(env + "HEALTH_CHECK_METHOD"),
+		HostHeader:          os.Getenv(env + "HOST_HEADER"),
+		PreserveHost:        getEnvBool(env+"PRESERVE_HOST", false),
+		NegotiateEncoding:   getEnvBool(env+"NEGOTIATE_ENCODING", false),
+		TrailingSlash:       getEnv(env+"TRAILING_SLASH", "preserve"),
+		MaxBackendConns:     getEnvInt(env+"MAX_BACKEND_CONNS", 0),
+	}
+}
+
+// loadUpstreamAuth reads <prefix>_UPSTREAM_AUTH_TYPE and its credentials
+func loadUpstreamAuth(prefix string, secrets *secretResolver) UpstreamAuth {
+	return UpstreamAuth{
+		Type:     strings.ToLower(os.Getenv(prefix + "_UPSTREAM_AUTH_TYPE")),
+		Token:    secrets.get(prefix+"_UPSTREAM_AUTH_TOKEN", ""),
+		Username: os.Getenv(prefix + "_UPSTREAM_AUTH_USERNAME"),
+		Password: secrets.get(prefix+"_UPSTREAM_AUTH_PASSWORD", ""),
 	}
-	return services
+}
+
+// loadDefaultServiceFromEnv builds the catch-all service for unmatched paths.
+// Returns nil when neither DEFAULT_SERVICE_URL nor DEFAULT_SERVICE_BACKENDS is set.
+func loadDefaultServiceFromEnv(secrets *secretResolver) *ServiceConfig {
+	svc := loadServiceFromEnv("DEFAULT_SERVICE", getEnv("DEFAULT_SERVICE_NAME", "default-service"), "/", "", secrets)
+	if len(svc.GetBackends()) == 0 {
+		return nil
+	}
+	return &svc
 }
 
 // parseBackendsEnv parses comma-separated backend URLs from environment variable
@@ -202,6 +690,50 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvList parses a comma-separated environment variable, skipping empty entries
+func getEnvList(key string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+
+	parts := strings.Split(value, ",")
+	result := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+// getEnvListOr is getEnvList with a default for an unset variable; set but
+// empty, it yields an empty list
+func getEnvListOr(key string, defaultValue []string) []string {
+	if _, ok := os.LookupEnv(key); !ok {
+		return defaultValue
+	}
+	return getEnvList(key)
+}
+
+// getEnvMap parses comma-separated key=value pairs, e.g. "tenant=acme,source=gateway"
+func getEnvMap(key string) map[string]string {
+	pairs := getEnvList(key)
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	result := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		k, v, ok := strings.Cut(pair, "=")
+		if k = strings.TrimSpace(k); !ok || k == "" {
+			continue
+		}
+		result[k] = strings.TrimSpace(v)
+	}
+	return result
+}
+
 func getEnvInt(key string, defaultValue int) int {
 	if value := os.Getenv(key); value != "" {
 		if intValue, err := strconv.Atoi(value); err == nil {
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeSecret(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSecretFromFile(t *testing.T) {
+	t.Setenv("ADMIN_PASSWORD", "")
+	t.Setenv("ADMIN_PASSWORD_FILE", writeSecret(t, "from-file\n"))
+	t.Setenv("USER_SERVICE_UPSTREAM_AUTH_TOKEN", "")
+	t.Setenv("USER_SERVICE_UPSTREAM_AUTH_TOKEN_FILE", writeSecret(t, "token"))
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Admin.Password != "from-file" {
+		t.Errorf("Admin.Password = %q, want %q", cfg.Admin.Password, "from-file")
+	}
+	for _, svc := range cfg.Services {
+		if svc.Name == "user-service" && svc.UpstreamAuth.Token != "token" {
+			t.Errorf("user-service upstream token = %q, want %q", svc.UpstreamAuth.Token, "token")
+		}
+	}
+}
+
+func TestLoadSecretPrecedence(t *testing.T) {
+	file := writeSecret(t, "from-file")
+
+	tests := []struct {
+		name string
+		env  string
+		file string
+		want string
+	}{
+		{"env wins over file", "from-env", file, "from-env"},
+		{"file when env unset", "", file, "from-file"},
+		{"default when neither", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("REDIS_PASSWORD", tt.env)
+			t.Setenv("REDIS_PASSWORD_FILE", tt.file)
+
+			cfg, err := Load()
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if cfg.Redis.Password != tt.want {
+				t.Errorf("Redis.Password = %q, want %q", cfg.Redis.Password, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadUnreadableSecretFile(t *testing.T) {
+	t.Setenv("ADMIN_PASSWORD", "")
+	t.Setenv("ADMIN_PASSWORD_FILE", filepath.Join(t.TempDir(), "missing"))
+
+	if _, err := Load(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load() error = %v, want a missing file error", err)
+	}
+}
+
+type staticSecrets map[string]string
+
+func (s staticSecrets) Secret(name string) (string, bool, error) {
+	value, ok := s[name]
+	return value, ok, nil
+}
+
+func TestLoadWithSecretsCustomSource(t *testing.T) {
+	t.Setenv("ADMIN_PASSWORD", "from-env")
+	t.Setenv("REDIS_PASSWORD", "")
+
+	cfg, err := LoadWithSecrets(EnvSecrets{}, staticSecrets{"ADMIN_PASSWORD": "from-vault", "REDIS_PASSWORD": "from-vault"})
+	if err != nil {
+		t.Fatalf("LoadWithSecrets() error = %v", err)
+	}
+	if cfg.Admin.Password != "from-env" {
+		t.Errorf("Admin.Password = %q, want the environment to win", cfg.Admin.Password)
+	}
+	if cfg.Redis.Password != "from-vault" {
+		t.Errorf("Redis.Password = %q, want %q", cfg.Redis.Password, "from-vault")
+	}
+}
+
+func TestLoadServicesFromEnvPrefixes(t *testing.T) {
+	for _, prefix := range []string{"AUTH_SERVICE", "USER_SERVICE", "DEFAULT_SERVICE"} {
+		t.Setenv(prefix+"_URL", "http://"+prefix+".internal")
+		t.Setenv(prefix+"_HOST_HEADER", prefix+".example.com")
+		t.Setenv(prefix+"_MAX_BACKEND_CONNS", "7")
+	}
+	t.Setenv("DEFAULT_SERVICE_NAME", "web")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Proxy.DefaultService == nil {
+		t.Fatal("default service not loaded")
+	}
+
+	want := map[string]string{"auth-service": "AUTH_SERVICE", "user-service": "USER_SERVICE", "web": "DEFAULT_SERVICE"}
+	for _, svc := range cfg.AllServices() {
+		prefix, ok := want[svc.Name]
+		if !ok {
+			t.Errorf("unexpected service %q", svc.Name)
+			continue
+		}
+		delete(want, svc.Name)
+		if svc.TargetURL != "http://"+prefix+".internal" || svc.HostHeader != prefix+".example.com" || svc.MaxBackendConns != 7 {
+			t.Errorf("%s = {TargetURL: %q, HostHeader: %q, MaxBackendConns: %d}, want %s_* settings",
+				svc.Name, svc.TargetURL, svc.HostHeader, svc.MaxBackendConns, prefix)
+		}
+	}
+	for name := range want {
+		t.Errorf("service %q missing", name)
+	}
+}
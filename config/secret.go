@@ -0,0 +1,67 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SecretSource looks up a secret by its environment variable name, e.g.
+// ADMIN_PASSWORD. found is false when the source has no value for it.
+type SecretSource interface {
+	Secret(name string) (value string, found bool, err error)
+}
+
+// EnvSecrets reads secrets from the environment variable itself
+type EnvSecrets struct{}
+
+func (EnvSecrets) Secret(name string) (string, bool, error) {
+	value := os.Getenv(name)
+	return value, value != "", nil
+}
+
+// FileSecrets reads secrets from the file named by <name>_FILE, the
+// Docker/Kubernetes secrets convention. A trailing newline is dropped.
+type FileSecrets struct{}
+
+func (FileSecrets) Secret(name string) (string, bool, error) {
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return "", false, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+// DefaultSecretSources are used by Load: an explicitly set variable wins
+// over its _FILE variant
+var DefaultSecretSources = []SecretSource{EnvSecrets{}, FileSecrets{}}
+
+// secretResolver asks each source in turn, collecting lookup errors so Load
+// can report all of them at once
+type secretResolver struct {
+	sources []SecretSource
+	errs    []error
+}
+
+func (r *secretResolver) get(name, defaultValue string) string {
+	for _, source := range r.sources {
+		value, found, err := source.Secret(name)
+		if err != nil {
+			r.errs = append(r.errs, err)
+			return defaultValue
+		}
+		if found {
+			return value
+		}
+	}
+	return defaultValue
+}
+
+func (r *secretResolver) err() error {
+	return errors.Join(r.errs...)
+}
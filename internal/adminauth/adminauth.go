@@ -0,0 +1,171 @@
+package adminauth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Role string
+
+const (
+	// RoleAdmin can read and mutate every admin resource
+	RoleAdmin Role = "admin"
+	// RoleReadOnly can only issue safe (GET/HEAD/OPTIONS) admin requests
+	RoleReadOnly Role = "readonly"
+)
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	return r == RoleAdmin || r == RoleReadOnly
+}
+
+// Allows reports whether the role may issue a request with the given method
+func (r Role) Allows(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return r.Valid()
+	default:
+		return r == RoleAdmin
+	}
+}
+
+type User struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"` // produced by HashPassword
+	Role         Role   `json:"role"`
+}
+
+type usersFile struct {
+	Users []User `json:"users"`
+}
+
+// Store authenticates admin users against their password hashes
+type Store struct {
+	users map[string]User
+	dummy string // compared against for unknown users to keep timing uniform
+}
+
+func NewStore(users []User) (*Store, error) {
+	store := &Store{
+		users: make(map[string]User, len(users)),
+		dummy: HashPassword(""),
+	}
+
+	for _, u := range users {
+		if u.Username == "" {
+			return nil, fmt.Errorf("admin user without username")
+		}
+		if !u.Role.Valid() {
+			return nil, fmt.Errorf("admin user %q has unknown role %q", u.Username, u.Role)
+		}
+		if _, _, _, err := parseHash(u.PasswordHash); err != nil {
+			return nil, fmt.Errorf("admin user %q: %w", u.Username, err)
+		}
+		if _, dup := store.users[u.Username]; dup {
+			return nil, fmt.Errorf("duplicate admin user %q", u.Username)
+		}
+		store.users[u.Username] = u
+	}
+
+	if len(store.users) == 0 {
+		return nil, fmt.Errorf("no admin users configured")
+	}
+	return store, nil
+}
+
+// LoadStore reads admin users from a JSON file of the form
+// {"users":[{"username":"ops","password_hash":"pbkdf2-sha256$...","role":"readonly"}]}
+func LoadStore(path string) (*Store, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read admin users file: %w", err)
+	}
+
+	var f usersFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse admin users file: %w", err)
+	}
+	return NewStore(f.Users)
+}
+
+// Authenticate returns the user's role when the credentials match
+func (s *Store) Authenticate(username, password string) (Role, bool) {
+	user, ok := s.users[username]
+	hash := user.PasswordHash
+	if !ok {
+		hash = s.dummy
+	}
+
+	if !VerifyPassword(hash, password) || !ok {
+		return "", false
+	}
+	return user.Role, true
+}
+
+const (
+	hashScheme       = "pbkdf2-sha256"
+	hashIterations   = 100000
+	hashSaltBytes    = 16
+	hashDerivedBytes = 32
+)
+
+// HashPassword returns a salted PBKDF2-SHA256 hash in the form
+// pbkdf2-sha256$<iterations>$<salt>$<key> (base64, unpadded)
+func HashPassword(password string) string {
+	return hashWithIterations(password, hashIterations)
+}
+
+func hashWithIterations(password string, iterations int) string {
+	salt := make([]byte, hashSaltBytes)
+	rand.Read(salt)
+
+	// Cannot fail: the key length is within the allowed range
+	key, _ := pbkdf2.Key(sha256.New, password, salt, iterations, hashDerivedBytes)
+
+	enc := base64.RawStdEncoding
+	return strings.Join([]string{hashScheme, strconv.Itoa(iterations), enc.EncodeToString(salt), enc.EncodeToString(key)}, "$")
+}
+
+// VerifyPassword reports whether password matches hash in constant time
+func VerifyPassword(hash, password string) bool {
+	iterations, salt, want, err := parseHash(hash)
+	if err != nil {
+		return false
+	}
+
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+func parseHash(hash string) (iterations int, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != hashScheme {
+		return 0, nil, nil, fmt.Errorf("unsupported password hash format")
+	}
+
+	iterations, err = strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return 0, nil, nil, fmt.Errorf("invalid password hash iterations")
+	}
+
+	enc := base64.RawStdEncoding
+	if salt, err = enc.DecodeString(parts[2]); err != nil {
+		return 0, nil, nil, fmt.Errorf("invalid password hash salt")
+	}
+	if key, err = enc.DecodeString(parts[3]); err != nil || len(key) == 0 {
+		return 0, nil, nil, fmt.Errorf("invalid password hash key")
+	}
+	return iterations, salt, key, nil
+}
@@ -0,0 +1,98 @@
+package adminauth
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHashAndVerifyPassword(t *testing.T) {
+	hash := hashWithIterations("s3cret", 1000)
+
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$1000$") {
+		t.Errorf("hash = %q, want pbkdf2-sha256$1000$ prefix", hash)
+	}
+	if strings.Contains(hash, "s3cret") {
+		t.Error("hash contains the plaintext password")
+	}
+	if !VerifyPassword(hash, "s3cret") {
+		t.Error("expected password to verify")
+	}
+	if VerifyPassword(hash, "wrong") {
+		t.Error("expected wrong password to fail")
+	}
+	if hashWithIterations("s3cret", 1000) == hash {
+		t.Error("expected different salts for repeated hashes")
+	}
+	if VerifyPassword("plaintext", "plaintext") {
+		t.Error("expected malformed hash to fail")
+	}
+}
+
+func TestLoadStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admins.json")
+	content := `{"users":[
+		{"username":"root","password_hash":"` + hashWithIterations("rootpw", 1000) + `","role":"admin"},
+		{"username":"viewer","password_hash":"` + hashWithIterations("viewpw", 1000) + `","role":"readonly"}
+	]}`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := LoadStore(path)
+	if err != nil {
+		t.Fatalf("LoadStore() error = %v", err)
+	}
+
+	tests := []struct {
+		username, password string
+		wantRole           Role
+		wantOK             bool
+	}{
+		{"root", "rootpw", RoleAdmin, true},
+		{"viewer", "viewpw", RoleReadOnly, true},
+		{"viewer", "rootpw", "", false},
+		{"nobody", "rootpw", "", false},
+	}
+	for _, tt := range tests {
+		role, ok := store.Authenticate(tt.username, tt.password)
+		if role != tt.wantRole || ok != tt.wantOK {
+			t.Errorf("Authenticate(%q) = (%q, %v), want (%q, %v)", tt.username, role, ok, tt.wantRole, tt.wantOK)
+		}
+	}
+}
+
+func TestNewStoreRejectsInvalidUsers(t *testing.T) {
+	valid := hashWithIterations("pw", 1000)
+
+	tests := map[string][]User{
+		"empty":          nil,
+		"no username":    {{PasswordHash: valid, Role: RoleAdmin}},
+		"unknown role":   {{Username: "a", PasswordHash: valid, Role: "superuser"}},
+		"plaintext hash": {{Username: "a", PasswordHash: "pw", Role: RoleAdmin}},
+		"duplicate":      {{Username: "a", PasswordHash: valid, Role: RoleAdmin}, {Username: "a", PasswordHash: valid, Role: RoleReadOnly}},
+	}
+	for name, users := range tests {
+		if _, err := NewStore(users); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestRoleAllows(t *testing.T) {
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodOptions} {
+		if !RoleReadOnly.Allows(method) {
+			t.Errorf("readonly should allow %s", method)
+		}
+	}
+	for _, method := range []string{http.MethodPost, http.MethodPatch, http.MethodPut, http.MethodDelete} {
+		if RoleReadOnly.Allows(method) {
+			t.Errorf("readonly should not allow %s", method)
+		}
+		if !RoleAdmin.Allows(method) {
+			t.Errorf("admin should allow %s", method)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package admission
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrShed is returned when a request loses its place to higher-priority traffic
+	ErrShed = errors.New("request shed under load")
+	// ErrTimeout is returned when a queued request isn't admitted in time
+	ErrTimeout = errors.New("timed out waiting for admission")
+)
+
+type Config struct {
+	// MaxInFlight is the number of concurrent requests admitted without queueing
+	MaxInFlight int
+	// MaxQueue bounds the number of waiting requests (0 = shed immediately when saturated)
+	MaxQueue int
+	// QueueTimeout bounds how long a request waits for a slot
+	QueueTimeout time.Duration
+}
+
+// Controller admits requests up to MaxInFlight and, once saturated, hands freed
+// slots to the highest-priority waiter first
+type Controller struct {
+	config Config
+
+	mu       sync.Mutex
+	inFlight int
+	waiters  waiterQueue
+	seq      uint64
+}
+
+func New(config Config) *Controller {
+	if config.MaxInFlight <= 0 {
+		config.MaxInFlight = 1
+	}
+	if config.MaxQueue < 0 {
+		config.MaxQueue = 0
+	}
+	if config.QueueTimeout <= 0 {
+		config.QueueTimeout = time.Second
+	}
+	return &Controller{config: config}
+}
+
+// Acquire waits for a slot and returns a func that releases it.
+// Higher priority values are admitted first.
+func (c *Controller) Acquire(ctx context.Context, priority int) (func(), error) {
+	c.mu.Lock()
+
+	if c.inFlight < c.config.MaxInFlight && len(c.waiters) == 0 {
+		c.inFlight++
+		c.mu.Unlock()
+		return c.release, nil
+	}
+
+	// Queue full: displace the lowest-priority waiter if this request outranks it
+	if len(c.waiters) >= c.config.MaxQueue {
+		lowest := c.waiters.lowest()
+		if lowest == nil || lowest.priority >= priority {
+			c.mu.Unlock()
+			return nil, ErrShed
+		}
+		c.remove(lowest)
+		lowest.result <- ErrShed
+	}
+
+	c.seq++
+	w := &waiter{priority: priority, seq: c.seq, result: make(chan error, 1)}
+	heap.Push(&c.waiters, w)
+	c.mu.Unlock()
+
+	timer := time.NewTimer(c.config.QueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-w.result:
+		if err != nil {
+			return nil, err
+		}
+		return c.release, nil
+	case <-timer.C:
+		return c.abandon(w, ErrTimeout)
+	case <-ctx.Done():
+		return c.abandon(w, ctx.Err())
+	}
+}
+
+// abandon removes w from the queue, unless it was admitted or shed concurrently
+func (c *Controller) abandon(w *waiter, reason error) (func(), error) {
+	c.mu.Lock()
+	if w.index >= 0 {
+		c.remove(w)
+		c.mu.Unlock()
+		return nil, reason
+	}
+	c.mu.Unlock()
+
+	// Already decided; honour the outcome but give back a granted slot
+	if err := <-w.result; err != nil {
+		return nil, err
+	}
+	c.release()
+	return nil, reason
+}
+
+// release hands the slot to the best waiter, or frees it
+func (c *Controller) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.waiters) > 0 {
+		next := heap.Pop(&c.waiters).(*waiter)
+		next.result <- nil
+		return
+	}
+	c.inFlight--
+}
+
+func (c *Controller) remove(w *waiter) {
+	heap.Remove(&c.waiters, w.index)
+}
+
+// Stats reports current in-flight and queued request counts
+func (c *Controller) Stats() (inFlight, queued int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.inFlight, len(c.waiters)
+}
+
+type waiter struct {
+	priority int
+	seq      uint64 // FIFO among equal priorities
+	result   chan error
+	index    int // position in the heap, -1 once removed
+}
+
+// waiterQueue is a max-heap on priority, oldest first among equals
+type waiterQueue []*waiter
+
+func (q waiterQueue) Len() int { return len(q) }
+
+func (q waiterQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waiterQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waiterQueue) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waiterQueue) Pop() any {
+	old := *q
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	w.index = -1
+	*q = old[:n-1]
+	return w
+}
+
+// lowest returns the waiter that would be admitted last
+func (q waiterQueue) lowest() *waiter {
+	var worst *waiter
+	for _, w := range q {
+		if worst == nil || w.priority < worst.priority ||
+			(w.priority == worst.priority && w.seq > worst.seq) {
+			worst = w
+		}
+	}
+	return worst
+}
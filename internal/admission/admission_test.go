@@ -0,0 +1,148 @@
+package admission
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitQueued blocks until the controller has n queued requests
+func waitQueued(t *testing.T, c *Controller, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, queued := c.Stats(); queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	_, queued := c.Stats()
+	t.Fatalf("queued = %d, want %d", queued, n)
+}
+
+func TestAdmitsBelowThreshold(t *testing.T) {
+	c := New(Config{MaxInFlight: 2, MaxQueue: 0, QueueTimeout: time.Second})
+
+	r1, err := c.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("first Acquire() error = %v", err)
+	}
+	r2, err := c.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("second Acquire() error = %v", err)
+	}
+	if _, err := c.Acquire(context.Background(), 0); !errors.Is(err, ErrShed) {
+		t.Errorf("third Acquire() error = %v, want ErrShed", err)
+	}
+
+	r1()
+	r2()
+	if inFlight, _ := c.Stats(); inFlight != 0 {
+		t.Errorf("inFlight after release = %d, want 0", inFlight)
+	}
+}
+
+func TestHighPriorityAdmittedFirst(t *testing.T) {
+	c := New(Config{MaxInFlight: 1, MaxQueue: 4, QueueTimeout: time.Second})
+
+	release, err := c.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	order := make(chan int, 3)
+	start := func(priority int) {
+		go func() {
+			r, err := c.Acquire(context.Background(), priority)
+			if err != nil {
+				t.Errorf("Acquire(%d) error = %v", priority, err)
+				return
+			}
+			order <- priority
+			r()
+		}()
+	}
+
+	start(1)
+	waitQueued(t, c, 1)
+	start(1)
+	waitQueued(t, c, 2)
+	start(10)
+	waitQueued(t, c, 3)
+
+	release()
+
+	want := []int{10, 1, 1}
+	for i, w := range want {
+		select {
+		case got := <-order:
+			if got != w {
+				t.Errorf("admission %d priority = %d, want %d", i, got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("admission %d did not happen", i)
+		}
+	}
+}
+
+func TestLowPriorityShedWhenQueueFull(t *testing.T) {
+	c := New(Config{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: time.Second})
+
+	release, err := c.Acquire(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	lowErr := make(chan error, 1)
+	go func() {
+		r, err := c.Acquire(context.Background(), 0)
+		if r != nil {
+			r()
+		}
+		lowErr <- err
+	}()
+	waitQueued(t, c, 1)
+
+	// An equal-priority arrival cannot displace the waiter
+	if _, err := c.Acquire(context.Background(), 0); !errors.Is(err, ErrShed) {
+		t.Errorf("equal priority Acquire() error = %v, want ErrShed", err)
+	}
+
+	highDone := make(chan error, 1)
+	go func() {
+		r, err := c.Acquire(context.Background(), 5)
+		if r != nil {
+			r()
+		}
+		highDone <- err
+	}()
+
+	select {
+	case err := <-lowErr:
+		if !errors.Is(err, ErrShed) {
+			t.Errorf("low priority error = %v, want ErrShed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("low priority waiter was not shed")
+	}
+
+	release()
+	if err := <-highDone; err != nil {
+		t.Errorf("high priority error = %v, want admitted", err)
+	}
+}
+
+func TestQueueTimeout(t *testing.T) {
+	c := New(Config{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 20 * time.Millisecond})
+
+	release, _ := c.Acquire(context.Background(), 0)
+	defer release()
+
+	if _, err := c.Acquire(context.Background(), 0); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Acquire() error = %v, want ErrTimeout", err)
+	}
+	if _, queued := c.Stats(); queued != 0 {
+		t.Errorf("queued after timeout = %d, want 0", queued)
+	}
+}
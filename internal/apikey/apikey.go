@@ -6,50 +6,156 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/bimakw/api-gateway/internal/clock"
 	"github.com/redis/go-redis/v9"
 )
 
 type Manager struct {
-	client *redis.Client
+	client redis.UniversalClient
+	// fallback serves recently validated keys during Redis outages (nil = fail closed)
+	fallback *fallbackCache
 }
 
 type APIKey struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	KeyHash     string    `json:"key_hash"`
-	RateLimit   int       `json:"rate_limit"` // requests per minute, 0 = use default
-	Permissions []string  `json:"permissions"`
-	CreatedAt   time.Time `json:"created_at"`
-	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
-	Active      bool      `json:"active"`
+	ID              string     `json:"id"`
+	Name            string     `json:"name"`
+	KeyHash         string     `json:"key_hash"`
+	KeyPrefix       string     `json:"key_prefix,omitempty"` // label + first characters of the key, safe to display
+	RateLimit       int        `json:"rate_limit"`           // requests per minute, 0 = use default
+	Unlimited       bool       `json:"unlimited,omitempty"`  // bypasses rate limiting entirely
+	Priority        int        `json:"priority,omitempty"`   // admission priority under load, higher first
+	Plan            string     `json:"plan,omitempty"`       // free-form billing plan label, e.g. "free"
+	Permissions     []string   `json:"permissions"`
+	AllowedServices []string   `json:"allowed_services,omitempty"` // empty = all services
+	CreatedAt       time.Time  `json:"created_at"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+	Active          bool       `json:"active"`
 }
 
 type CreateKeyRequest struct {
-	Name        string     `json:"name"`
-	RateLimit   int        `json:"rate_limit,omitempty"`
-	Permissions []string   `json:"permissions,omitempty"`
-	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Name            string     `json:"name"`
+	Prefix          string     `json:"prefix,omitempty"` // optional label prepended to the raw key, e.g. "sk_live_"
+	RateLimit       int        `json:"rate_limit,omitempty"`
+	Unlimited       bool       `json:"unlimited,omitempty"`
+	Priority        int        `json:"priority,omitempty"`
+	Plan            string     `json:"plan,omitempty"`
+	Permissions     []string   `json:"permissions,omitempty"`
+	AllowedServices []string   `json:"allowed_services,omitempty"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+}
+
+// UpdateKeyRequest holds mutable key metadata; nil fields are left unchanged
+// and an explicit "expires_at": null (or "") removes the expiry
+type UpdateKeyRequest struct {
+	Name            *string    `json:"name,omitempty"`
+	RateLimit       *int       `json:"rate_limit,omitempty"`
+	Unlimited       *bool      `json:"unlimited,omitempty"`
+	Priority        *int       `json:"priority,omitempty"`
+	Plan            *string    `json:"plan,omitempty"`
+	Permissions     *[]string  `json:"permissions,omitempty"`
+	AllowedServices *[]string  `json:"allowed_services,omitempty"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+	ClearExpiresAt  bool       `json:"-"` // the key never expires; ExpiresAt is ignored
+}
+
+// UnmarshalJSON tells an absent expires_at apart from an explicit null or "",
+// which sets ClearExpiresAt
+func (r *UpdateKeyRequest) UnmarshalJSON(data []byte) error {
+	type plain UpdateKeyRequest
+	var raw struct {
+		*plain
+		ExpiresAt json.RawMessage `json:"expires_at"`
+	}
+	raw.plain = (*plain)(r)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	switch string(raw.ExpiresAt) {
+	case "":
+	case "null", `""`:
+		r.ExpiresAt = nil
+		r.ClearExpiresAt = true
+	default:
+		var expiresAt time.Time
+		if err := json.Unmarshal(raw.ExpiresAt, &expiresAt); err != nil {
+			return fmt.Errorf("expires_at: %w", err)
+		}
+		r.ExpiresAt = &expiresAt
+	}
+	return nil
+}
+
+// RotateKeyRequest configures a secret rotation
+type RotateKeyRequest struct {
+	// GracePeriodSeconds keeps the old secret valid for this long (0 = revoke immediately)
+	GracePeriodSeconds int `json:"grace_period_seconds,omitempty"`
 }
 
 type CreateKeyResponse struct {
-	APIKey  *APIKey `json:"api_key"`
-	RawKey  string  `json:"raw_key"` // Only returned once on creation
+	APIKey *APIKey `json:"api_key"`
+	RawKey string  `json:"raw_key"` // Only returned once on creation
+}
+
+const (
+	maxPrefixLength      = 16
+	visibleFragmentChars = 6
+)
+
+// ValidPrefix reports whether prefix is usable as a key label: up to 16
+// letters, digits, '_' or '-'
+func ValidPrefix(prefix string) bool {
+	if len(prefix) > maxPrefixLength {
+		return false
+	}
+	for _, c := range prefix {
+		isAlnum := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !isAlnum && c != '_' && c != '-' {
+			return false
+		}
+	}
+	return true
 }
 
-func NewManager(client *redis.Client) *Manager {
+// CanAccessService reports whether the key is scoped to the given service
+func (k *APIKey) CanAccessService(serviceName string) bool {
+	if len(k.AllowedServices) == 0 {
+		return true
+	}
+	for _, s := range k.AllowedServices {
+		if s == serviceName {
+			return true
+		}
+	}
+	return false
+}
+
+func NewManager(client redis.UniversalClient) *Manager {
 	return &Manager{client: client}
 }
 
+// EnableFallback lets keys validated within the last ttl keep working while
+// Redis is unreachable. Unknown keys are still rejected during an outage.
+func (m *Manager) EnableFallback(ttl time.Duration, clk clock.Clock) {
+	m.fallback = newFallbackCache(ttl, clk)
+}
+
 // CreateKey generates a new API key
 func (m *Manager) CreateKey(ctx context.Context, req *CreateKeyRequest) (*CreateKeyResponse, error) {
+	if !ValidPrefix(req.Prefix) {
+		return nil, fmt.Errorf("invalid key prefix %q", req.Prefix)
+	}
+
 	// Generate random key
-	rawKey, err := generateRandomKey(32)
+	secret, err := generateRandomKey(32)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate key: %w", err)
 	}
+	rawKey := req.Prefix + secret
 
 	// Hash the key for storage
 	keyHash := hashKey(rawKey)
@@ -61,14 +167,19 @@ func (m *Manager) CreateKey(ctx context.Context, req *CreateKeyRequest) (*Create
 	}
 
 	apiKey := &APIKey{
-		ID:          id,
-		Name:        req.Name,
-		KeyHash:     keyHash,
-		RateLimit:   req.RateLimit,
-		Permissions: req.Permissions,
-		CreatedAt:   time.Now(),
-		ExpiresAt:   req.ExpiresAt,
-		Active:      true,
+		ID:              id,
+		Name:            req.Name,
+		KeyHash:         keyHash,
+		KeyPrefix:       req.Prefix + secret[:visibleFragmentChars],
+		RateLimit:       req.RateLimit,
+		Unlimited:       req.Unlimited,
+		Priority:        req.Priority,
+		Plan:            req.Plan,
+		Permissions:     req.Permissions,
+		AllowedServices: req.AllowedServices,
+		CreatedAt:       time.Now(),
+		ExpiresAt:       req.ExpiresAt,
+		Active:          true,
 	}
 
 	// Store in Redis
@@ -100,16 +211,53 @@ func (m *Manager) CreateKey(ctx context.Context, req *CreateKeyRequest) (*Create
 	}, nil
 }
 
+// errLookup marks validation failures caused by Redis rather than the key
+var errLookup = errors.New("failed to lookup key")
+
+// errKeyNotFound is returned by GetKey for ids with no stored key
+var errKeyNotFound = errors.New("API key not found")
+
+// Validation failures caused by the key itself
+var (
+	ErrInvalidKey  = errors.New("invalid API key")
+	ErrKeyDisabled = errors.New("API key is disabled")
+	ErrKeyExpired  = errors.New("API key has expired")
+)
+
 func (m *Manager) ValidateKey(ctx context.Context, rawKey string) (*APIKey, error) {
 	keyHash := hashKey(rawKey)
+
+	apiKey, err := m.lookupKey(ctx, keyHash)
+	if m.fallback != nil {
+		switch {
+		case errors.Is(err, errLookup):
+			if cached, ok := m.fallback.get(keyHash); ok {
+				return checkUsable(cached)
+			}
+		case err != nil:
+			m.fallback.remove(keyHash)
+		default:
+			m.fallback.put(keyHash, apiKey)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return checkUsable(apiKey)
+}
+
+// lookupKey finds the key stored under keyHash, falling back to rotated-out
+// secrets still in their grace period
+func (m *Manager) lookupKey(ctx context.Context, keyHash string) (*APIKey, error) {
 	redisKey := fmt.Sprintf("apikey:hash:%s", keyHash)
 
 	data, err := m.client.Get(ctx, redisKey).Bytes()
 	if err == redis.Nil {
-		return nil, fmt.Errorf("invalid API key")
+		return m.lookupRotatedKey(ctx, keyHash)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to lookup key: %w", err)
+		return nil, fmt.Errorf("%w: %w", errLookup, err)
 	}
 
 	var apiKey APIKey
@@ -117,15 +265,43 @@ func (m *Manager) ValidateKey(ctx context.Context, rawKey string) (*APIKey, erro
 		return nil, fmt.Errorf("failed to unmarshal key: %w", err)
 	}
 
+	return &apiKey, nil
+}
+
+// lookupRotatedKey accepts a rotated-out secret while its grace period lasts.
+// The grace entry only points at the key id, so revocation and metadata
+// changes made after rotation still apply.
+func (m *Manager) lookupRotatedKey(ctx context.Context, keyHash string) (*APIKey, error) {
+	id, err := m.client.Get(ctx, fmt.Sprintf("apikey:grace:%s", keyHash)).Result()
+	if err == redis.Nil {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errLookup, err)
+	}
+
+	// Only a key deleted since rotation invalidates the secret; a Redis
+	// failure stays a lookup error so the fallback cache still applies
+	apiKey, err := m.GetKey(ctx, id)
+	if errors.Is(err, errKeyNotFound) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+func checkUsable(apiKey *APIKey) (*APIKey, error) {
 	if !apiKey.Active {
-		return nil, fmt.Errorf("API key is disabled")
+		return nil, ErrKeyDisabled
 	}
 
 	if apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt) {
-		return nil, fmt.Errorf("API key has expired")
+		return nil, ErrKeyExpired
 	}
 
-	return &apiKey, nil
+	return apiKey, nil
 }
 
 // GetKey retrieves an API key by ID
@@ -134,10 +310,10 @@ func (m *Manager) GetKey(ctx context.Context, id string) (*APIKey, error) {
 
 	data, err := m.client.Get(ctx, redisKey).Bytes()
 	if err == redis.Nil {
-		return nil, fmt.Errorf("API key not found")
+		return nil, errKeyNotFound
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to lookup key: %w", err)
+		return nil, fmt.Errorf("%w: %w", errLookup, err)
 	}
 
 	var apiKey APIKey
@@ -167,6 +343,96 @@ func (m *Manager) ListKeys(ctx context.Context) ([]*APIKey, error) {
 	return keys, nil
 }
 
+// UpdateKey changes the metadata of an existing API key
+func (m *Manager) UpdateKey(ctx context.Context, id string, req *UpdateKeyRequest) (*APIKey, error) {
+	apiKey, err := m.GetKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		apiKey.Name = *req.Name
+	}
+	if req.RateLimit != nil {
+		apiKey.RateLimit = *req.RateLimit
+	}
+	if req.Unlimited != nil {
+		apiKey.Unlimited = *req.Unlimited
+	}
+	if req.Priority != nil {
+		apiKey.Priority = *req.Priority
+	}
+	if req.Plan != nil {
+		apiKey.Plan = *req.Plan
+	}
+	if req.Permissions != nil {
+		apiKey.Permissions = *req.Permissions
+	}
+	if req.AllowedServices != nil {
+		apiKey.AllowedServices = *req.AllowedServices
+	}
+	if req.ClearExpiresAt {
+		apiKey.ExpiresAt = nil
+	} else if req.ExpiresAt != nil {
+		apiKey.ExpiresAt = req.ExpiresAt
+	}
+
+	if err := m.saveKey(ctx, apiKey); err != nil {
+		return nil, err
+	}
+
+	apiKey.KeyHash = ""
+	return apiKey, nil
+}
+
+// RotateKey issues a new secret for an existing key, keeping its id and all
+// other metadata. The old secret stops working immediately, or after
+// gracePeriod when it is positive. The new raw key is only returned here.
+func (m *Manager) RotateKey(ctx context.Context, id string, gracePeriod time.Duration) (*CreateKeyResponse, error) {
+	apiKey, err := m.GetKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := generateRandomKey(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	// Keep the label the key was created with, e.g. "sk_live_"
+	label := ""
+	if n := len(apiKey.KeyPrefix) - visibleFragmentChars; n > 0 {
+		label = apiKey.KeyPrefix[:n]
+	}
+	rawKey := label + secret
+
+	oldHash := apiKey.KeyHash
+	apiKey.KeyHash = hashKey(rawKey)
+	apiKey.KeyPrefix = label + secret[:visibleFragmentChars]
+
+	data, err := json.Marshal(apiKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal key: %w", err)
+	}
+
+	pipe := m.client.Pipeline()
+	pipe.Set(ctx, fmt.Sprintf("apikey:hash:%s", apiKey.KeyHash), data, 0)
+	pipe.Set(ctx, fmt.Sprintf("apikey:id:%s", id), data, 0)
+	pipe.Del(ctx, fmt.Sprintf("apikey:hash:%s", oldHash))
+	if gracePeriod > 0 {
+		pipe.Set(ctx, fmt.Sprintf("apikey:grace:%s", oldHash), id, gracePeriod)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to store rotated key: %w", err)
+	}
+
+	apiKey.KeyHash = ""
+	return &CreateKeyResponse{
+		APIKey: apiKey,
+		RawKey: rawKey,
+	}, nil
+}
+
 // RevokeKey disables an API key
 func (m *Manager) RevokeKey(ctx context.Context, id string) error {
 	apiKey, err := m.GetKey(ctx, id)
@@ -176,6 +442,11 @@ func (m *Manager) RevokeKey(ctx context.Context, id string) error {
 
 	apiKey.Active = false
 
+	return m.saveKey(ctx, apiKey)
+}
+
+// saveKey writes the key to both its hash and id storage locations
+func (m *Manager) saveKey(ctx context.Context, apiKey *APIKey) error {
 	data, err := json.Marshal(apiKey)
 	if err != nil {
 		return fmt.Errorf("failed to marshal key: %w", err)
@@ -183,7 +454,7 @@ func (m *Manager) RevokeKey(ctx context.Context, id string) error {
 
 	// Update both storage locations
 	hashKey := fmt.Sprintf("apikey:hash:%s", apiKey.KeyHash)
-	idKey := fmt.Sprintf("apikey:id:%s", id)
+	idKey := fmt.Sprintf("apikey:id:%s", apiKey.ID)
 
 	pipe := m.client.Pipeline()
 	pipe.Set(ctx, hashKey, data, 0)
@@ -0,0 +1,496 @@
+package apikey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bimakw/api-gateway/internal/clock"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestManager(t *testing.T) (*Manager, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewManager(client), mr
+}
+
+func TestCreateKeyWithAllowedServices(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctx := context.Background()
+
+	created, err := m.CreateKey(ctx, &CreateKeyRequest{
+		Name:            "scoped",
+		AllowedServices: []string{"user-service"},
+	})
+	if err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+
+	key, err := m.ValidateKey(ctx, created.RawKey)
+	if err != nil {
+		t.Fatalf("ValidateKey() error = %v", err)
+	}
+	if len(key.AllowedServices) != 1 || key.AllowedServices[0] != "user-service" {
+		t.Errorf("AllowedServices = %v, want [user-service]", key.AllowedServices)
+	}
+}
+
+func TestUpdateKeyAllowedServices(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctx := context.Background()
+
+	created, err := m.CreateKey(ctx, &CreateKeyRequest{Name: "key"})
+	if err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+
+	services := []string{"auth-service"}
+	if _, err := m.UpdateKey(ctx, created.APIKey.ID, &UpdateKeyRequest{AllowedServices: &services}); err != nil {
+		t.Fatalf("UpdateKey() error = %v", err)
+	}
+
+	// The hash entry used by validation must reflect the update
+	key, err := m.ValidateKey(ctx, created.RawKey)
+	if err != nil {
+		t.Fatalf("ValidateKey() error = %v", err)
+	}
+	if !key.CanAccessService("auth-service") || key.CanAccessService("user-service") {
+		t.Errorf("AllowedServices = %v, want only auth-service", key.AllowedServices)
+	}
+	if key.Name != "key" {
+		t.Errorf("Name = %q, want unchanged", key.Name)
+	}
+}
+
+func TestUpdateKeyClearsExpiry(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctx := context.Background()
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	created, err := m.CreateKey(ctx, &CreateKeyRequest{Name: "key", ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+
+	tests := []struct {
+		body      string
+		wantClear bool
+	}{
+		{`{"name":"renamed"}`, false},
+		{`{"expires_at":null}`, true},
+		{`{"expires_at":""}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			if _, err := m.UpdateKey(ctx, created.APIKey.ID, &UpdateKeyRequest{ExpiresAt: &expiresAt}); err != nil {
+				t.Fatalf("UpdateKey() error = %v", err)
+			}
+
+			var req UpdateKeyRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			key, err := m.UpdateKey(ctx, created.APIKey.ID, &req)
+			if err != nil {
+				t.Fatalf("UpdateKey() error = %v", err)
+			}
+			if tt.wantClear && key.ExpiresAt != nil {
+				t.Errorf("ExpiresAt = %v, want cleared", key.ExpiresAt)
+			}
+			if !tt.wantClear && (key.ExpiresAt == nil || !key.ExpiresAt.Equal(expiresAt)) {
+				t.Errorf("ExpiresAt = %v, want %v", key.ExpiresAt, expiresAt)
+			}
+		})
+	}
+
+	var req UpdateKeyRequest
+	if err := json.Unmarshal([]byte(`{"expires_at":"2030-01-02T03:04:05Z","rate_limit":5}`), &req); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if req.ExpiresAt == nil || req.ExpiresAt.Year() != 2030 || req.ClearExpiresAt || req.RateLimit == nil || *req.RateLimit != 5 {
+		t.Errorf("decoded %+v, want expiry in 2030 and rate_limit 5", req)
+	}
+	if err := json.Unmarshal([]byte(`{"expires_at":"tomorrow"}`), &req); err == nil {
+		t.Error("Unmarshal() accepted an invalid expires_at")
+	}
+}
+
+func TestCanAccessService(t *testing.T) {
+	unscoped := &APIKey{}
+	scoped := &APIKey{AllowedServices: []string{"user-service", "auth-service"}}
+
+	tests := []struct {
+		key     *APIKey
+		service string
+		want    bool
+	}{
+		{unscoped, "user-service", true},
+		{unscoped, "billing-service", true},
+		{scoped, "user-service", true},
+		{scoped, "auth-service", true},
+		{scoped, "billing-service", false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.key.CanAccessService(tt.service); got != tt.want {
+			t.Errorf("CanAccessService(%q) with %v = %v, want %v", tt.service, tt.key.AllowedServices, got, tt.want)
+		}
+	}
+}
+
+func TestCreateKeyWithPrefix(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctx := context.Background()
+
+	created, err := m.CreateKey(ctx, &CreateKeyRequest{Name: "live", Prefix: "sk_live_"})
+	if err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+
+	if !strings.HasPrefix(created.RawKey, "sk_live_") {
+		t.Errorf("RawKey = %q, want sk_live_ prefix", created.RawKey)
+	}
+	if want := created.RawKey[:len("sk_live_")+6]; created.APIKey.KeyPrefix != want {
+		t.Errorf("KeyPrefix = %q, want %q", created.APIKey.KeyPrefix, want)
+	}
+
+	// The full raw key (label included) is what validates
+	if _, err := m.ValidateKey(ctx, created.RawKey); err != nil {
+		t.Errorf("ValidateKey() error = %v", err)
+	}
+	if _, err := m.ValidateKey(ctx, strings.TrimPrefix(created.RawKey, "sk_live_")); err == nil {
+		t.Error("expected key without its prefix to be rejected")
+	}
+
+	keys, err := m.ListKeys(ctx)
+	if err != nil {
+		t.Fatalf("ListKeys() error = %v", err)
+	}
+	if len(keys) != 1 || keys[0].KeyPrefix != created.APIKey.KeyPrefix {
+		t.Fatalf("ListKeys() = %+v, want one key with prefix %q", keys, created.APIKey.KeyPrefix)
+	}
+
+	// The listing identifies the key without leaking the secret
+	listing, _ := json.Marshal(keys)
+	secret := strings.TrimPrefix(created.RawKey, "sk_live_")
+	if strings.Contains(string(listing), secret) {
+		t.Error("listing exposes the raw secret")
+	}
+}
+
+func TestCreateKeyRejectsInvalidPrefix(t *testing.T) {
+	m, _ := newTestManager(t)
+
+	for _, prefix := range []string{"sk live", "sk/live", "a_prefix_that_is_too_long_"} {
+		if _, err := m.CreateKey(context.Background(), &CreateKeyRequest{Name: "bad", Prefix: prefix}); err == nil {
+			t.Errorf("CreateKey() with prefix %q: expected error", prefix)
+		}
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	m, mr := newTestManager(t)
+	ctx := context.Background()
+
+	created, err := m.CreateKey(ctx, &CreateKeyRequest{
+		Name:            "rotating",
+		Prefix:          "sk_live_",
+		RateLimit:       120,
+		Permissions:     []string{"read"},
+		AllowedServices: []string{"user-service"},
+	})
+	if err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+
+	rotated, err := m.RotateKey(ctx, created.APIKey.ID, 0)
+	if err != nil {
+		t.Fatalf("RotateKey() error = %v", err)
+	}
+
+	if rotated.RawKey == created.RawKey {
+		t.Fatal("RotateKey() returned the old secret")
+	}
+	if !strings.HasPrefix(rotated.RawKey, "sk_live_") {
+		t.Errorf("rotated RawKey = %q, want the original sk_live_ label", rotated.RawKey)
+	}
+	if rotated.APIKey.KeyHash != "" {
+		t.Error("rotation response exposes the key hash")
+	}
+
+	key, err := m.ValidateKey(ctx, rotated.RawKey)
+	if err != nil {
+		t.Fatalf("ValidateKey(new) error = %v", err)
+	}
+	if key.ID != created.APIKey.ID || key.Name != "rotating" || key.RateLimit != 120 ||
+		len(key.Permissions) != 1 || len(key.AllowedServices) != 1 {
+		t.Errorf("rotated key metadata = %+v, want original metadata", key)
+	}
+
+	if _, err := m.ValidateKey(ctx, created.RawKey); err == nil {
+		t.Error("old secret still validates without a grace period")
+	}
+	if mr.Exists("apikey:hash:" + hashKey(created.RawKey)) {
+		t.Error("old hash entry was not removed")
+	}
+}
+
+func TestRotateKeyGracePeriod(t *testing.T) {
+	m, mr := newTestManager(t)
+	ctx := context.Background()
+
+	created, err := m.CreateKey(ctx, &CreateKeyRequest{Name: "graceful"})
+	if err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+
+	rotated, err := m.RotateKey(ctx, created.APIKey.ID, time.Minute)
+	if err != nil {
+		t.Fatalf("RotateKey() error = %v", err)
+	}
+
+	// Both secrets work during the grace window
+	for name, raw := range map[string]string{"old": created.RawKey, "new": rotated.RawKey} {
+		key, err := m.ValidateKey(ctx, raw)
+		if err != nil {
+			t.Errorf("ValidateKey(%s) during grace error = %v", name, err)
+			continue
+		}
+		if key.ID != created.APIKey.ID {
+			t.Errorf("ValidateKey(%s) id = %q, want %q", name, key.ID, created.APIKey.ID)
+		}
+	}
+
+	mr.FastForward(time.Minute + time.Second)
+
+	if _, err := m.ValidateKey(ctx, created.RawKey); err == nil {
+		t.Error("old secret still validates after the grace period")
+	}
+	if _, err := m.ValidateKey(ctx, rotated.RawKey); err != nil {
+		t.Errorf("ValidateKey(new) after grace error = %v", err)
+	}
+}
+
+func TestRotateKeyGraceRespectsRevocation(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctx := context.Background()
+
+	created, err := m.CreateKey(ctx, &CreateKeyRequest{Name: "revoked"})
+	if err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+	if _, err := m.RotateKey(ctx, created.APIKey.ID, time.Hour); err != nil {
+		t.Fatalf("RotateKey() error = %v", err)
+	}
+	if err := m.RevokeKey(ctx, created.APIKey.ID); err != nil {
+		t.Fatalf("RevokeKey() error = %v", err)
+	}
+
+	if _, err := m.ValidateKey(ctx, created.RawKey); err == nil {
+		t.Error("old secret validates for a revoked key during its grace period")
+	}
+}
+
+// newOutageTestManager returns a manager whose client fails fast once mr is closed
+func newOutageTestManager(t *testing.T) (*Manager, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	return NewManager(client), mr
+}
+
+func TestFallbackDuringRedisOutage(t *testing.T) {
+	m, mr := newOutageTestManager(t)
+	clk := clock.NewFake(time.Now())
+	m.EnableFallback(time.Minute, clk)
+	ctx := context.Background()
+
+	known, err := m.CreateKey(ctx, &CreateKeyRequest{Name: "known"})
+	if err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+	unseen, err := m.CreateKey(ctx, &CreateKeyRequest{Name: "unseen"})
+	if err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+	if _, err := m.ValidateKey(ctx, known.RawKey); err != nil {
+		t.Fatalf("ValidateKey() error = %v", err)
+	}
+
+	mr.Close()
+
+	clk.Advance(59 * time.Second)
+	key, err := m.ValidateKey(ctx, known.RawKey)
+	if err != nil {
+		t.Fatalf("known key during outage: error = %v", err)
+	}
+	if key.ID != known.APIKey.ID {
+		t.Errorf("known key during outage: ID = %s, want %s", key.ID, known.APIKey.ID)
+	}
+
+	if _, err := m.ValidateKey(ctx, unseen.RawKey); err == nil {
+		t.Error("key never validated before the outage should be rejected")
+	}
+
+	clk.Advance(time.Second)
+	if _, err := m.ValidateKey(ctx, known.RawKey); err == nil {
+		t.Error("known key should be rejected once the fallback TTL has passed")
+	}
+}
+
+// failingGets fails GET commands on keys with prefix while fail is set,
+// simulating Redis errors partway through a lookup
+type failingGets struct {
+	prefix string
+	fail   *atomic.Bool
+}
+
+func (h failingGets) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h failingGets) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if h.fail.Load() && cmd.Name() == "get" && strings.HasPrefix(fmt.Sprint(cmd.Args()[1]), h.prefix) {
+			err := errors.New("connection reset by peer")
+			cmd.SetErr(err)
+			return err
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h failingGets) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestFallbackServesRotatedKeyDuringOutage(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	var fail atomic.Bool
+	client.AddHook(failingGets{prefix: "apikey:id:", fail: &fail})
+
+	m := NewManager(client)
+	m.EnableFallback(time.Minute, clock.NewFake(time.Now()))
+	ctx := context.Background()
+
+	created, err := m.CreateKey(ctx, &CreateKeyRequest{Name: "rotated"})
+	if err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+	if _, err := m.RotateKey(ctx, created.APIKey.ID, time.Hour); err != nil {
+		t.Fatalf("RotateKey() error = %v", err)
+	}
+	if _, err := m.ValidateKey(ctx, created.RawKey); err != nil {
+		t.Fatalf("ValidateKey(old) error = %v", err)
+	}
+
+	// The grace entry still resolves but the key record can't be read
+	fail.Store(true)
+	key, err := m.ValidateKey(ctx, created.RawKey)
+	if err != nil {
+		t.Fatalf("rotated key during outage: error = %v", err)
+	}
+	if key.ID != created.APIKey.ID {
+		t.Errorf("rotated key during outage: ID = %s, want %s", key.ID, created.APIKey.ID)
+	}
+}
+
+func TestNoFallbackByDefault(t *testing.T) {
+	m, mr := newOutageTestManager(t)
+	ctx := context.Background()
+
+	created, err := m.CreateKey(ctx, &CreateKeyRequest{Name: "known"})
+	if err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+	if _, err := m.ValidateKey(ctx, created.RawKey); err != nil {
+		t.Fatalf("ValidateKey() error = %v", err)
+	}
+
+	mr.Close()
+	if _, err := m.ValidateKey(ctx, created.RawKey); err == nil {
+		t.Error("ValidateKey() should fail closed without a fallback")
+	}
+}
+
+func TestRevokeMatching(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctx := context.Background()
+
+	create := func(name, plan string) *CreateKeyResponse {
+		t.Helper()
+		created, err := m.CreateKey(ctx, &CreateKeyRequest{Name: name, Plan: plan})
+		if err != nil {
+			t.Fatalf("CreateKey() error = %v", err)
+		}
+		return created
+	}
+
+	// Enough keys to span several scan batches
+	var leakedFree, leakedPro []*CreateKeyResponse
+	for i := 0; i < 150; i++ {
+		if i%2 == 0 {
+			leakedFree = append(leakedFree, create(fmt.Sprintf("leaked-%d", i), "free"))
+		} else {
+			leakedPro = append(leakedPro, create(fmt.Sprintf("leaked-%d", i), "pro"))
+		}
+	}
+	unrelated := create("partner", "free")
+
+	if _, err := m.RevokeMatching(ctx, RevokeFilter{}); !errors.Is(err, ErrEmptyFilter) {
+		t.Fatalf("RevokeMatching(empty) error = %v, want ErrEmptyFilter", err)
+	}
+
+	n, err := m.RevokeMatching(ctx, RevokeFilter{NamePrefix: "leaked-", Plan: "free"})
+	if err != nil {
+		t.Fatalf("RevokeMatching() error = %v", err)
+	}
+	if n != len(leakedFree) {
+		t.Errorf("revoked = %d, want %d", n, len(leakedFree))
+	}
+	for _, k := range leakedFree {
+		if _, err := m.ValidateKey(ctx, k.RawKey); err == nil {
+			t.Fatalf("%s still validates after revocation", k.APIKey.Name)
+		}
+	}
+	for _, k := range append(leakedPro, unrelated) {
+		if _, err := m.ValidateKey(ctx, k.RawKey); err != nil {
+			t.Fatalf("%s (plan %s) was revoked: %v", k.APIKey.Name, k.APIKey.Plan, err)
+		}
+	}
+
+	// Already revoked keys aren't counted again
+	if n, _ := m.RevokeMatching(ctx, RevokeFilter{Plan: "free", NamePrefix: "leaked-"}); n != 0 {
+		t.Errorf("second revoke = %d, want 0", n)
+	}
+
+	// Backdate the partner key so only it predates the cutoff
+	old, err := m.GetKey(ctx, unrelated.APIKey.ID)
+	if err != nil {
+		t.Fatalf("GetKey() error = %v", err)
+	}
+	old.CreatedAt = time.Now().Add(-48 * time.Hour)
+	if err := m.saveKey(ctx, old); err != nil {
+		t.Fatalf("saveKey() error = %v", err)
+	}
+	cutoff := time.Now().Add(-24 * time.Hour)
+	if n, err := m.RevokeMatching(ctx, RevokeFilter{CreatedBefore: &cutoff}); err != nil || n != 1 {
+		t.Errorf("RevokeMatching(created_before) = %d, %v, want 1", n, err)
+	}
+	if _, err := m.ValidateKey(ctx, unrelated.RawKey); err == nil {
+		t.Error("backdated key still validates")
+	}
+	if _, err := m.ValidateKey(ctx, leakedPro[0].RawKey); err != nil {
+		t.Errorf("recent key revoked by created_before: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package apikey
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bimakw/api-gateway/internal/clock"
+)
+
+// maxFallbackEntries bounds the fallback cache; new keys aren't cached once
+// it is full of unexpired entries
+const maxFallbackEntries = 10000
+
+// fallbackCache remembers recently validated keys so they keep working while
+// Redis is unreachable. It is never consulted when Redis answers.
+type fallbackCache struct {
+	ttl   time.Duration
+	clock clock.Clock
+
+	mu      sync.Mutex
+	entries map[string]fallbackEntry // key hash -> last validated key
+}
+
+type fallbackEntry struct {
+	key     *APIKey
+	expires time.Time
+}
+
+func newFallbackCache(ttl time.Duration, clk clock.Clock) *fallbackCache {
+	return &fallbackCache{
+		ttl:     ttl,
+		clock:   clock.OrReal(clk),
+		entries: make(map[string]fallbackEntry),
+	}
+}
+
+func (c *fallbackCache) put(keyHash string, key *APIKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	if _, exists := c.entries[keyHash]; !exists && len(c.entries) >= maxFallbackEntries {
+		for hash, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, hash)
+			}
+		}
+		if len(c.entries) >= maxFallbackEntries {
+			return
+		}
+	}
+	c.entries[keyHash] = fallbackEntry{key: key, expires: now.Add(c.ttl)}
+}
+
+func (c *fallbackCache) get(keyHash string) (*APIKey, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[keyHash]
+	if !ok {
+		return nil, false
+	}
+	if !c.clock.Now().Before(e.expires) {
+		delete(c.entries, keyHash)
+		return nil, false
+	}
+	return e.key, true
+}
+
+func (c *fallbackCache) remove(keyHash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, keyHash)
+}
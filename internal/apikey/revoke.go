@@ -0,0 +1,123 @@
+package apikey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// revokeBatchSize is how many key ids RevokeMatching loads per round trip
+const revokeBatchSize = 100
+
+// ErrEmptyFilter is returned for a filter that would match every key
+var ErrEmptyFilter = errors.New("filter must set at least one criterion")
+
+// RevokeFilter selects keys for bulk revocation. Set criteria are combined,
+// so a key must match all of them.
+type RevokeFilter struct {
+	NamePrefix    string     `json:"name_prefix,omitempty"`
+	Plan          string     `json:"plan,omitempty"`
+	CreatedBefore *time.Time `json:"created_before,omitempty"`
+}
+
+func (f *RevokeFilter) empty() bool {
+	return f.NamePrefix == "" && f.Plan == "" && f.CreatedBefore == nil
+}
+
+func (f *RevokeFilter) matches(k *APIKey) bool {
+	if f.NamePrefix != "" && !strings.HasPrefix(k.Name, f.NamePrefix) {
+		return false
+	}
+	if f.Plan != "" && k.Plan != f.Plan {
+		return false
+	}
+	if f.CreatedBefore != nil && !k.CreatedAt.Before(*f.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// RevokeMatching disables every active key matching filter and returns how
+// many were revoked. Keys are scanned in batches, so only one batch is held
+// in memory at a time.
+func (m *Manager) RevokeMatching(ctx context.Context, filter RevokeFilter) (int, error) {
+	if filter.empty() {
+		return 0, ErrEmptyFilter
+	}
+
+	revoked := 0
+	var cursor uint64
+	for {
+		ids, next, err := m.client.SScan(ctx, "apikey:list", cursor, "", revokeBatchSize).Result()
+		if err != nil {
+			return revoked, fmt.Errorf("failed to scan keys: %w", err)
+		}
+
+		n, err := m.revokeBatch(ctx, ids, &filter)
+		revoked += n
+		if err != nil {
+			return revoked, err
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return revoked, nil
+		}
+	}
+}
+
+// revokeBatch loads ids in one pipeline and writes the matching keys back
+// as inactive in another
+func (m *Manager) revokeBatch(ctx context.Context, ids []string, filter *RevokeFilter) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	read := m.client.Pipeline()
+	gets := make([]*redis.StringCmd, len(ids))
+	for i, id := range ids {
+		gets[i] = read.Get(ctx, fmt.Sprintf("apikey:id:%s", id))
+	}
+	// Ids whose body is gone surface as redis.Nil and are skipped below
+	if _, err := read.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return 0, fmt.Errorf("failed to load keys: %w", err)
+	}
+
+	write := m.client.Pipeline()
+	matched := 0
+	for _, get := range gets {
+		data, err := get.Bytes()
+		if err != nil {
+			continue
+		}
+		var apiKey APIKey
+		if err := json.Unmarshal(data, &apiKey); err != nil {
+			continue
+		}
+		if !apiKey.Active || !filter.matches(&apiKey) {
+			continue
+		}
+
+		apiKey.Active = false
+		data, err = json.Marshal(&apiKey)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal key: %w", err)
+		}
+		write.Set(ctx, fmt.Sprintf("apikey:hash:%s", apiKey.KeyHash), data, 0)
+		write.Set(ctx, fmt.Sprintf("apikey:id:%s", apiKey.ID), data, 0)
+		matched++
+	}
+
+	if matched == 0 {
+		return 0, nil
+	}
+	if _, err := write.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to revoke keys: %w", err)
+	}
+	return matched, nil
+}
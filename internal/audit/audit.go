@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKey holds the most recent entries, newest first
+const redisKey = "audit:admin"
+
+// DefaultMaxEntries is how many entries Redis keeps when no limit is given
+const DefaultMaxEntries = 10000
+
+// Entry is one admin action
+type Entry struct {
+	Time     time.Time `json:"timestamp"`
+	Admin    string    `json:"admin"`
+	Action   string    `json:"action"`
+	Target   string    `json:"target,omitempty"`
+	ClientIP string    `json:"client_ip"`
+}
+
+// Log records admin actions as slog records marked audit=true, and
+// optionally keeps them in a capped Redis list for retention
+type Log struct {
+	logger     *slog.Logger
+	client     redis.UniversalClient
+	maxEntries int64
+}
+
+// New returns a Log writing to logger. With a non-nil client the latest
+// maxEntries entries (0 = DefaultMaxEntries) are also kept in Redis.
+func New(logger *slog.Logger, client redis.UniversalClient, maxEntries int) *Log {
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	return &Log{logger: logger, client: client, maxEntries: int64(maxEntries)}
+}
+
+// Record logs e, stamping it with the current time if unset. Failing to
+// store it in Redis is logged but doesn't undo the action.
+func (l *Log) Record(ctx context.Context, e Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	l.logger.LogAttrs(ctx, slog.LevelInfo, "admin action",
+		slog.Bool("audit", true),
+		slog.String("admin", e.Admin),
+		slog.String("action", e.Action),
+		slog.String("target", e.Target),
+		slog.String("client_ip", e.ClientIP),
+		slog.Time("timestamp", e.Time),
+	)
+
+	if l.client == nil {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	_, err = l.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, redisKey, data)
+		pipe.LTrim(ctx, redisKey, 0, l.maxEntries-1)
+		return nil
+	})
+	if err != nil {
+		l.logger.Warn("Failed to store audit entry", "action", e.Action, "error", err)
+	}
+}
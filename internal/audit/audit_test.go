@@ -0,0 +1,39 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRecordKeepsLatestEntriesInRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	log := New(slog.New(slog.NewTextHandler(io.Discard, nil)), client, 2)
+	ctx := context.Background()
+	for _, target := range []string{"a", "b", "c"} {
+		log.Record(ctx, Entry{Admin: "ops", Action: "apikey.delete", Target: target, ClientIP: "10.0.0.1"})
+	}
+
+	raw, err := client.LRange(ctx, redisKey, 0, -1).Result()
+	if err != nil {
+		t.Fatalf("LRange() error = %v", err)
+	}
+	if len(raw) != 2 {
+		t.Fatalf("stored %d entries, want 2", len(raw))
+	}
+	var newest Entry
+	if err := json.Unmarshal([]byte(raw[0]), &newest); err != nil {
+		t.Fatalf("decode error = %v", err)
+	}
+	if newest.Target != "c" || newest.Admin != "ops" || newest.Time.IsZero() {
+		t.Errorf("newest entry = %+v, want target c with a timestamp", newest)
+	}
+}
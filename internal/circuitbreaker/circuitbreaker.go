@@ -4,6 +4,8 @@ import (
 	"errors"
 	"sync"
 	"time"
+
+	"github.com/bimakw/api-gateway/internal/clock"
 )
 
 type State int
@@ -32,11 +34,43 @@ var (
 	ErrTooManyRequests = errors.New("too many requests in half-open state")
 )
 
+// Config tunes a CircuitBreaker. HalfOpenMaxRequests bounds concurrent
+// half-open probes, counting the request that triggers the transition.
 type Config struct {
 	MaxFailures         int
 	ResetTimeout        time.Duration
 	HalfOpenMaxRequests int
 	SuccessThreshold    int
+	// SingleProbe is classic half-open: exactly one probe at a time, whose
+	// success closes the breaker and whose failure reopens it. It overrides
+	// HalfOpenMaxRequests and SuccessThreshold.
+	SingleProbe bool
+	// ResetBackoffMultiplier grows the open duration after each failed half-open
+	// probe (values <= 1 keep the reset timeout fixed)
+	ResetBackoffMultiplier float64
+	// ResetTimeoutMax caps the grown open duration (0 = 10x ResetTimeout)
+	ResetTimeoutMax time.Duration
+
+	// OnStateChange, if set, is called after every state transition
+	OnStateChange func(name string, from, to State)
+	// OnOutcome, if set, is called for every recorded success or failure
+	OnOutcome func(name string, success bool)
+
+	// IsSuccessful classifies a response status for RecordStatus (nil =
+	// DefaultIsSuccessful, i.e. anything below 500 succeeds)
+	IsSuccessful func(statusCode int) bool
+
+	// WarmUp ignores failures for this long after the breaker is created, so a
+	// backend still starting up doesn't trip it (0 = no warm-up)
+	WarmUp time.Duration
+
+	// Clock measures the open period (nil = wall clock)
+	Clock clock.Clock
+}
+
+// DefaultIsSuccessful treats every non-5xx response as a success
+func DefaultIsSuccessful(statusCode int) bool {
+	return statusCode < 500
 }
 
 func DefaultConfig() Config {
@@ -59,9 +93,24 @@ type CircuitBreaker struct {
 	lastFailure          time.Time
 	halfOpenRequests     int
 	consecutiveSuccesses int
+	openTimeout          time.Duration // current open duration, grows with backoff
+	warmUpUntil          time.Time     // failures before this don't count toward tripping
 }
 
 func New(name string, config Config) *CircuitBreaker {
+	config = config.withDefaults()
+
+	return &CircuitBreaker{
+		name:        name,
+		config:      config,
+		state:       StateClosed,
+		openTimeout: config.ResetTimeout,
+		warmUpUntil: config.Clock.Now().Add(config.WarmUp),
+	}
+}
+
+// withDefaults fills unset fields with the values a breaker actually uses
+func (config Config) withDefaults() Config {
 	if config.MaxFailures <= 0 {
 		config.MaxFailures = 7
 	}
@@ -74,12 +123,18 @@ func New(name string, config Config) *CircuitBreaker {
 	if config.SuccessThreshold <= 0 {
 		config.SuccessThreshold = 3
 	}
-
-	return &CircuitBreaker{
-		name:   name,
-		config: config,
-		state:  StateClosed,
+	if config.SingleProbe {
+		config.HalfOpenMaxRequests = 1
+		config.SuccessThreshold = 1
 	}
+	if config.ResetBackoffMultiplier > 1 && config.ResetTimeoutMax <= 0 {
+		config.ResetTimeoutMax = 10 * config.ResetTimeout
+	}
+	if config.IsSuccessful == nil {
+		config.IsSuccessful = DefaultIsSuccessful
+	}
+	config.Clock = clock.OrReal(config.Clock)
+	return config
 }
 
 func (cb *CircuitBreaker) Execute(fn func() error) error {
@@ -98,61 +153,108 @@ func (cb *CircuitBreaker) AllowRequest() bool {
 }
 
 func (cb *CircuitBreaker) beforeRequest() error {
+	halfOpened, err := cb.admit()
+	if halfOpened {
+		cb.notifyStateChange(StateOpen, StateHalfOpen)
+	}
+	return err
+}
+
+// admit decides whether a request may proceed and reports whether it moved
+// the breaker from open to half-open
+func (cb *CircuitBreaker) admit() (bool, error) {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 
 	switch cb.state {
 	case StateClosed:
-		return nil
+		return false, nil
 
 	case StateOpen:
-		// Check if we should transition to half-open
-		if time.Since(cb.lastFailure) >= cb.config.ResetTimeout {
+		// Check if we should transition to half-open. The transitioning
+		// request is the first probe and occupies a half-open slot.
+		if cb.config.Clock.Now().Sub(cb.lastFailure) >= cb.openTimeout {
 			cb.toHalfOpen()
-			return nil
+			cb.halfOpenRequests++
+			return true, nil
 		}
-		return ErrCircuitOpen
+		return false, ErrCircuitOpen
 
 	case StateHalfOpen:
 		// Limit requests in half-open state
 		if cb.halfOpenRequests >= cb.config.HalfOpenMaxRequests {
-			return ErrTooManyRequests
+			return false, ErrTooManyRequests
 		}
 		cb.halfOpenRequests++
-		return nil
+		return false, nil
 	}
 
-	return nil
+	return false, nil
 }
 
 func (cb *CircuitBreaker) afterRequest(success bool) {
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	from := cb.state
+	cb.record(success)
+	to := cb.state
+	cb.mu.Unlock()
+
+	// Hooks run without the lock so they may inspect the breaker
+	if cb.config.OnOutcome != nil {
+		cb.config.OnOutcome(cb.name, success)
+	}
+	if from != to {
+		cb.notifyStateChange(from, to)
+	}
+}
 
+// record applies an outcome to the state machine. Callers must hold cb.mu.
+func (cb *CircuitBreaker) record(success bool) {
 	switch cb.state {
 	case StateClosed:
+		now := cb.config.Clock.Now()
 		if success {
 			cb.failures = 0
-		} else {
+		} else if !now.Before(cb.warmUpUntil) {
+			// Failures during warm-up are ignored; the backend may still be starting
 			cb.failures++
-			cb.lastFailure = time.Now()
+			cb.lastFailure = now
 			if cb.failures >= cb.config.MaxFailures {
 				cb.toOpen()
 			}
 		}
 
 	case StateHalfOpen:
+		cb.releaseHalfOpenSlot()
 		if success {
 			cb.consecutiveSuccesses++
 			if cb.consecutiveSuccesses >= cb.config.SuccessThreshold {
 				cb.toClosed()
 			}
 		} else {
+			cb.backoff()
 			cb.toOpen()
 		}
 	}
 }
 
+// Release returns a request slot admitted by AllowRequest without recording an
+// outcome, for requests abandoned before reaching the backend
+func (cb *CircuitBreaker) Release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == StateHalfOpen {
+		cb.releaseHalfOpenSlot()
+	}
+}
+
+func (cb *CircuitBreaker) releaseHalfOpenSlot() {
+	if cb.halfOpenRequests > 0 {
+		cb.halfOpenRequests--
+	}
+}
+
 func (cb *CircuitBreaker) RecordSuccess() {
 	cb.afterRequest(true)
 }
@@ -161,9 +263,20 @@ func (cb *CircuitBreaker) RecordFailure() {
 	cb.afterRequest(false)
 }
 
+// RecordStatus records a response outcome as classified by IsSuccessful
+func (cb *CircuitBreaker) RecordStatus(statusCode int) {
+	cb.afterRequest(cb.config.IsSuccessful(statusCode))
+}
+
+func (cb *CircuitBreaker) notifyStateChange(from, to State) {
+	if cb.config.OnStateChange != nil {
+		cb.config.OnStateChange(cb.name, from, to)
+	}
+}
+
 func (cb *CircuitBreaker) toOpen() {
 	cb.state = StateOpen
-	cb.lastFailure = time.Now()
+	cb.lastFailure = cb.config.Clock.Now()
 	cb.consecutiveSuccesses = 0
 }
 
@@ -172,6 +285,19 @@ func (cb *CircuitBreaker) toClosed() {
 	cb.failures = 0
 	cb.consecutiveSuccesses = 0
 	cb.halfOpenRequests = 0
+	cb.openTimeout = cb.config.ResetTimeout
+}
+
+// backoff lengthens the next open period after a failed half-open probe
+func (cb *CircuitBreaker) backoff() {
+	if cb.config.ResetBackoffMultiplier <= 1 {
+		return
+	}
+	next := time.Duration(float64(cb.openTimeout) * cb.config.ResetBackoffMultiplier)
+	if next > cb.config.ResetTimeoutMax {
+		next = cb.config.ResetTimeoutMax
+	}
+	cb.openTimeout = next
 }
 
 func (cb *CircuitBreaker) toHalfOpen() {
@@ -186,6 +312,26 @@ func (cb *CircuitBreaker) GetState() State {
 	return cb.state
 }
 
+// OpenRemaining returns how long the breaker stays open before admitting a
+// half-open probe (0 unless open)
+func (cb *CircuitBreaker) OpenRemaining() time.Duration {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	return cb.openRemaining()
+}
+
+// openRemaining is OpenRemaining for callers holding cb.mu
+func (cb *CircuitBreaker) openRemaining() time.Duration {
+	if cb.state != StateOpen {
+		return 0
+	}
+	remaining := cb.openTimeout - cb.config.Clock.Now().Sub(cb.lastFailure)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
 func (cb *CircuitBreaker) GetStats() Stats {
 	cb.mu.RLock()
 	defer cb.mu.RUnlock()
@@ -196,6 +342,7 @@ func (cb *CircuitBreaker) GetStats() Stats {
 		Failures:             cb.failures,
 		ConsecutiveSuccesses: cb.consecutiveSuccesses,
 		LastFailure:          cb.lastFailure,
+		OpenRemainingSeconds: cb.openRemaining().Seconds(),
 	}
 }
 
@@ -205,10 +352,17 @@ type Stats struct {
 	Failures             int       `json:"failures"`
 	ConsecutiveSuccesses int       `json:"consecutive_successes"`
 	LastFailure          time.Time `json:"last_failure,omitempty"`
+	// OpenRemainingSeconds is the time until an open breaker admits a probe
+	OpenRemainingSeconds float64 `json:"open_remaining_seconds,omitempty"`
 }
 
 func (cb *CircuitBreaker) Reset() {
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	from := cb.state
 	cb.toClosed()
+	cb.mu.Unlock()
+
+	if from != StateClosed {
+		cb.notifyStateChange(from, StateClosed)
+	}
 }
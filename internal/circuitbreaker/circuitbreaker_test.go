@@ -2,8 +2,11 @@ package circuitbreaker
 
 import (
 	"errors"
+	"net/http"
 	"testing"
 	"time"
+
+	"github.com/bimakw/api-gateway/internal/clock"
 )
 
 func TestStateString(t *testing.T) {
@@ -112,11 +115,13 @@ func TestCircuitBreakerOpensAfterMaxFailures(t *testing.T) {
 }
 
 func TestCircuitBreakerTransitionsToHalfOpen(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
 	cb := New("test", Config{
 		MaxFailures:         2,
 		ResetTimeout:        50 * time.Millisecond,
 		HalfOpenMaxRequests: 2,
 		SuccessThreshold:    2,
+		Clock:               clk,
 	})
 
 	// Open the circuit
@@ -127,10 +132,14 @@ func TestCircuitBreakerTransitionsToHalfOpen(t *testing.T) {
 		t.Fatalf("state = %v, want Open", cb.GetState())
 	}
 
-	// Wait for reset timeout
-	time.Sleep(60 * time.Millisecond)
+	// Just short of the reset timeout the breaker stays open
+	clk.Advance(50*time.Millisecond - time.Nanosecond)
+	if cb.AllowRequest() {
+		t.Fatal("should reject requests before the reset timeout")
+	}
 
-	// Next request should transition to half-open
+	// Next request at the reset timeout should transition to half-open
+	clk.Advance(time.Nanosecond)
 	if !cb.AllowRequest() {
 		t.Error("should allow request after reset timeout")
 	}
@@ -152,27 +161,48 @@ func TestCircuitBreakerHalfOpenLimitsRequests(t *testing.T) {
 	cb.RecordFailure()
 	time.Sleep(5 * time.Millisecond)
 
-	// First AllowRequest transitions from Open to HalfOpen (no increment yet)
+	// First AllowRequest transitions from Open to HalfOpen and is the first probe
 	if !cb.AllowRequest() {
-		t.Error("first request should be allowed (transition to half-open)")
+		t.Error("first request should be allowed (transition to half-open, counter=1)")
 	}
 
-	// Second request: already in half-open, increments counter to 1
+	// Second request: already in half-open, increments counter to 2
 	if !cb.AllowRequest() {
-		t.Error("second request should be allowed (counter=1)")
+		t.Error("second request should be allowed (counter=2)")
 	}
 
-	// Third request: increments counter to 2
-	if !cb.AllowRequest() {
-		t.Error("third request should be allowed (counter=2)")
-	}
-
-	// Fourth request should be denied (counter >= HalfOpenMaxRequests)
+	// Third request should be denied (counter >= HalfOpenMaxRequests)
 	if cb.AllowRequest() {
 		t.Error("half-open should limit requests after HalfOpenMaxRequests")
 	}
 }
 
+func TestCircuitBreakerHalfOpenAllowsExactlyMaxProbes(t *testing.T) {
+	for _, limit := range []int{1, 2, 5} {
+		cb := New("test", Config{
+			MaxFailures:         1,
+			ResetTimeout:        1 * time.Millisecond,
+			HalfOpenMaxRequests: limit,
+			SuccessThreshold:    limit + 1,
+		})
+		cb.RecordFailure()
+		time.Sleep(5 * time.Millisecond)
+
+		allowed := 0
+		for i := 0; i < limit+3; i++ {
+			if cb.AllowRequest() {
+				allowed++
+			}
+		}
+		if allowed != limit {
+			t.Errorf("HalfOpenMaxRequests=%d: allowed %d probes, want %d", limit, allowed, limit)
+		}
+		if cb.GetState() != StateHalfOpen {
+			t.Errorf("HalfOpenMaxRequests=%d: state = %v, want HalfOpen", limit, cb.GetState())
+		}
+	}
+}
+
 func TestCircuitBreakerClosesAfterSuccessThreshold(t *testing.T) {
 	cb := New("test", Config{
 		MaxFailures:         1,
@@ -325,3 +355,300 @@ func TestCircuitBreakerConcurrency(t *testing.T) {
 
 	// Should not panic
 }
+
+// failProbe waits out the open period, lets one half-open probe through and fails it
+func failProbe(t *testing.T, cb *CircuitBreaker, wait time.Duration) {
+	t.Helper()
+	time.Sleep(wait)
+	if !cb.AllowRequest() {
+		t.Fatalf("expected half-open probe after %v", wait)
+	}
+	cb.RecordFailure()
+	if cb.GetState() != StateOpen {
+		t.Fatalf("state = %v, want Open after failed probe", cb.GetState())
+	}
+}
+
+func TestCircuitBreakerResetBackoff(t *testing.T) {
+	cb := New("test", Config{
+		MaxFailures:            1,
+		ResetTimeout:           20 * time.Millisecond,
+		ResetBackoffMultiplier: 2,
+		ResetTimeoutMax:        80 * time.Millisecond,
+	})
+
+	cb.RecordFailure()
+	if cb.openTimeout != 20*time.Millisecond {
+		t.Fatalf("open duration = %v, want 20ms", cb.openTimeout)
+	}
+
+	want := []time.Duration{40 * time.Millisecond, 80 * time.Millisecond, 80 * time.Millisecond}
+	for i, w := range want {
+		failProbe(t, cb, cb.openTimeout+5*time.Millisecond)
+		if cb.openTimeout != w {
+			t.Errorf("open duration after %d failed probes = %v, want %v", i+1, cb.openTimeout, w)
+		}
+	}
+
+	// The grown duration is enforced: the old reset timeout is no longer enough
+	time.Sleep(30 * time.Millisecond)
+	if cb.AllowRequest() {
+		t.Error("expected breaker to stay open for the backed-off duration")
+	}
+
+	// Closing the breaker restores the base reset timeout
+	cb.Reset()
+	if cb.openTimeout != 20*time.Millisecond {
+		t.Errorf("open duration after close = %v, want 20ms", cb.openTimeout)
+	}
+}
+
+func TestCircuitBreakerResetBackoffDisabledByDefault(t *testing.T) {
+	cb := New("test", Config{
+		MaxFailures:  1,
+		ResetTimeout: 20 * time.Millisecond,
+	})
+
+	cb.RecordFailure()
+	failProbe(t, cb, 25*time.Millisecond)
+	failProbe(t, cb, 25*time.Millisecond)
+
+	if cb.openTimeout != 20*time.Millisecond {
+		t.Errorf("open duration = %v, want fixed 20ms", cb.openTimeout)
+	}
+}
+
+func TestCircuitBreakerHalfOpenSlots(t *testing.T) {
+	cb := New("test", Config{
+		MaxFailures:         1,
+		ResetTimeout:        1 * time.Millisecond,
+		HalfOpenMaxRequests: 1,
+		SuccessThreshold:    3,
+	})
+
+	cb.RecordFailure()
+	time.Sleep(5 * time.Millisecond)
+	cb.AllowRequest() // transition to half-open, taking the only slot
+	if cb.AllowRequest() {
+		t.Fatal("expected the transition request to occupy the half-open slot")
+	}
+	cb.Release()
+
+	// A released slot can be reused
+	for i := 0; i < 3; i++ {
+		if !cb.AllowRequest() {
+			t.Fatalf("request %d: expected free half-open slot", i+1)
+		}
+		cb.Release()
+	}
+
+	// A recorded outcome also frees the slot, so probes keep flowing until the threshold
+	for i := 0; i < 3; i++ {
+		if !cb.AllowRequest() {
+			t.Fatalf("probe %d: expected free half-open slot", i+1)
+		}
+		cb.RecordSuccess()
+	}
+	if cb.GetState() != StateClosed {
+		t.Errorf("state = %v, want Closed after 3 successes", cb.GetState())
+	}
+
+	// Release outside half-open is a no-op
+	cb.Release()
+	if cb.GetState() != StateClosed {
+		t.Errorf("state = %v, want Closed", cb.GetState())
+	}
+}
+
+func TestHooksObserveOutcomesAndTransitions(t *testing.T) {
+	var successes, failures int
+	var transitions []string
+	clk := clock.NewFake(time.Unix(0, 0))
+	cb := New("svc", Config{
+		MaxFailures:      2,
+		ResetTimeout:     10 * time.Millisecond,
+		SuccessThreshold: 1,
+		Clock:            clk,
+		OnOutcome: func(name string, success bool) {
+			if name != "svc" {
+				t.Errorf("OnOutcome name = %q, want svc", name)
+			}
+			if success {
+				successes++
+			} else {
+				failures++
+			}
+		},
+		OnStateChange: func(name string, from, to State) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	})
+
+	cb.RecordSuccess()
+	cb.RecordFailure()
+	cb.RecordFailure()
+	clk.Advance(10 * time.Millisecond)
+	if !cb.AllowRequest() {
+		t.Fatal("expected probe after reset timeout")
+	}
+	cb.RecordSuccess()
+
+	if successes != 2 || failures != 2 {
+		t.Errorf("outcomes = %d successes, %d failures, want 2 and 2", successes, failures)
+	}
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if len(transitions) != len(want) {
+		t.Fatalf("transitions = %v, want %v", transitions, want)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Errorf("transitions[%d] = %s, want %s", i, transitions[i], want[i])
+		}
+	}
+}
+
+func TestCircuitBreakerWarmUpIgnoresEarlyFailures(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	cb := New("test", Config{
+		MaxFailures: 2,
+		WarmUp:      10 * time.Second,
+		Clock:       clk,
+	})
+
+	for i := 0; i < 5; i++ {
+		cb.RecordFailure()
+	}
+	if cb.GetState() != StateClosed {
+		t.Fatalf("state = %v during warm-up, want Closed", cb.GetState())
+	}
+	if got := cb.GetStats().Failures; got != 0 {
+		t.Errorf("failures = %d during warm-up, want 0", got)
+	}
+
+	clk.Advance(10 * time.Second)
+	cb.RecordFailure()
+	cb.RecordFailure()
+	if cb.GetState() != StateOpen {
+		t.Errorf("state = %v after warm-up, want Open", cb.GetState())
+	}
+}
+
+func TestCircuitBreakerRecordStatus(t *testing.T) {
+	teapotFails := func(statusCode int) bool {
+		return statusCode != http.StatusTeapot && DefaultIsSuccessful(statusCode)
+	}
+
+	tests := []struct {
+		name         string
+		isSuccessful func(int) bool
+		status       int
+		wantOpen     bool
+	}{
+		{"default 5xx fails", nil, http.StatusBadGateway, true},
+		{"default 4xx succeeds", nil, http.StatusTeapot, false},
+		{"custom 418 fails", teapotFails, http.StatusTeapot, true},
+		{"custom 404 succeeds", teapotFails, http.StatusNotFound, false},
+		{"custom keeps 5xx failing", teapotFails, http.StatusServiceUnavailable, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := New("test", Config{MaxFailures: 2, IsSuccessful: tt.isSuccessful})
+			cb.RecordStatus(tt.status)
+			cb.RecordStatus(tt.status)
+
+			if got := cb.GetState() == StateOpen; got != tt.wantOpen {
+				t.Errorf("open = %v after two %d responses, want %v", got, tt.status, tt.wantOpen)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerOpenRemaining(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	cb := New("test", Config{MaxFailures: 1, ResetTimeout: 30 * time.Second, Clock: clk})
+
+	if got := cb.OpenRemaining(); got != 0 {
+		t.Errorf("closed OpenRemaining() = %v, want 0", got)
+	}
+
+	cb.RecordFailure()
+	clk.Advance(10 * time.Second)
+	if got := cb.OpenRemaining(); got != 20*time.Second {
+		t.Errorf("OpenRemaining() = %v, want 20s", got)
+	}
+	if got := cb.GetStats().OpenRemainingSeconds; got != 20 {
+		t.Errorf("stats OpenRemainingSeconds = %v, want 20", got)
+	}
+
+	clk.Advance(30 * time.Second)
+	if got := cb.OpenRemaining(); got != 0 {
+		t.Errorf("OpenRemaining() past the timeout = %v, want 0", got)
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	for _, success := range []bool{true, false} {
+		clk := clock.NewFake(time.Unix(0, 0))
+		// The multi-probe settings are overridden by SingleProbe
+		cb := New("test", Config{
+			MaxFailures:         1,
+			ResetTimeout:        10 * time.Second,
+			HalfOpenMaxRequests: 5,
+			SuccessThreshold:    3,
+			SingleProbe:         true,
+			Clock:               clk,
+		})
+		cb.RecordFailure()
+		clk.Advance(10 * time.Second)
+
+		allowed := 0
+		for range 5 {
+			if cb.AllowRequest() {
+				allowed++
+			}
+		}
+		if allowed != 1 {
+			t.Fatalf("half-open allowed %d probes, want 1", allowed)
+		}
+		if cb.GetState() != StateHalfOpen {
+			t.Fatalf("state with probe in flight = %v, want HalfOpen", cb.GetState())
+		}
+
+		if success {
+			cb.RecordSuccess()
+			if cb.GetState() != StateClosed {
+				t.Errorf("state after successful probe = %v, want Closed", cb.GetState())
+			}
+			continue
+		}
+		cb.RecordFailure()
+		if cb.GetState() != StateOpen {
+			t.Errorf("state after failed probe = %v, want Open", cb.GetState())
+		}
+		if cb.AllowRequest() {
+			t.Error("request allowed right after the probe failed")
+		}
+	}
+}
+
+func TestCircuitBreakerSingleProbeReleasedSlot(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	cb := New("test", Config{MaxFailures: 1, ResetTimeout: time.Second, SingleProbe: true, Clock: clk})
+	cb.RecordFailure()
+	clk.Advance(time.Second)
+
+	if !cb.AllowRequest() {
+		t.Fatal("first probe rejected")
+	}
+	// An abandoned probe frees the slot without deciding the state
+	cb.Release()
+	if !cb.AllowRequest() {
+		t.Fatal("probe rejected after the previous one was released")
+	}
+	if cb.AllowRequest() {
+		t.Error("second concurrent probe allowed")
+	}
+	if cb.GetState() != StateHalfOpen {
+		t.Errorf("state = %v, want HalfOpen", cb.GetState())
+	}
+}
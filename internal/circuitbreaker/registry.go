@@ -40,6 +40,44 @@ func (r *Registry) Get(name string) *CircuitBreaker {
 	return cb
 }
 
+// Register creates the breaker for name with its own config, replacing any
+// existing one. Use it for per-service settings or to start a warm-up
+// period before the first request.
+func (r *Registry) Register(name string, config Config) *CircuitBreaker {
+	cb := New(name, config)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.breakers[name] = cb
+	return cb
+}
+
+// ConfigFor returns the effective config of name's breaker without creating
+// it; breakers not created yet report the registry defaults
+func (r *Registry) ConfigFor(name string) Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if cb, exists := r.breakers[name]; exists {
+		return cb.config
+	}
+	return r.config.withDefaults()
+}
+
+// Remove drops name's breaker, e.g. once its service is removed. A later Get
+// starts a fresh breaker with the registry defaults. It reports false if
+// there was no breaker.
+func (r *Registry) Remove(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.breakers[name]; !exists {
+		return false
+	}
+	delete(r.breakers, name)
+	return true
+}
+
 func (r *Registry) GetAll() map[string]*CircuitBreaker {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -1,13 +1,23 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/bimakw/api-gateway/config"
 	"github.com/bimakw/api-gateway/internal/apikey"
+	"github.com/bimakw/api-gateway/internal/audit"
 	"github.com/bimakw/api-gateway/internal/health"
+	"github.com/bimakw/api-gateway/internal/metrics"
+	"github.com/bimakw/api-gateway/internal/middleware"
 	"github.com/bimakw/api-gateway/internal/proxy"
+	"github.com/bimakw/api-gateway/internal/servicestate"
+	"github.com/bimakw/api-gateway/internal/version"
+	"github.com/redis/go-redis/v9"
 )
 
 type Handler struct {
@@ -15,20 +25,43 @@ type Handler struct {
 	apiKeyMgr     *apikey.Manager
 	healthChecker *health.Checker
 	reverseProxy  *proxy.ReverseProxy
+	redisClient   redis.UniversalClient
+	auditLog      *audit.Log
 }
 
-func New(cfg *config.Config, apiKeyMgr *apikey.Manager, healthChecker *health.Checker, rp *proxy.ReverseProxy) *Handler {
+func New(cfg *config.Config, apiKeyMgr *apikey.Manager, healthChecker *health.Checker, rp *proxy.ReverseProxy, redisClient redis.UniversalClient) *Handler {
 	return &Handler{
 		config:        cfg,
 		apiKeyMgr:     apiKeyMgr,
 		healthChecker: healthChecker,
 		reverseProxy:  rp,
+		redisClient:   redisClient,
 	}
 }
 
+// SetAuditLog records every successful admin mutation to log
+func (h *Handler) SetAuditLog(log *audit.Log) {
+	h.auditLog = log
+}
+
+// audit records an admin action by the authenticated admin on target
+func (h *Handler) audit(r *http.Request, action, target string) {
+	if h.auditLog == nil {
+		return
+	}
+	admin, _ := r.Context().Value(middleware.AdminUserContextKey).(string)
+	h.auditLog.Record(r.Context(), audit.Entry{
+		Admin:    admin,
+		Action:   action,
+		Target:   target,
+		ClientIP: middleware.ClientIP(r),
+	})
+}
+
 type HealthResponse struct {
 	Status  string `json:"status"`
 	Message string `json:"message"`
+	Redis   string `json:"redis,omitempty"` // "ok" or "unreachable", only when the Redis check is enabled
 }
 
 type ServiceInfo struct {
@@ -50,6 +83,34 @@ func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 		Status:  "ok",
 		Message: "API Gateway is running",
 	}
+
+	if !h.config.Health.CheckRedis || h.redisClient == nil {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	timeout := h.config.Health.RedisTimeout
+	if timeout <= 0 {
+		timeout = 500 * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	if err := h.redisClient.Ping(ctx).Err(); err != nil {
+		resp.Redis = "unreachable"
+		if h.config.Health.RedisRequired {
+			resp.Status = "unavailable"
+			resp.Message = "Redis is unreachable"
+			writeJSON(w, http.StatusServiceUnavailable, resp)
+			return
+		}
+		resp.Status = "degraded"
+		resp.Message = "API Gateway is running without Redis"
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	resp.Redis = "ok"
 	writeJSON(w, http.StatusOK, resp)
 }
 
@@ -75,12 +136,17 @@ func (h *Handler) Info(w http.ResponseWriter, r *http.Request) {
 
 	resp := InfoResponse{
 		Status:   "ok",
-		Version:  "1.0.0",
+		Version:  version.Version,
 		Services: services,
 	}
 	writeJSON(w, http.StatusOK, resp)
 }
 
+// Version returns the build metadata of the running gateway
+func (h *Handler) Version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, version.Get())
+}
+
 func (h *Handler) ServicesHealth(w http.ResponseWriter, r *http.Request) {
 	if h.healthChecker == nil {
 		writeJSON(w, http.StatusServiceUnavailable, map[string]string{
@@ -91,9 +157,17 @@ func (h *Handler) ServicesHealth(w http.ResponseWriter, r *http.Request) {
 	}
 
 	healthStatuses := h.healthChecker.GetAllHealth()
+
+	// Count services per status, e.g. {"healthy": 3, "degraded": 1}
+	summary := make(map[health.Status]int)
+	for _, svc := range healthStatuses {
+		summary[svc.Status]++
+	}
+
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"status":   "ok",
 		"services": healthStatuses,
+		"summary":  summary,
 	})
 }
 
@@ -115,6 +189,14 @@ func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !apikey.ValidPrefix(req.Prefix) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error":   "Invalid request",
+			"message": "prefix may only contain letters, digits, '_' or '-' (max 16 characters)",
+		})
+		return
+	}
+
 	result, err := h.apiKeyMgr.CreateKey(r.Context(), &req)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{
@@ -124,6 +206,7 @@ func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.audit(r, "apikey.create", result.APIKey.ID)
 	writeJSON(w, http.StatusCreated, map[string]interface{}{
 		"status":  "success",
 		"message": "API key created. Save the raw_key - it won't be shown again!",
@@ -147,6 +230,94 @@ func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// UpdateAPIKey changes metadata (name, limits, permissions, service scopes) of an API key
+func (h *Handler) UpdateAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error":   "Invalid request",
+			"message": "id is required",
+		})
+		return
+	}
+
+	var req apikey.UpdateKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error":   "Invalid request",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	key, err := h.apiKeyMgr.UpdateKey(r.Context(), id, &req)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{
+			"error":   "Failed to update API key",
+			"message": err.Error(),
+		})
+		return
+	}
+	h.audit(r, "apikey.update", id)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   key,
+	})
+}
+
+// GetConfig returns the effective configuration with secrets redacted
+func (h *Handler) GetConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   h.config.Redacted(),
+	})
+}
+
+// RotateAPIKey issues a new secret for an API key, keeping its metadata.
+// An optional body {"grace_period_seconds": N} keeps the old secret valid for N seconds.
+func (h *Handler) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error":   "Invalid request",
+			"message": "id is required",
+		})
+		return
+	}
+
+	var req apikey.RotateKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error":   "Invalid request",
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.GracePeriodSeconds < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error":   "Invalid request",
+			"message": "grace_period_seconds must not be negative",
+		})
+		return
+	}
+
+	resp, err := h.apiKeyMgr.RotateKey(r.Context(), id, time.Duration(req.GracePeriodSeconds)*time.Second)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{
+			"error":   "Failed to rotate API key",
+			"message": err.Error(),
+		})
+		return
+	}
+	h.audit(r, "apikey.rotate", id)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   resp,
+	})
+}
+
 // RevokeAPIKey disables an API key
 func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
@@ -165,6 +336,7 @@ func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	h.audit(r, "apikey.revoke", id)
 
 	writeJSON(w, http.StatusOK, map[string]string{
 		"status":  "success",
@@ -172,6 +344,47 @@ func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// RevokeAPIKeys disables every key matching the filter in the body, e.g.
+// {"name_prefix":"ci-","created_before":"2024-01-01T00:00:00Z"}
+func (h *Handler) RevokeAPIKeys(w http.ResponseWriter, r *http.Request) {
+	var filter apikey.RevokeFilter
+	if err := json.NewDecoder(r.Body).Decode(&filter); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error":   "Invalid request",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	revoked, err := h.apiKeyMgr.RevokeMatching(r.Context(), filter)
+	if revoked > 0 {
+		// The filter identifies the keys; it marshals without error
+		target, _ := json.Marshal(filter)
+		h.audit(r, "apikey.revoke_matching", string(target))
+	}
+	if errors.Is(err, apikey.ErrEmptyFilter) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error":   "Invalid request",
+			"message": "set at least one of name_prefix, plan or created_before",
+		})
+		return
+	}
+	if err != nil {
+		// Keys revoked before the failure stay revoked
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
+			"error":   "Failed to revoke API keys",
+			"message": err.Error(),
+			"revoked": revoked,
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "success",
+		"revoked": revoked,
+	})
+}
+
 // DeleteAPIKey permanently removes an API key
 func (h *Handler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
@@ -190,6 +403,7 @@ func (h *Handler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	h.audit(r, "apikey.delete", id)
 
 	writeJSON(w, http.StatusOK, map[string]string{
 		"status":  "success",
@@ -238,6 +452,7 @@ func (h *Handler) ResetCircuitBreaker(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	h.audit(r, "circuit_breaker.reset", name)
 
 	writeJSON(w, http.StatusOK, map[string]string{
 		"status":  "success",
@@ -255,12 +470,271 @@ func (h *Handler) ResetAllCircuitBreakers(w http.ResponseWriter, r *http.Request
 	}
 
 	h.reverseProxy.ResetAllCircuitBreakers()
+	h.audit(r, "circuit_breaker.reset_all", "")
 	writeJSON(w, http.StatusOK, map[string]string{
 		"status":  "success",
 		"message": "All circuit breakers have been reset",
 	})
 }
 
+// DisableService takes a service offline on every replica until re-enabled
+func (h *Handler) DisableService(w http.ResponseWriter, r *http.Request) {
+	h.setServiceDisabled(w, r, true)
+}
+
+// EnableService restores routing to a disabled service
+func (h *Handler) EnableService(w http.ResponseWriter, r *http.Request) {
+	h.setServiceDisabled(w, r, false)
+}
+
+func (h *Handler) setServiceDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	name := r.PathValue("name")
+	if h.reverseProxy == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{
+			"error":   "Reverse proxy not available",
+			"message": "Service routing is not enabled",
+		})
+		return
+	}
+
+	if !h.reverseProxy.SetServiceDisabled(name, disabled) {
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"error":   "Not found",
+			"message": "Service '" + name + "' not found",
+		})
+		return
+	}
+
+	// Persist so other replicas pick the change up on their next sync
+	if h.redisClient != nil {
+		if err := servicestate.NewStore(h.redisClient).SetDisabled(r.Context(), name, disabled); err != nil {
+			h.reverseProxy.SetServiceDisabled(name, !disabled)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{
+				"error":   "Failed to persist service state",
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+
+	state, action := "enabled", "service.enable"
+	if disabled {
+		state, action = "disabled", "service.disable"
+	}
+	h.audit(r, action, name)
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status":  "success",
+		"message": "Service '" + name + "' has been " + state,
+	})
+}
+
+// RemoveService stops routing to a service and drops its circuit breaker,
+// metrics and health state. The removal lasts until the gateway restarts with
+// the service still configured.
+func (h *Handler) RemoveService(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if h.reverseProxy == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{
+			"error":   "Reverse proxy not available",
+			"message": "Service routing is not enabled",
+		})
+		return
+	}
+
+	if !h.reverseProxy.RemoveService(name) {
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"error":   "Not found",
+			"message": "Service '" + name + "' not found",
+		})
+		return
+	}
+	if h.healthChecker != nil {
+		h.healthChecker.RemoveService(name)
+	}
+
+	h.audit(r, "service.remove", name)
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status":  "success",
+		"message": "Service '" + name + "' has been removed",
+	})
+}
+
+// SetServiceHealth forces a service healthy or unhealthy, e.g. {"healthy":false},
+// and suspends its health probes until AutoServiceHealth
+func (h *Handler) SetServiceHealth(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if h.healthChecker == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{
+			"error":   "Health checker not available",
+			"message": "Health checking is not enabled",
+		})
+		return
+	}
+
+	var req struct {
+		Healthy *bool `json:"healthy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error":   "Invalid request",
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.Healthy == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error":   "Invalid request",
+			"message": "healthy is required",
+		})
+		return
+	}
+
+	if !h.healthChecker.SetOverride(name, *req.Healthy) {
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"error":   "Not found",
+			"message": "Service '" + name + "' not found",
+		})
+		return
+	}
+
+	state := "unhealthy"
+	if *req.Healthy {
+		state = "healthy"
+	}
+	h.audit(r, "service.health.set_"+state, name)
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status":  "success",
+		"message": "Service '" + name + "' marked " + state + " until health checks are set back to auto",
+	})
+}
+
+// AutoServiceHealth lifts a manual health override and resumes probing
+func (h *Handler) AutoServiceHealth(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if h.healthChecker == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{
+			"error":   "Health checker not available",
+			"message": "Health checking is not enabled",
+		})
+		return
+	}
+
+	if !h.healthChecker.ClearOverride(name) {
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"error":   "Not found",
+			"message": "Service '" + name + "' not found",
+		})
+		return
+	}
+	h.audit(r, "service.health.auto", name)
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status":  "success",
+		"message": "Automatic health checks resumed for '" + name + "'",
+	})
+}
+
+// ServiceMetrics combines request metrics, circuit breaker and health data for one service
+type ServiceMetrics struct {
+	Name string `json:"name"`
+	metrics.ServiceStats
+	CircuitState string `json:"circuit_state"`
+	HealthStatus string `json:"health_status,omitempty"`
+}
+
+// GetRequestRate returns gateway-wide throughput over the last second and minute
+func (h *Handler) GetRequestRate(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   metrics.Get().GetRequestRate(),
+	})
+}
+
+// services lists the services the dashboards report: the ones the proxy still
+// routes, so a removed service drops off, or the configured ones without a proxy
+func (h *Handler) services() []config.ServiceConfig {
+	if h.reverseProxy == nil {
+		return h.config.AllServices()
+	}
+	return h.reverseProxy.GetServices()
+}
+
+// GetInFlight returns the requests currently awaiting each service's backend,
+// to spot one piling up requests
+func (h *Handler) GetInFlight(w http.ResponseWriter, r *http.Request) {
+	services := make(map[string]int64)
+	var total int64
+	for _, svc := range h.services() {
+		count := metrics.Get().ServiceInFlight(svc.Name)
+		services[svc.Name] = count
+		total += count
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"total":    total,
+			"services": services,
+		},
+	})
+}
+
+// GetServiceMetrics returns a per-service dashboard, optionally filtered by ?service=name
+func (h *Handler) GetServiceMetrics(w http.ResponseWriter, r *http.Request) {
+	filter := r.URL.Query().Get("service")
+
+	circuitStates := make(map[string]string)
+	if h.reverseProxy != nil {
+		for _, stats := range h.reverseProxy.GetCircuitBreakerStats() {
+			circuitStates[stats.Name] = stats.State
+		}
+	}
+
+	result := make([]ServiceMetrics, 0, len(h.config.Services))
+	for _, svc := range h.services() {
+		if filter != "" && svc.Name != filter {
+			continue
+		}
+
+		entry := ServiceMetrics{
+			Name:         svc.Name,
+			ServiceStats: metrics.Get().GetServiceStats(svc.Name),
+			CircuitState: "closed",
+		}
+		if state, ok := circuitStates[svc.Name]; ok {
+			entry.CircuitState = state
+		}
+		if h.healthChecker != nil {
+			if health := h.healthChecker.GetHealth(svc.Name); health != nil {
+				entry.HealthStatus = string(health.Status)
+			}
+		}
+
+		result = append(result, entry)
+	}
+
+	if filter != "" && len(result) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"error":   "Not found",
+			"message": "Service '" + filter + "' not found",
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   result,
+	})
+}
+
+// AdminNotFound answers unmapped /admin/* paths so they never fall through to the proxy
+func (h *Handler) AdminNotFound(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusNotFound, map[string]string{
+		"error":   "Not found",
+		"message": "Unknown admin endpoint",
+	})
+}
+
 func writeJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -3,11 +3,14 @@ package health
 import (
 	"context"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
 
 	"github.com/bimakw/api-gateway/config"
+	"github.com/bimakw/api-gateway/internal/unixsocket"
 )
 
 type Status string
@@ -16,14 +19,25 @@ const (
 	StatusHealthy   Status = "healthy"
 	StatusUnhealthy Status = "unhealthy"
 	StatusUnknown   Status = "unknown"
+	// StatusDegraded passes its probes but answers slower than
+	// Config.DegradedThreshold; it stays in rotation
+	StatusDegraded Status = "degraded"
 )
 
+// Routable reports whether instances with this status receive traffic
+func (s Status) Routable() bool {
+	return s == StatusHealthy || s == StatusDegraded
+}
+
 type InstanceHealth struct {
 	URL          string    `json:"url"`
 	Status       Status    `json:"status"`
 	LastCheck    time.Time `json:"last_check"`
 	ResponseTime int64     `json:"response_time_ms"`
 	ErrorMessage string    `json:"error_message,omitempty"`
+
+	consecutiveSuccesses int
+	consecutiveFailures  int
 }
 
 type ServiceHealth struct {
@@ -34,10 +48,26 @@ type ServiceHealth struct {
 	LastCheck    time.Time         `json:"last_check"`
 	ResponseTime int64             `json:"response_time_ms"`       // Average response time
 	ErrorMessage string            `json:"error_message,omitempty"`
+	// Manual is set while an operator override pins the status
+	Manual bool `json:"manual,omitempty"`
 }
 
 type HealthCallback func(serviceName, instanceURL string, healthy bool)
 
+type Config struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	// HealthyThreshold is the number of consecutive passing probes before an
+	// unhealthy instance is marked healthy again
+	HealthyThreshold int
+	// UnhealthyThreshold is the number of consecutive failing probes before a
+	// healthy instance is marked unhealthy
+	UnhealthyThreshold int
+	// DegradedThreshold marks instances whose passing probe took longer than
+	// this as degraded (0 = never)
+	DegradedThreshold time.Duration
+}
+
 type Checker struct {
 	services    []config.ServiceConfig
 	healthMap   map[string]*ServiceHealth
@@ -45,14 +75,29 @@ type Checker struct {
 	mu          sync.RWMutex
 	interval    time.Duration
 	timeout     time.Duration
+	degradedAt  time.Duration
+	thresholds  map[Status]int // consecutive probes needed to enter a status
 	client      *http.Client
 	logger      *slog.Logger
 	stopCh      chan struct{}
 	callbacks   []HealthCallback
 	callbackMu  sync.RWMutex
+
+	// overrides pins a service's instances to a status and suspends probing
+	overrides map[string]Status
+
+	unixClients   map[string]*http.Client // socket path -> client
+	unixClientsMu sync.Mutex
 }
 
-func NewChecker(services []config.ServiceConfig, interval, timeout time.Duration, logger *slog.Logger) *Checker {
+func NewChecker(services []config.ServiceConfig, cfg Config, logger *slog.Logger) *Checker {
+	if cfg.HealthyThreshold <= 0 {
+		cfg.HealthyThreshold = 1
+	}
+	if cfg.UnhealthyThreshold <= 0 {
+		cfg.UnhealthyThreshold = 1
+	}
+
 	healthMap := make(map[string]*ServiceHealth)
 	instanceMap := make(map[string]map[string]*InstanceHealth)
 
@@ -89,14 +134,22 @@ func NewChecker(services []config.ServiceConfig, interval, timeout time.Duration
 		services:    services,
 		healthMap:   healthMap,
 		instanceMap: instanceMap,
-		interval:    interval,
-		timeout:     timeout,
+		interval:    cfg.Interval,
+		timeout:     cfg.Timeout,
+		degradedAt:  cfg.DegradedThreshold,
+		thresholds: map[Status]int{
+			StatusHealthy:   cfg.HealthyThreshold,
+			StatusUnhealthy: cfg.UnhealthyThreshold,
+		},
 		client: &http.Client{
-			Timeout: timeout,
+			Timeout:   cfg.Timeout,
+			Transport: newProbeTransport(cfg.Timeout),
 		},
-		logger:    logger,
-		stopCh:    make(chan struct{}),
-		callbacks: make([]HealthCallback, 0),
+		logger:      logger,
+		stopCh:      make(chan struct{}),
+		callbacks:   make([]HealthCallback, 0),
+		unixClients: make(map[string]*http.Client),
+		overrides:   make(map[string]Status),
 	}
 }
 
@@ -142,13 +195,20 @@ func (c *Checker) Stop() {
 func (c *Checker) checkAll(ctx context.Context) {
 	var wg sync.WaitGroup
 
-	for _, svc := range c.services {
+	c.mu.RLock()
+	services := c.services
+	c.mu.RUnlock()
+
+	for _, svc := range services {
+		if c.overridden(svc.Name) {
+			continue
+		}
 		backends := svc.GetBackends()
 		for _, backend := range backends {
 			wg.Add(1)
 			go func(svc config.ServiceConfig, backendURL string) {
 				defer wg.Done()
-				c.checkInstance(ctx, svc.Name, backendURL)
+				c.checkInstance(ctx, svc.Name, svc.GetHealthCheckMethod(), backendURL)
 			}(svc, backend.URL)
 		}
 	}
@@ -158,17 +218,26 @@ func (c *Checker) checkAll(ctx context.Context) {
 	c.updateAggregatedHealth()
 }
 
-func (c *Checker) checkInstance(ctx context.Context, serviceName, instanceURL string) {
+func (c *Checker) checkInstance(ctx context.Context, serviceName, method, instanceURL string) {
 	start := time.Now()
 	healthURL := instanceURL + "/health"
+	client := c.client
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
+	// Unix socket backends are probed through a socket-dialing client
+	if u, err := url.Parse(instanceURL); err == nil {
+		if socketPath, ok := unixsocket.SocketPath(u); ok {
+			healthURL = unixsocket.HTTPURL().String() + "/health"
+			client = c.unixClient(socketPath)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, healthURL, nil)
 	if err != nil {
 		c.updateInstanceHealth(serviceName, instanceURL, StatusUnhealthy, 0, err.Error())
 		return
 	}
 
-	resp, err := c.client.Do(req)
+	resp, err := client.Do(req)
 	responseTime := time.Since(start).Milliseconds()
 
 	if err != nil {
@@ -183,7 +252,11 @@ func (c *Checker) checkInstance(ctx context.Context, serviceName, instanceURL st
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		c.updateInstanceHealth(serviceName, instanceURL, StatusHealthy, responseTime, "")
+		status := StatusHealthy
+		if c.degradedAt > 0 && time.Since(start) > c.degradedAt {
+			status = StatusDegraded
+		}
+		c.updateInstanceHealth(serviceName, instanceURL, status, responseTime, "")
 		c.logger.Debug("Health check passed",
 			"service", serviceName,
 			"instance", instanceURL,
@@ -200,6 +273,36 @@ func (c *Checker) checkInstance(ctx context.Context, serviceName, instanceURL st
 	}
 }
 
+// unixClient returns the cached health check client for a unix socket backend
+func (c *Checker) unixClient(socketPath string) *http.Client {
+	c.unixClientsMu.Lock()
+	defer c.unixClientsMu.Unlock()
+
+	client, ok := c.unixClients[socketPath]
+	if !ok {
+		client = &http.Client{
+			Timeout:   c.timeout,
+			Transport: unixsocket.Transport(socketPath),
+		}
+		c.unixClients[socketPath] = client
+	}
+	return client
+}
+
+// newProbeTransport returns a transport with a connection pool of its own, so
+// probes never queue behind proxy traffic on the shared default pool and
+// report a busy backend as down. Dial, handshake and header waits are bound
+// by the probe timeout.
+func newProbeTransport(timeout time.Duration) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+	// One probe per instance at a time needs only a connection to keep alive
+	transport.MaxIdleConnsPerHost = 1
+	return transport
+}
+
 func (c *Checker) updateInstanceHealth(serviceName, instanceURL string, status Status, responseTime int64, errorMsg string) {
 	c.mu.Lock()
 
@@ -215,18 +318,45 @@ func (c *Checker) updateInstanceHealth(serviceName, instanceURL string, status S
 		return
 	}
 
-	previousStatus := instance.Status
-	statusChanged := previousStatus != status
+	// A probe that was in flight when an override was set doesn't undo it
+	if _, pinned := c.overrides[serviceName]; pinned {
+		c.mu.Unlock()
+		return
+	}
+
+	// Degraded probes passed, so they count toward the healthy threshold
+	consecutive := 0
+	threshold := c.thresholds[StatusUnhealthy]
+	if status.Routable() {
+		instance.consecutiveSuccesses++
+		instance.consecutiveFailures = 0
+		consecutive = instance.consecutiveSuccesses
+		threshold = c.thresholds[StatusHealthy]
+	} else {
+		instance.consecutiveFailures++
+		instance.consecutiveSuccesses = 0
+		consecutive = instance.consecutiveFailures
+	}
+
+	// The first probe settles an unknown instance; afterwards the status only
+	// flips once the probe result has repeated enough times in a row
+	previous := instance.Status
+	statusChanged := previous != status &&
+		(previous == StatusUnknown || consecutive >= threshold)
 
-	instance.Status = status
+	if statusChanged {
+		instance.Status = status
+	}
+	// Moving between healthy and degraded doesn't change routing
+	notify := statusChanged && (previous == StatusUnknown || previous.Routable() != status.Routable())
 	instance.LastCheck = time.Now()
 	instance.ResponseTime = responseTime
 	instance.ErrorMessage = errorMsg
 
 	c.mu.Unlock()
 
-	if statusChanged {
-		c.notifyCallbacks(serviceName, instanceURL, status == StatusHealthy)
+	if notify {
+		c.notifyCallbacks(serviceName, instanceURL, status.Routable())
 	}
 }
 
@@ -242,7 +372,7 @@ func (c *Checker) updateAggregatedHealth() {
 
 		// Collect instance health
 		instances := make([]*InstanceHealth, 0, len(instanceMap))
-		healthyCount := 0
+		healthyCount, routableCount := 0, 0
 		totalResponseTime := int64(0)
 		var latestCheck time.Time
 		var latestError string
@@ -258,6 +388,9 @@ func (c *Checker) updateAggregatedHealth() {
 
 			if instance.Status == StatusHealthy {
 				healthyCount++
+			}
+			if instance.Status.Routable() {
+				routableCount++
 			} else if instance.ErrorMessage != "" {
 				latestError = instance.ErrorMessage
 			}
@@ -270,11 +403,13 @@ func (c *Checker) updateAggregatedHealth() {
 
 		// Determine aggregated status
 		var aggregatedStatus Status
-		if healthyCount == len(instanceMap) {
-			aggregatedStatus = StatusHealthy
+		if routableCount == len(instanceMap) {
 			latestError = ""
-		} else if healthyCount > 0 {
+		}
+		if healthyCount > 0 {
 			aggregatedStatus = StatusHealthy // Partially healthy is still healthy (at least one backend works)
+		} else if routableCount > 0 {
+			aggregatedStatus = StatusDegraded // Every working backend is slow
 		} else {
 			aggregatedStatus = StatusUnhealthy
 		}
@@ -284,6 +419,7 @@ func (c *Checker) updateAggregatedHealth() {
 		health.LastCheck = latestCheck
 		health.ResponseTime = totalResponseTime / int64(len(instanceMap))
 		health.ErrorMessage = latestError
+		_, health.Manual = c.overrides[serviceName]
 	}
 }
 
@@ -305,7 +441,7 @@ func (c *Checker) checkService(ctx context.Context, svc config.ServiceConfig) {
 	if len(backends) == 0 {
 		return
 	}
-	c.checkInstance(ctx, svc.Name, backends[0].URL)
+	c.checkInstance(ctx, svc.Name, svc.GetHealthCheckMethod(), backends[0].URL)
 }
 
 func (c *Checker) GetHealth(name string) *ServiceHealth {
@@ -332,6 +468,7 @@ func (c *Checker) GetHealth(name string) *ServiceHealth {
 			LastCheck:    health.LastCheck,
 			ResponseTime: health.ResponseTime,
 			ErrorMessage: health.ErrorMessage,
+			Manual:       health.Manual,
 		}
 	}
 	return nil
@@ -361,6 +498,7 @@ func (c *Checker) GetAllHealth() []*ServiceHealth {
 			LastCheck:    health.LastCheck,
 			ResponseTime: health.ResponseTime,
 			ErrorMessage: health.ErrorMessage,
+			Manual:       health.Manual,
 		})
 	}
 	return result
@@ -371,7 +509,7 @@ func (c *Checker) IsHealthy(name string) bool {
 	defer c.mu.RUnlock()
 
 	if health, ok := c.healthMap[name]; ok {
-		return health.Status == StatusHealthy
+		return health.Status.Routable()
 	}
 	return false
 }
@@ -0,0 +1,303 @@
+package health
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bimakw/api-gateway/config"
+)
+
+// newToggleBackend serves /health with 200 while healthy is true, 503 otherwise
+func newToggleBackend(t *testing.T, healthy *atomic.Bool) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if healthy.Load() {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestChecker(backendURL string, healthyThreshold, unhealthyThreshold int) *Checker {
+	services := []config.ServiceConfig{{Name: "svc", TargetURL: backendURL}}
+	return NewChecker(services, Config{
+		Interval:           time.Minute,
+		Timeout:            time.Second,
+		HealthyThreshold:   healthyThreshold,
+		UnhealthyThreshold: unhealthyThreshold,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestHealthyThresholdPreventsFlapping(t *testing.T) {
+	var healthy atomic.Bool
+	backend := newToggleBackend(t, &healthy)
+	c := newTestChecker(backend.URL, 2, 1)
+
+	var flips []bool
+	c.RegisterCallback(func(_, _ string, h bool) { flips = append(flips, h) })
+
+	ctx := context.Background()
+
+	// The first probe settles the unknown state immediately
+	c.checkAll(ctx)
+	if c.IsHealthy("svc") {
+		t.Fatal("expected service to start unhealthy")
+	}
+
+	// A single passing probe is not enough to return to rotation
+	healthy.Store(true)
+	c.checkAll(ctx)
+	if c.IsHealthy("svc") {
+		t.Error("service flipped healthy after one passing probe, want two")
+	}
+
+	c.checkAll(ctx)
+	if !c.IsHealthy("svc") {
+		t.Error("expected service healthy after two consecutive passing probes")
+	}
+
+	if len(flips) != 2 || flips[0] || !flips[1] {
+		t.Errorf("callbacks = %v, want [false true]", flips)
+	}
+}
+
+func TestUnhealthyThresholdResetsOnSuccess(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	backend := newToggleBackend(t, &healthy)
+	c := newTestChecker(backend.URL, 1, 3)
+
+	ctx := context.Background()
+	c.checkAll(ctx)
+
+	// Two failures, a success, then two more failures never reach three in a row
+	for _, pass := range []bool{false, false, true, false, false} {
+		healthy.Store(pass)
+		c.checkAll(ctx)
+		if !c.IsHealthy("svc") {
+			t.Fatal("service marked unhealthy before three consecutive failures")
+		}
+	}
+
+	c.checkAll(ctx)
+	if c.IsHealthy("svc") {
+		t.Error("expected service unhealthy after three consecutive failures")
+	}
+}
+
+func TestCheckUnixSocketInstance(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "backend.sock")
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+
+	var probedPath string
+	backend := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probedPath = r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	})}
+	go backend.Serve(ln)
+	t.Cleanup(func() { backend.Close() })
+
+	c := newTestChecker("unix://"+socketPath, 1, 1)
+	c.checkAll(context.Background())
+
+	if !c.IsHealthy("svc") {
+		t.Errorf("expected unix socket backend healthy, got %+v", c.GetHealth("svc"))
+	}
+	if probedPath != "/health" {
+		t.Errorf("probed path = %q, want /health", probedPath)
+	}
+}
+
+func TestHealthCheckMethod(t *testing.T) {
+	methods := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods <- r.Method
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(backend.Close)
+
+	c := NewChecker([]config.ServiceConfig{
+		{Name: "svc", TargetURL: backend.URL, HealthCheckMethod: "head"},
+	}, Config{Interval: time.Minute, Timeout: time.Second}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	c.checkAll(context.Background())
+
+	if got := <-methods; got != http.MethodHead {
+		t.Errorf("probe method = %s, want HEAD", got)
+	}
+	if !c.IsHealthy("svc") {
+		t.Errorf("expected HEAD-probed backend healthy, got %+v", c.GetHealth("svc"))
+	}
+}
+
+func TestCheckerUsesIsolatedTransport(t *testing.T) {
+	c := newTestChecker("http://localhost:1", 1, 1)
+
+	transport, ok := c.client.Transport.(*http.Transport)
+	if !ok || transport == http.DefaultTransport {
+		t.Fatalf("checker transport = %T %p, want a dedicated *http.Transport", c.client.Transport, c.client.Transport)
+	}
+	if transport.ResponseHeaderTimeout != time.Second || transport.TLSHandshakeTimeout != time.Second {
+		t.Errorf("transport timeouts = %v/%v, want the 1s probe timeout",
+			transport.ResponseHeaderTimeout, transport.TLSHandshakeTimeout)
+	}
+
+	other := newTestChecker("http://localhost:1", 1, 1)
+	if other.client.Transport == c.client.Transport {
+		t.Error("checkers share a transport, want one pool each")
+	}
+}
+
+func TestSlowProbeMarksDegraded(t *testing.T) {
+	var slow atomic.Bool
+	slow.Store(true)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slow.Load() {
+			time.Sleep(30 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(backend.Close)
+
+	c := NewChecker([]config.ServiceConfig{{Name: "svc", TargetURL: backend.URL}}, Config{
+		Interval:          time.Minute,
+		Timeout:           time.Second,
+		DegradedThreshold: 10 * time.Millisecond,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var routable []bool
+	c.RegisterCallback(func(_, _ string, h bool) { routable = append(routable, h) })
+
+	c.checkAll(context.Background())
+	if got := c.GetHealth("svc").Status; got != StatusDegraded {
+		t.Fatalf("service status = %s, want degraded", got)
+	}
+	if got := c.GetInstanceHealth("svc", backend.URL).Status; got != StatusDegraded {
+		t.Errorf("instance status = %s, want degraded", got)
+	}
+	if !c.IsHealthy("svc") {
+		t.Error("degraded service should stay routable")
+	}
+
+	slow.Store(false)
+	c.checkAll(context.Background())
+	if got := c.GetHealth("svc").Status; got != StatusHealthy {
+		t.Errorf("service status = %s after a fast probe, want healthy", got)
+	}
+	if len(routable) != 1 || !routable[0] {
+		t.Errorf("callbacks = %v, want a single routable notification", routable)
+	}
+}
+
+func TestOverrideHoldsUntilAuto(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	var probes atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(backend.Close)
+	c := newTestChecker(backend.URL, 2, 2)
+
+	var flips []bool
+	c.RegisterCallback(func(_, _ string, h bool) { flips = append(flips, h) })
+
+	ctx := context.Background()
+	c.checkAll(ctx)
+	if !c.IsHealthy("svc") {
+		t.Fatal("expected service healthy before the override")
+	}
+
+	if c.SetOverride("missing", false) || c.ClearOverride("missing") {
+		t.Error("override accepted for an unknown service")
+	}
+	if !c.SetOverride("svc", false) {
+		t.Fatal("SetOverride() = false for a known service")
+	}
+
+	before := probes.Load()
+	for i := 0; i < 3; i++ {
+		c.checkAll(ctx)
+	}
+	if got := probes.Load() - before; got != 0 {
+		t.Errorf("probes while overridden = %d, want 0", got)
+	}
+	if h := c.GetHealth("svc"); h.Status != StatusUnhealthy || !h.Manual {
+		t.Errorf("health during override = %s (manual %v), want unhealthy and manual", h.Status, h.Manual)
+	}
+
+	// Back to automatic: the next probe settles the status at once
+	c.ClearOverride("svc")
+	c.checkAll(ctx)
+	if probes.Load() == before {
+		t.Error("probing did not resume after ClearOverride")
+	}
+	if h := c.GetHealth("svc"); h.Status != StatusHealthy || h.Manual {
+		t.Errorf("health after auto = %s (manual %v), want healthy and not manual", h.Status, h.Manual)
+	}
+
+	// A forced healthy status also survives failing probes
+	healthy.Store(false)
+	c.SetOverride("svc", true)
+	c.checkAll(ctx)
+	c.checkAll(ctx)
+	if !c.IsHealthy("svc") {
+		t.Error("forced healthy service flipped unhealthy")
+	}
+
+	if len(flips) != 3 || !flips[0] || flips[1] || !flips[2] {
+		t.Errorf("callbacks = %v, want [true false true]", flips)
+	}
+}
+
+func TestRemoveServiceStopsProbing(t *testing.T) {
+	var removedHits atomic.Int32
+	removed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		removedHits.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(removed.Close)
+	var healthy atomic.Bool
+	healthy.Store(true)
+	kept := newToggleBackend(t, &healthy)
+
+	c := NewChecker([]config.ServiceConfig{
+		{Name: "removed", TargetURL: removed.URL},
+		{Name: "kept", TargetURL: kept.URL},
+	}, Config{Interval: time.Minute, Timeout: time.Second}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	if !c.RemoveService("removed") {
+		t.Fatal("RemoveService() = false, want true")
+	}
+	if c.RemoveService("removed") {
+		t.Error("second RemoveService() = true, want false")
+	}
+	c.checkAll(context.Background())
+
+	if got := removedHits.Load(); got != 0 {
+		t.Errorf("removed service probed %d times, want 0", got)
+	}
+	if !c.IsHealthy("kept") {
+		t.Errorf("remaining service health = %+v, want healthy", c.GetHealth("kept"))
+	}
+	all := c.GetAllHealth()
+	if len(all) != 1 || all[0].Name != "kept" {
+		t.Errorf("GetAllHealth() = %d services, want only kept", len(all))
+	}
+}
@@ -0,0 +1,75 @@
+package health
+
+// manualUnhealthyMessage explains a forced unhealthy status in health output
+const manualUnhealthyMessage = "marked unhealthy by an operator"
+
+// SetOverride pins every instance of a service to healthy or unhealthy and
+// stops probing it until ClearOverride. Registered callbacks are notified of
+// the instances whose status changed, so load balancers follow the override.
+// Returns false for an unknown service.
+func (c *Checker) SetOverride(name string, healthy bool) bool {
+	status := StatusUnhealthy
+	errorMsg := manualUnhealthyMessage
+	if healthy {
+		status = StatusHealthy
+		errorMsg = ""
+	}
+
+	c.mu.Lock()
+	instances, ok := c.instanceMap[name]
+	if !ok {
+		c.mu.Unlock()
+		return false
+	}
+	c.overrides[name] = status
+
+	var changed []string
+	for url, instance := range instances {
+		instance.consecutiveSuccesses = 0
+		instance.consecutiveFailures = 0
+		instance.ErrorMessage = errorMsg
+		if instance.Status != status {
+			instance.Status = status
+			changed = append(changed, url)
+		}
+	}
+	c.mu.Unlock()
+
+	c.updateAggregatedHealth()
+	for _, url := range changed {
+		c.notifyCallbacks(name, url, healthy)
+	}
+	return true
+}
+
+// ClearOverride returns a service to automatic checking. Its instances become
+// unknown so the next probe settles them regardless of thresholds. Returns
+// false for an unknown service.
+func (c *Checker) ClearOverride(name string) bool {
+	c.mu.Lock()
+	instances, ok := c.instanceMap[name]
+	if !ok {
+		c.mu.Unlock()
+		return false
+	}
+	if _, pinned := c.overrides[name]; pinned {
+		delete(c.overrides, name)
+		for _, instance := range instances {
+			instance.Status = StatusUnknown
+			instance.ErrorMessage = ""
+			instance.consecutiveSuccesses = 0
+			instance.consecutiveFailures = 0
+		}
+	}
+	c.mu.Unlock()
+
+	c.updateAggregatedHealth()
+	return true
+}
+
+func (c *Checker) overridden(name string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, pinned := c.overrides[name]
+	return pinned
+}
@@ -0,0 +1,27 @@
+package health
+
+import (
+	"slices"
+
+	"github.com/bimakw/api-gateway/config"
+)
+
+// RemoveService stops probing a service and drops its health state, so it no
+// longer shows up in health output. Probes already in flight are discarded.
+// Returns false for an unknown service.
+func (c *Checker) RemoveService(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.healthMap[name]; !ok {
+		return false
+	}
+	delete(c.healthMap, name)
+	delete(c.instanceMap, name)
+	delete(c.overrides, name)
+	// A fresh slice, so a checkAll ranging over the old one isn't disturbed
+	c.services = slices.DeleteFunc(slices.Clone(c.services), func(svc config.ServiceConfig) bool {
+		return svc.Name == name
+	})
+	return true
+}
@@ -0,0 +1,71 @@
+package loadbalancer
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// virtualNodes is the number of ring points per unit of backend weight
+const virtualNodes = 100
+
+// IPHashSelector pins each client key to a backend via a consistent hash ring,
+// so adding, removing or failing a backend only remaps that backend's clients
+type IPHashSelector struct {
+	backends []*Backend
+	ring     []ringPoint // sorted by hash
+}
+
+type ringPoint struct {
+	hash    uint32
+	backend *Backend
+}
+
+func NewIPHashSelector(backends []*Backend) *IPHashSelector {
+	s := &IPHashSelector{backends: backends}
+	for _, b := range backends {
+		for i := 0; i < virtualNodes*effectiveWeight(b); i++ {
+			s.ring = append(s.ring, ringPoint{
+				hash:    crc32.ChecksumIEEE([]byte(b.URL.String() + "#" + strconv.Itoa(i))),
+				backend: b,
+			})
+		}
+	}
+	sort.Slice(s.ring, func(i, j int) bool { return s.ring[i].hash < s.ring[j].hash })
+	return s
+}
+
+// Select has no client key to hash, so it behaves like an empty key
+func (s *IPHashSelector) Select() *Backend {
+	return s.SelectFor("")
+}
+
+// SelectFor returns the first healthy backend clockwise from key's position on the ring
+func (s *IPHashSelector) SelectFor(key string) *Backend {
+	if len(s.ring) == 0 {
+		return nil
+	}
+
+	h := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= h })
+	for i := 0; i < len(s.ring); i++ {
+		p := s.ring[(start+i)%len(s.ring)]
+		if p.backend.IsHealthy {
+			return p.backend
+		}
+	}
+	return nil
+}
+
+func (s *IPHashSelector) SetHealthy(urlStr string, healthy bool) {
+	for _, b := range s.backends {
+		if b.URL.String() == urlStr {
+			b.IsHealthy = healthy
+			return
+		}
+	}
+}
+
+func (s *IPHashSelector) GetBackends() []*Backend {
+	return s.backends
+}
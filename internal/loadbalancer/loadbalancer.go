@@ -3,12 +3,17 @@ package loadbalancer
 import (
 	"net/url"
 	"sync"
+	"time"
+
+	"github.com/bimakw/api-gateway/internal/clock"
 )
 
 type Backend struct {
 	URL       *url.URL
 	Weight    int
 	IsHealthy bool
+	// HealthySince is when the backend last turned healthy (zero = since start)
+	HealthySince time.Time
 }
 
 // Selector defines the interface for load balancing strategies
@@ -21,10 +26,33 @@ type Selector interface {
 	GetBackends() []*Backend
 }
 
+// KeyedSelector is implemented by strategies that route by a client key (e.g. ip-hash)
+type KeyedSelector interface {
+	// SelectFor returns the healthy backend assigned to key, or nil if none available
+	SelectFor(key string) *Backend
+}
+
+// Strategies lists the supported load balancing strategy names
+var Strategies = []string{"round-robin", "random", "weighted-random", "ip-hash"}
+
+// ValidStrategy reports whether name is a known strategy
+func ValidStrategy(name string) bool {
+	for _, s := range Strategies {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
 // LoadBalancer manages backend selection with health awareness
 type LoadBalancer struct {
 	selector Selector
 	mu       sync.RWMutex
+
+	// slowStart is the ramp-up window for backends that turn healthy (0 = off)
+	slowStart time.Duration
+	clock     clock.Clock
 }
 
 func New(strategy string, backends []*Backend) *LoadBalancer {
@@ -33,6 +61,10 @@ func New(strategy string, backends []*Backend) *LoadBalancer {
 	switch strategy {
 	case "random":
 		selector = NewRandomSelector(backends)
+	case "weighted-random":
+		selector = NewWeightedRandomSelector(backends)
+	case "ip-hash":
+		selector = NewIPHashSelector(backends)
 	default:
 		// Default to round-robin
 		selector = NewRoundRobinSelector(backends)
@@ -40,18 +72,39 @@ func New(strategy string, backends []*Backend) *LoadBalancer {
 
 	return &LoadBalancer{
 		selector: selector,
+		clock:    clock.Real,
 	}
 }
 
 func (lb *LoadBalancer) Select() *Backend {
 	lb.mu.RLock()
 	defer lb.mu.RUnlock()
-	return lb.selector.Select()
+	return lb.selectWithSlowStart()
+}
+
+// SelectFor picks a backend for a client key. Strategies that don't route by
+// key ignore it and fall back to Select.
+func (lb *LoadBalancer) SelectFor(key string) *Backend {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	if keyed, ok := lb.selector.(KeyedSelector); ok {
+		return keyed.SelectFor(key)
+	}
+	return lb.selectWithSlowStart()
 }
 
 func (lb *LoadBalancer) SetHealthy(urlStr string, healthy bool) {
 	lb.mu.Lock()
 	defer lb.mu.Unlock()
+
+	if healthy {
+		// Start the slow-start window on the unhealthy -> healthy transition
+		for _, b := range lb.selector.GetBackends() {
+			if b.URL.String() == urlStr && !b.IsHealthy {
+				b.HealthySince = lb.clock.Now()
+			}
+		}
+	}
 	lb.selector.SetHealthy(urlStr, healthy)
 }
 
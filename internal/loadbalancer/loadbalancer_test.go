@@ -2,8 +2,12 @@ package loadbalancer
 
 import (
 	"net/url"
+	"strconv"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/bimakw/api-gateway/internal/clock"
 )
 
 func mustParseURL(rawURL string) *url.URL {
@@ -264,3 +268,177 @@ func BenchmarkRandomSelect(b *testing.B) {
 		lb.Select()
 	}
 }
+
+func createWeightedBackends() []*Backend {
+	return []*Backend{
+		{URL: mustParseURL("http://backend1:8080"), Weight: 1, IsHealthy: true},
+		{URL: mustParseURL("http://backend2:8080"), Weight: 3, IsHealthy: true},
+		{URL: mustParseURL("http://backend3:8080"), Weight: 6, IsHealthy: true},
+	}
+}
+
+func TestWeightedRandomDistribution(t *testing.T) {
+	lb := New("weighted-random", createWeightedBackends())
+
+	counts := make(map[string]int)
+	iterations := 20000
+
+	for i := 0; i < iterations; i++ {
+		backend := lb.Select()
+		if backend == nil {
+			t.Fatal("expected backend, got nil")
+		}
+		counts[backend.URL.String()]++
+	}
+
+	want := map[string]float64{
+		"http://backend1:8080": 0.1,
+		"http://backend2:8080": 0.3,
+		"http://backend3:8080": 0.6,
+	}
+	for url, share := range want {
+		got := float64(counts[url]) / float64(iterations)
+		if got < share-0.03 || got > share+0.03 {
+			t.Errorf("backend %s share = %.3f, want ~%.1f", url, got, share)
+		}
+	}
+}
+
+func TestWeightedRandomSkipsUnhealthy(t *testing.T) {
+	lb := New("weighted-random", createWeightedBackends())
+
+	lb.SetHealthy("http://backend3:8080", false)
+
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		backend := lb.Select()
+		if backend == nil {
+			t.Fatal("expected backend, got nil")
+		}
+		counts[backend.URL.String()]++
+	}
+
+	if counts["http://backend3:8080"] != 0 {
+		t.Error("weighted random selector selected unhealthy backend")
+	}
+	if counts["http://backend1:8080"] == 0 || counts["http://backend2:8080"] == 0 {
+		t.Errorf("expected remaining backends to share traffic, got %v", counts)
+	}
+
+	lb.SetHealthy("http://backend1:8080", false)
+	lb.SetHealthy("http://backend2:8080", false)
+	if backend := lb.Select(); backend != nil {
+		t.Errorf("expected nil with all backends unhealthy, got %s", backend.URL)
+	}
+
+	lb.SetHealthy("http://backend3:8080", true)
+	if backend := lb.Select(); backend == nil || backend.URL.String() != "http://backend3:8080" {
+		t.Error("expected recovered backend to be selected")
+	}
+}
+
+func TestIPHashIsConsistent(t *testing.T) {
+	lb := New("ip-hash", createTestBackends())
+
+	assigned := make(map[string]string)
+	used := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		client := "10.0.0." + strconv.Itoa(i)
+		b := lb.SelectFor(client)
+		if b == nil {
+			t.Fatal("expected backend, got nil")
+		}
+		assigned[client] = b.URL.String()
+		used[b.URL.String()] = true
+
+		if again := lb.SelectFor(client); again != b {
+			t.Fatalf("client %s moved from %s to %s", client, b.URL, again.URL)
+		}
+	}
+	if len(used) != 3 {
+		t.Errorf("clients spread over %d backends, want 3", len(used))
+	}
+
+	// Failing one backend only remaps the clients that were pinned to it
+	lb.SetHealthy("http://backend2:8080", false)
+	for client, before := range assigned {
+		after := lb.SelectFor(client).URL.String()
+		if before == "http://backend2:8080" {
+			if after == before {
+				t.Errorf("client %s still routed to unhealthy backend", client)
+			}
+		} else if after != before {
+			t.Errorf("client %s moved from %s to %s though its backend is healthy", client, before, after)
+		}
+	}
+}
+
+func TestIPHashAllUnhealthy(t *testing.T) {
+	backends := createTestBackends()
+	lb := New("ip-hash", backends)
+	for _, b := range backends {
+		lb.SetHealthy(b.URL.String(), false)
+	}
+	if b := lb.SelectFor("10.0.0.1"); b != nil {
+		t.Errorf("SelectFor() = %s, want nil when all backends are unhealthy", b.URL)
+	}
+}
+
+func TestValidStrategy(t *testing.T) {
+	for _, name := range []string{"round-robin", "random", "weighted-random", "ip-hash"} {
+		if !ValidStrategy(name) {
+			t.Errorf("ValidStrategy(%q) = false, want true", name)
+		}
+	}
+	for _, name := range []string{"", "least-conn", "Round-Robin"} {
+		if ValidStrategy(name) {
+			t.Errorf("ValidStrategy(%q) = true, want false", name)
+		}
+	}
+}
+
+func TestSlowStartRampsUpNewlyHealthyBackend(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	backends := createTestBackends()[:2]
+	lb := New("round-robin", backends)
+	lb.clock = clk
+	lb.SetSlowStart(time.Minute)
+
+	recovered := "http://backend2:8080"
+	lb.SetHealthy(recovered, false)
+	lb.SetHealthy(recovered, true)
+
+	share := func() float64 {
+		hits := 0
+		for i := 0; i < 2000; i++ {
+			if lb.Select().URL.String() == recovered {
+				hits++
+			}
+		}
+		return float64(hits) / 2000
+	}
+
+	// Round-robin would give it half; right after recovery it gets ~10% of that
+	if got := share(); got > 0.15 {
+		t.Errorf("share right after recovery = %.2f, want a reduced share", got)
+	}
+
+	clk.Advance(time.Minute)
+	if got := share(); got < 0.45 || got > 0.55 {
+		t.Errorf("share after the window = %.2f, want ~0.5", got)
+	}
+}
+
+func TestSlowStartReturnsOnlyHealthyBackend(t *testing.T) {
+	backends := createTestBackends()[:1]
+	lb := New("round-robin", backends)
+	lb.SetSlowStart(time.Minute)
+	lb.SetHealthy("http://backend1:8080", false)
+	lb.SetHealthy("http://backend1:8080", true)
+
+	for i := 0; i < 100; i++ {
+		if lb.Select() == nil {
+			t.Fatal("Select() = nil, want the ramping backend when it's the only one")
+		}
+	}
+}
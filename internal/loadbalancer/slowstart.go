@@ -0,0 +1,51 @@
+package loadbalancer
+
+import (
+	"math/rand"
+	"time"
+)
+
+// slowStartMinFraction is the share of its normal traffic a backend gets
+// right after turning healthy
+const slowStartMinFraction = 0.1
+
+// SetSlowStart makes backends that turn healthy ramp up from a tenth of their
+// normal share to all of it over window (0 = disabled). Keyed strategies
+// (ip-hash) are unaffected so client affinity is kept.
+func (lb *LoadBalancer) SetSlowStart(window time.Duration) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.slowStart = window
+}
+
+// rampFraction returns the share of its normal traffic b should get at now
+func (lb *LoadBalancer) rampFraction(b *Backend, now time.Time) float64 {
+	if lb.slowStart <= 0 || b.HealthySince.IsZero() {
+		return 1
+	}
+	elapsed := now.Sub(b.HealthySince)
+	if elapsed >= lb.slowStart {
+		return 1
+	}
+	return slowStartMinFraction + (1-slowStartMinFraction)*float64(elapsed)/float64(lb.slowStart)
+}
+
+// selectWithSlowStart asks the selector again, with a probability that
+// shrinks as the backend warms up, when it picks a backend still ramping up.
+// A ramping backend that keeps being picked, e.g. the only healthy one, is
+// returned after a bounded number of tries. Callers must hold lb.mu.
+func (lb *LoadBalancer) selectWithSlowStart() *Backend {
+	b := lb.selector.Select()
+	if lb.slowStart <= 0 {
+		return b
+	}
+
+	now := lb.clock.Now()
+	for tries := len(lb.selector.GetBackends()); b != nil && tries > 0; tries-- {
+		if rand.Float64() < lb.rampFraction(b, now) {
+			return b
+		}
+		b = lb.selector.Select()
+	}
+	return b
+}
@@ -0,0 +1,73 @@
+package loadbalancer
+
+import (
+	"math/rand"
+)
+
+// WeightedRandomSelector picks healthy backends with probability proportional to their weight
+type WeightedRandomSelector struct {
+	backends    []*Backend
+	totalWeight int
+}
+
+func NewWeightedRandomSelector(backends []*Backend) *WeightedRandomSelector {
+	w := &WeightedRandomSelector{
+		backends: backends,
+	}
+	w.recalculate()
+	return w
+}
+
+// Returns nil if no healthy backends are available
+func (w *WeightedRandomSelector) Select() *Backend {
+	if w.totalWeight == 0 {
+		return nil
+	}
+
+	// Walk the cumulative weights of healthy backends until we pass the sample
+	n := rand.Intn(w.totalWeight)
+	for _, b := range w.backends {
+		if !b.IsHealthy {
+			continue
+		}
+		n -= effectiveWeight(b)
+		if n < 0 {
+			return b
+		}
+	}
+
+	return nil
+}
+
+func (w *WeightedRandomSelector) SetHealthy(urlStr string, healthy bool) {
+	for _, b := range w.backends {
+		if b.URL.String() == urlStr {
+			b.IsHealthy = healthy
+			w.recalculate()
+			return
+		}
+	}
+}
+
+func (w *WeightedRandomSelector) GetBackends() []*Backend {
+	return w.backends
+}
+
+// recalculate sums the weights of healthy backends
+func (w *WeightedRandomSelector) recalculate() {
+	total := 0
+	for _, b := range w.backends {
+		if b.IsHealthy {
+			total += effectiveWeight(b)
+		}
+	}
+	w.totalWeight = total
+}
+
+// effectiveWeight treats unset or invalid weights as 1
+func effectiveWeight(b *Backend) int {
+	if b.Weight <= 0 {
+		return 1
+	}
+	return b.Weight
+}
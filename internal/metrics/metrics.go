@@ -1,31 +1,71 @@
 package metrics
 
 import (
+	"bufio"
+	"maps"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/bimakw/api-gateway/internal/clock"
 )
 
 type Metrics struct {
 	mu sync.RWMutex
 
 	// Request counters
-	requestsTotal     map[string]int64 // key: "method:path:status"
-	requestsInFlight  int64
-	requestDurations  []durationRecord
+	requestsTotal    map[string]int64 // key: "method:path:status"
+	requestsInFlight int64
+	requestDurations []durationRecord
 
 	// Rate limiter metrics
 	rateLimitedTotal int64
+	rateLimitAllowed map[string]int64 // dimension -> requests admitted by the limiter
+	rateLimitDenied  map[string]int64 // dimension -> requests rejected with 429
+
+	// API key validation metrics
+	apiKeyValidations int64
+	apiKeyFailures    map[string]int64 // reason -> rejected keys
 
 	// Circuit breaker metrics
-	circuitBreakerState   map[string]string // service -> state
-	circuitBreakerTrips   map[string]int64  // service -> trip count
+	circuitBreakerState         map[string]string // service -> state
+	circuitBreakerTrips         map[string]int64  // service -> trip count
+	circuitBreakerShortCircuits map[string]int64  // service -> requests rejected by an open breaker
+	circuitBreakerSuccesses     map[string]int64  // service -> successes recorded by the breaker
+	circuitBreakerFailures      map[string]int64  // service -> failures recorded by the breaker
 
 	// Service metrics
-	serviceRequestsTotal    map[string]int64 // service -> count
-	serviceErrorsTotal      map[string]int64 // service -> error count
-	serviceLatencies        map[string][]float64 // service -> latencies in ms
+	serviceRequestsTotal     map[string]int64      // service -> count
+	serviceErrorsTotal       map[string]int64      // service -> error count
+	serviceLatencyHistograms map[string]*histogram // service -> latencies in ms
+	serviceInFlight          map[string]int64      // service -> requests awaiting the backend
+
+	// Backend connection limits
+	backendConnections         map[string]map[string]int64 // service -> backend -> slots in use
+	backendConnLimitRejections map[string]int64            // service -> requests shed at the limit
+
+	// removedServices are never recorded again, so requests and breakers
+	// outliving RemoveService don't bring their series back
+	removedServices map[string]bool
+
+	// Latency histograms for the standard Prometheus exposition
+	requestHistograms map[routeKey]*histogram
+	serviceHistograms map[string]*histogram
+
+	// Route templates (e.g. /api/users/:id) used to normalize paths before the ID heuristic
+	routePatterns [][]string
+
+	// standardFormat switches /metrics to Prometheus client naming conventions
+	standardFormat bool
+
+	// requestRate tracks per-second request counts for throughput
+	requestRate rateWindow
+	clock       clock.Clock
+
+	// redisPoolStats reports the Redis connection pool (nil = not exposed)
+	redisPoolStats func() RedisPoolStats
 
 	startTime time.Time
 }
@@ -46,29 +86,68 @@ var (
 
 func Get() *Metrics {
 	once.Do(func() {
-		instance = &Metrics{
-			requestsTotal:         make(map[string]int64),
-			requestDurations:      make([]durationRecord, 0),
-			circuitBreakerState:   make(map[string]string),
-			circuitBreakerTrips:   make(map[string]int64),
-			serviceRequestsTotal:  make(map[string]int64),
-			serviceErrorsTotal:    make(map[string]int64),
-			serviceLatencies:      make(map[string][]float64),
-			startTime:             time.Now(),
-		}
+		instance = newMetrics()
 	})
 	return instance
 }
 
+func newMetrics() *Metrics {
+	return &Metrics{
+		requestsTotal:               make(map[string]int64),
+		requestDurations:            make([]durationRecord, 0),
+		rateLimitAllowed:            make(map[string]int64),
+		rateLimitDenied:             make(map[string]int64),
+		apiKeyFailures:              make(map[string]int64),
+		circuitBreakerState:         make(map[string]string),
+		circuitBreakerTrips:         make(map[string]int64),
+		circuitBreakerShortCircuits: make(map[string]int64),
+		circuitBreakerSuccesses:     make(map[string]int64),
+		circuitBreakerFailures:      make(map[string]int64),
+		serviceRequestsTotal:        make(map[string]int64),
+		serviceErrorsTotal:          make(map[string]int64),
+		serviceLatencyHistograms:    make(map[string]*histogram),
+		serviceInFlight:             make(map[string]int64),
+		backendConnections:          make(map[string]map[string]int64),
+		backendConnLimitRejections:  make(map[string]int64),
+		removedServices:             make(map[string]bool),
+		requestHistograms:           make(map[routeKey]*histogram),
+		serviceHistograms:           make(map[string]*histogram),
+		clock:                       clock.Real,
+		startTime:                   time.Now(),
+	}
+}
+
+// SetRoutePatterns configures route templates such as "/api/users/:id".
+// A request path matching a template is recorded as the template itself;
+// other paths fall back to the numeric/UUID heuristic.
+func (m *Metrics) SetRoutePatterns(patterns []string) {
+	parsed := make([][]string, 0, len(patterns))
+	for _, p := range patterns {
+		if p == "" {
+			continue
+		}
+		parsed = append(parsed, strings.Split(p, "/"))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.routePatterns = parsed
+}
+
 func (m *Metrics) RecordRequest(method, path string, status int, duration time.Duration) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Normalize path for metrics (remove IDs, etc)
-	normalizedPath := normalizePath(path)
+	// Normalize path for metrics (route templates first, then remove IDs, etc)
+	normalizedPath, ok := matchRoutePattern(m.routePatterns, path)
+	if !ok {
+		normalizedPath = normalizePath(path)
+	}
 
 	key := method + ":" + normalizedPath + ":" + strconv.Itoa(status)
 	m.requestsTotal[key]++
+	m.requestRate.add(m.clock.Now())
+	m.histogramFor(routeKey{method, normalizedPath}).observe(duration.Seconds())
 
 	// Keep last 1000 duration records for percentile calculation
 	record := durationRecord{
@@ -87,6 +166,9 @@ func (m *Metrics) RecordRequest(method, path string, status int, duration time.D
 func (m *Metrics) RecordServiceRequest(serviceName string, status int, latency time.Duration) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if m.removedServices[serviceName] {
+		return
+	}
 
 	m.serviceRequestsTotal[serviceName]++
 
@@ -94,22 +176,77 @@ func (m *Metrics) RecordServiceRequest(serviceName string, status int, latency t
 		m.serviceErrorsTotal[serviceName]++
 	}
 
-	latencies := m.serviceLatencies[serviceName]
-	latencies = append(latencies, float64(latency.Milliseconds()))
-	// Keep last 100 latencies per service
-	if len(latencies) > 100 {
-		latencies = latencies[1:]
+	hist, ok := m.serviceHistograms[serviceName]
+	if !ok {
+		hist = newHistogram()
+		m.serviceHistograms[serviceName] = hist
+	}
+	hist.observe(latency.Seconds())
+
+	latencyHist, ok := m.serviceLatencyHistograms[serviceName]
+	if !ok {
+		latencyHist = newHistogramWith(backendLatencyBucketsMs)
+		m.serviceLatencyHistograms[serviceName] = latencyHist
 	}
-	m.serviceLatencies[serviceName] = latencies
+	latencyHist.observe(float64(latency.Milliseconds()))
 }
 
-// IncrementRateLimited increments the rate limited counter
-func (m *Metrics) IncrementRateLimited() {
+// Rate limit dimensions: which kind of key a request was limited under
+const (
+	RateLimitAnonymous = "anonymous"
+	RateLimitAPIKey    = "apikey"
+)
+
+// RecordRateLimitDecision counts one limiter outcome for a dimension; denials
+// also count toward the global rate limited total
+func (m *Metrics) RecordRateLimitDecision(dimension string, allowed bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if allowed {
+		m.rateLimitAllowed[dimension]++
+		return
+	}
+	m.rateLimitDenied[dimension]++
 	m.rateLimitedTotal++
 }
 
+// RateLimitDecisions returns the allowed and denied counts for a dimension
+func (m *Metrics) RateLimitDecisions(dimension string) (allowed, denied int64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.rateLimitAllowed[dimension], m.rateLimitDenied[dimension]
+}
+
+// API key validation failure reasons. Failures are never labeled by key, so
+// the series count stays fixed however many keys clients try.
+const (
+	APIKeyNotFound = "not_found"
+	APIKeyExpired  = "expired"
+	APIKeyDisabled = "disabled"
+)
+
+// RecordAPIKeyValidation counts a key that passed validation
+func (m *Metrics) RecordAPIKeyValidation() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.apiKeyValidations++
+}
+
+// RecordAPIKeyFailure counts a key rejected for reason
+func (m *Metrics) RecordAPIKeyFailure(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.apiKeyFailures[reason]++
+}
+
+// APIKeyValidations returns the number of keys that passed validation and
+// of those rejected for reason
+func (m *Metrics) APIKeyValidations(reason string) (succeeded, failed int64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.apiKeyValidations, m.apiKeyFailures[reason]
+}
+
 // IncrementInFlight increments requests in flight
 func (m *Metrics) IncrementInFlight() {
 	m.mu.Lock()
@@ -124,9 +261,81 @@ func (m *Metrics) DecrementInFlight() {
 	m.requestsInFlight--
 }
 
+// IncrementServiceInFlight counts a request dispatched to a service's backend
+func (m *Metrics) IncrementServiceInFlight(serviceName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.removedServices[serviceName] {
+		return
+	}
+	m.serviceInFlight[serviceName]++
+}
+
+// DecrementServiceInFlight marks a request to a service's backend as
+// finished. Requests outliving RemoveService don't bring the gauge back.
+func (m *Metrics) DecrementServiceInFlight(serviceName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.serviceInFlight[serviceName]; ok {
+		m.serviceInFlight[serviceName]--
+	}
+}
+
+// ServiceInFlight returns the requests currently awaiting a service's backend
+func (m *Metrics) ServiceInFlight(serviceName string) int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.serviceInFlight[serviceName]
+}
+
+// AddBackendConnections changes the connection slots in use on one backend
+// of a service by delta
+func (m *Metrics) AddBackendConnections(serviceName, backend string, delta int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.removedServices[serviceName] {
+		return
+	}
+	backends := m.backendConnections[serviceName]
+	if backends == nil {
+		backends = make(map[string]int64)
+		m.backendConnections[serviceName] = backends
+	}
+	backends[backend] += delta
+}
+
+// BackendConnections returns the connection slots in use on a backend
+func (m *Metrics) BackendConnections(serviceName, backend string) int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.backendConnections[serviceName][backend]
+}
+
+// IncrementBackendConnLimitRejections counts a request shed because its
+// backend had no free connection slot
+func (m *Metrics) IncrementBackendConnLimitRejections(serviceName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.removedServices[serviceName] {
+		return
+	}
+	m.backendConnLimitRejections[serviceName]++
+}
+
+// BackendConnLimitRejections returns the requests to a service shed at its
+// backend connection limit
+func (m *Metrics) BackendConnLimitRejections(serviceName string) int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.backendConnLimitRejections[serviceName]
+}
+
 func (m *Metrics) UpdateCircuitBreakerState(serviceName, state string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if m.removedServices[serviceName] {
+		return
+	}
 	m.circuitBreakerState[serviceName] = state
 }
 
@@ -134,9 +343,93 @@ func (m *Metrics) UpdateCircuitBreakerState(serviceName, state string) {
 func (m *Metrics) IncrementCircuitBreakerTrips(serviceName string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if m.removedServices[serviceName] {
+		return
+	}
 	m.circuitBreakerTrips[serviceName]++
 }
 
+// IncrementCircuitBreakerShortCircuits counts a request rejected without reaching the backend
+func (m *Metrics) IncrementCircuitBreakerShortCircuits(serviceName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.removedServices[serviceName] {
+		return
+	}
+	m.circuitBreakerShortCircuits[serviceName]++
+}
+
+// RecordCircuitBreakerOutcome counts a success or failure observed by a service's breaker
+func (m *Metrics) RecordCircuitBreakerOutcome(serviceName string, success bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.removedServices[serviceName] {
+		return
+	}
+	if success {
+		m.circuitBreakerSuccesses[serviceName]++
+	} else {
+		m.circuitBreakerFailures[serviceName]++
+	}
+}
+
+// RemoveService drops every per-service series of serviceName, circuit
+// breaker state included, so a removed service stops being reported. Later
+// records for the name are ignored.
+func (m *Metrics) RemoveService(serviceName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.removedServices[serviceName] = true
+
+	delete(m.circuitBreakerState, serviceName)
+	delete(m.circuitBreakerTrips, serviceName)
+	delete(m.circuitBreakerShortCircuits, serviceName)
+	delete(m.circuitBreakerSuccesses, serviceName)
+	delete(m.circuitBreakerFailures, serviceName)
+	delete(m.serviceRequestsTotal, serviceName)
+	delete(m.serviceErrorsTotal, serviceName)
+	delete(m.serviceLatencyHistograms, serviceName)
+	delete(m.serviceInFlight, serviceName)
+	delete(m.serviceHistograms, serviceName)
+	delete(m.backendConnections, serviceName)
+	delete(m.backendConnLimitRejections, serviceName)
+}
+
+// ServiceStats summarizes the requests proxied to a single service
+type ServiceStats struct {
+	Requests     int64   `json:"requests"`
+	Errors       int64   `json:"errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	P95LatencyMs float64 `json:"p95_latency_ms"`
+	P99LatencyMs float64 `json:"p99_latency_ms"`
+	InFlight     int64   `json:"in_flight"`
+}
+
+func (m *Metrics) GetServiceStats(serviceName string) ServiceStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := ServiceStats{
+		Requests: m.serviceRequestsTotal[serviceName],
+		Errors:   m.serviceErrorsTotal[serviceName],
+		InFlight: m.serviceInFlight[serviceName],
+	}
+	if stats.Requests > 0 {
+		stats.ErrorRate = float64(stats.Errors) / float64(stats.Requests)
+	}
+
+	// Percentiles are estimated from the bucket counts
+	if hist, ok := m.serviceLatencyHistograms[serviceName]; ok {
+		stats.AvgLatencyMs = hist.mean()
+		stats.P95LatencyMs = hist.quantile(0.95)
+		stats.P99LatencyMs = hist.quantile(0.99)
+	}
+
+	return stats
+}
+
 func (m *Metrics) GetMetricsData() map[string]interface{} {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -164,14 +457,8 @@ func (m *Metrics) GetMetricsData() map[string]interface{} {
 
 	// Calculate per-service average latency
 	serviceAvgLatency := make(map[string]float64)
-	for svc, latencies := range m.serviceLatencies {
-		if len(latencies) > 0 {
-			var sum float64
-			for _, l := range latencies {
-				sum += l
-			}
-			serviceAvgLatency[svc] = sum / float64(len(latencies))
-		}
+	for svc, hist := range m.serviceLatencyHistograms {
+		serviceAvgLatency[svc] = hist.mean()
 	}
 
 	// Total requests
@@ -179,89 +466,127 @@ func (m *Metrics) GetMetricsData() map[string]interface{} {
 	for _, count := range m.requestsTotal {
 		totalRequests += count
 	}
-
-	return map[string]interface{}{
-		"uptime_seconds":       time.Since(m.startTime).Seconds(),
-		"requests_total":       totalRequests,
-		"requests_in_flight":   m.requestsInFlight,
-		"rate_limited_total":   m.rateLimitedTotal,
-		"requests_by_status":   statusCounts,
-		"requests_by_method":   methodCounts,
-		"latency_p50_ms":       p50,
-		"latency_p95_ms":       p95,
-		"latency_p99_ms":       p99,
-		"circuit_breakers":     m.circuitBreakerState,
-		"circuit_breaker_trips": m.circuitBreakerTrips,
-		"service_requests":     m.serviceRequestsTotal,
-		"service_errors":       m.serviceErrorsTotal,
-		"service_avg_latency_ms": serviceAvgLatency,
+	rate := m.requestRateLocked()
+
+	data := map[string]interface{}{
+		"uptime_seconds":                      time.Since(m.startTime).Seconds(),
+		"requests_total":                      totalRequests,
+		"requests_in_flight":                  m.requestsInFlight,
+		"requests_per_second":                 rate.RequestsPerSecond,
+		"requests_per_minute":                 rate.RequestsPerMinute,
+		"rate_limited_total":                  m.rateLimitedTotal,
+		"rate_limit_allowed":                  maps.Clone(m.rateLimitAllowed),
+		"rate_limit_denied":                   maps.Clone(m.rateLimitDenied),
+		"apikey_validations":                  m.apiKeyValidations,
+		"apikey_validation_failures":          maps.Clone(m.apiKeyFailures),
+		"requests_by_status":                  statusCounts,
+		"requests_by_method":                  methodCounts,
+		"latency_p50_ms":                      p50,
+		"latency_p95_ms":                      p95,
+		"latency_p99_ms":                      p99,
+		"circuit_breakers":                    m.circuitBreakerState,
+		"circuit_breaker_trips":               m.circuitBreakerTrips,
+		"circuit_breaker_short_circuits":      m.circuitBreakerShortCircuits,
+		"circuit_breaker_successes":           m.circuitBreakerSuccesses,
+		"circuit_breaker_failures":            m.circuitBreakerFailures,
+		"service_requests":                    m.serviceRequestsTotal,
+		"service_errors":                      m.serviceErrorsTotal,
+		"service_avg_latency_ms":              serviceAvgLatency,
+		"service_in_flight":                   maps.Clone(m.serviceInFlight),
+		"backend_connections":                 cloneBackendConnections(m.backendConnections),
+		"backend_connection_limit_rejections": maps.Clone(m.backendConnLimitRejections),
 	}
+	if m.redisPoolStats != nil {
+		m.redisPoolStats().addTo(data)
+	}
+	return data
 }
 
-func (m *Metrics) GetPrometheusFormat() string {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	var result string
-
+// writeLegacy renders the original exposition: millisecond summary quantiles
+// and unsorted series
+func (s *snapshot) writeLegacy(w *bufio.Writer) {
 	// Uptime
-	result += "# HELP gateway_uptime_seconds Time since gateway started\n"
-	result += "# TYPE gateway_uptime_seconds gauge\n"
-	result += "gateway_uptime_seconds " + formatFloat(time.Since(m.startTime).Seconds()) + "\n\n"
+	w.WriteString("# HELP gateway_uptime_seconds Time since gateway started\n")
+	w.WriteString("# TYPE gateway_uptime_seconds gauge\n")
+	w.WriteString("gateway_uptime_seconds " + formatFloat(s.uptime.Seconds()) + "\n\n")
 
 	// Requests in flight
-	result += "# HELP gateway_requests_in_flight Current number of requests being processed\n"
-	result += "# TYPE gateway_requests_in_flight gauge\n"
-	result += "gateway_requests_in_flight " + strconv.FormatInt(m.requestsInFlight, 10) + "\n\n"
+	w.WriteString("# HELP gateway_requests_in_flight Current number of requests being processed\n")
+	w.WriteString("# TYPE gateway_requests_in_flight gauge\n")
+	w.WriteString("gateway_requests_in_flight " + strconv.FormatInt(s.requestsInFlight, 10) + "\n\n")
 
 	// Rate limited total
-	result += "# HELP gateway_rate_limited_total Total number of rate limited requests\n"
-	result += "# TYPE gateway_rate_limited_total counter\n"
-	result += "gateway_rate_limited_total " + strconv.FormatInt(m.rateLimitedTotal, 10) + "\n\n"
+	w.WriteString("# HELP gateway_rate_limited_total Total number of rate limited requests\n")
+	w.WriteString("# TYPE gateway_rate_limited_total counter\n")
+	w.WriteString("gateway_rate_limited_total " + strconv.FormatInt(s.rateLimitedTotal, 10) + "\n\n")
+
+	writeLegacyLabeled(w, "gateway_ratelimit_allowed_total", "Requests admitted by the rate limiter", "dimension", s.rateLimitAllowed)
+	w.WriteString("\n")
+	writeLegacyLabeled(w, "gateway_ratelimit_denied_total", "Requests rejected by the rate limiter", "dimension", s.rateLimitDenied)
+	w.WriteString("\n")
+
+	w.WriteString("# HELP gateway_apikey_validations_total API keys that passed validation\n")
+	w.WriteString("# TYPE gateway_apikey_validations_total counter\n")
+	w.WriteString("gateway_apikey_validations_total " + strconv.FormatInt(s.apiKeyValidations, 10) + "\n\n")
+
+	writeLegacyLabeled(w, "gateway_apikey_validation_failures_total", "API keys rejected by validation", "reason", s.apiKeyFailures)
+	w.WriteString("\n")
 
 	// Requests total by method, path, status
-	result += "# HELP gateway_http_requests_total Total number of HTTP requests\n"
-	result += "# TYPE gateway_http_requests_total counter\n"
-	for key, count := range m.requestsTotal {
+	w.WriteString("# HELP gateway_http_requests_total Total number of HTTP requests\n")
+	w.WriteString("# TYPE gateway_http_requests_total counter\n")
+	for key, count := range s.requestsTotal {
 		parts := splitKey(key)
 		if len(parts) >= 3 {
-			result += "gateway_http_requests_total{method=\"" + parts[0] + "\",path=\"" + parts[1] + "\",status=\"" + parts[2] + "\"} " + strconv.FormatInt(count, 10) + "\n"
+			w.WriteString("gateway_http_requests_total{method=\"" + parts[0] + "\",path=\"" + parts[1] + "\",status=\"" + parts[2] + "\"} " + strconv.FormatInt(count, 10) + "\n")
 		}
 	}
-	result += "\n"
+	w.WriteString("\n")
 
 	// Request duration histogram approximation (using percentiles)
-	var allDurations []float64
-	for _, r := range m.requestDurations {
-		allDurations = append(allDurations, r.duration*1000)
-	}
-	p50, p95, p99 := calculatePercentiles(allDurations)
+	p50, p95, p99 := calculatePercentiles(s.requestDurations)
 
-	result += "# HELP gateway_http_request_duration_ms HTTP request duration in milliseconds\n"
-	result += "# TYPE gateway_http_request_duration_ms summary\n"
-	result += "gateway_http_request_duration_ms{quantile=\"0.5\"} " + formatFloat(p50) + "\n"
-	result += "gateway_http_request_duration_ms{quantile=\"0.95\"} " + formatFloat(p95) + "\n"
-	result += "gateway_http_request_duration_ms{quantile=\"0.99\"} " + formatFloat(p99) + "\n\n"
+	w.WriteString("# HELP gateway_http_request_duration_ms HTTP request duration in milliseconds\n")
+	w.WriteString("# TYPE gateway_http_request_duration_ms summary\n")
+	w.WriteString("gateway_http_request_duration_ms{quantile=\"0.5\"} " + formatFloat(p50) + "\n")
+	w.WriteString("gateway_http_request_duration_ms{quantile=\"0.95\"} " + formatFloat(p95) + "\n")
+	w.WriteString("gateway_http_request_duration_ms{quantile=\"0.99\"} " + formatFloat(p99) + "\n\n")
 
 	// Service metrics
-	result += "# HELP gateway_backend_requests_total Total requests to backend services\n"
-	result += "# TYPE gateway_backend_requests_total counter\n"
-	for svc, count := range m.serviceRequestsTotal {
-		result += "gateway_backend_requests_total{service=\"" + svc + "\"} " + strconv.FormatInt(count, 10) + "\n"
+	writeLegacyCounters(w, "gateway_backend_requests_total", "Total requests to backend services", s.serviceRequestsTotal)
+	w.WriteString("\n")
+	writeLegacyCounters(w, "gateway_backend_errors_total", "Total errors from backend services", s.serviceErrorsTotal)
+	w.WriteString("\n")
+
+	w.WriteString("# HELP gateway_backend_latency_ms Backend request latency in milliseconds\n")
+	w.WriteString("# TYPE gateway_backend_latency_ms histogram\n")
+	for _, svc := range sortedKeys(s.serviceLatencyHistograms) {
+		writeHistogram(w, "gateway_backend_latency_ms", []string{"service", svc}, s.serviceLatencyHistograms[svc])
 	}
-	result += "\n"
+	w.WriteString("\n")
 
-	result += "# HELP gateway_backend_errors_total Total errors from backend services\n"
-	result += "# TYPE gateway_backend_errors_total counter\n"
-	for svc, count := range m.serviceErrorsTotal {
-		result += "gateway_backend_errors_total{service=\"" + svc + "\"} " + strconv.FormatInt(count, 10) + "\n"
+	w.WriteString("# HELP gateway_backend_in_flight Requests currently awaiting a backend\n")
+	w.WriteString("# TYPE gateway_backend_in_flight gauge\n")
+	for svc, count := range s.serviceInFlight {
+		w.WriteString("gateway_backend_in_flight{service=\"" + svc + "\"} " + strconv.FormatInt(count, 10) + "\n")
 	}
-	result += "\n"
+	w.WriteString("\n")
+
+	w.WriteString("# HELP gateway_backend_connections Connection slots in use on a backend with a connection limit\n")
+	w.WriteString("# TYPE gateway_backend_connections gauge\n")
+	for svc, backends := range s.backendConnections {
+		for backend, count := range backends {
+			w.WriteString("gateway_backend_connections{service=\"" + svc + "\",backend=\"" + backend + "\"} " + strconv.FormatInt(count, 10) + "\n")
+		}
+	}
+	w.WriteString("\n")
+	writeLegacyCounters(w, "gateway_backend_connection_limit_rejections_total", "Requests shed at a backend connection limit", s.backendConnLimitRejections)
+	w.WriteString("\n")
 
 	// Circuit breaker state (1 = closed, 0.5 = half-open, 0 = open)
-	result += "# HELP gateway_circuit_breaker_state Circuit breaker state (1=closed, 0.5=half-open, 0=open)\n"
-	result += "# TYPE gateway_circuit_breaker_state gauge\n"
-	for svc, state := range m.circuitBreakerState {
+	w.WriteString("# HELP gateway_circuit_breaker_state Circuit breaker state (1=closed, 0.5=half-open, 0=open)\n")
+	w.WriteString("# TYPE gateway_circuit_breaker_state gauge\n")
+	for svc, state := range s.circuitBreakerState {
 		stateValue := "1"
 		switch state {
 		case "open":
@@ -269,17 +594,40 @@ func (m *Metrics) GetPrometheusFormat() string {
 		case "half-open":
 			stateValue = "0.5"
 		}
-		result += "gateway_circuit_breaker_state{service=\"" + svc + "\"} " + stateValue + "\n"
+		w.WriteString("gateway_circuit_breaker_state{service=\"" + svc + "\"} " + stateValue + "\n")
+	}
+	w.WriteString("\n")
+
+	writeLegacyCounters(w, "gateway_circuit_breaker_trips_total", "Total circuit breaker trips", s.circuitBreakerTrips)
+	w.WriteString("\n")
+	writeLegacyCounters(w, "gateway_circuit_breaker_short_circuits_total", "Requests rejected by an open circuit breaker", s.circuitBreakerShortCircuits)
+	w.WriteString("\n")
+	writeLegacyCounters(w, "gateway_circuit_breaker_successes_total", "Successes recorded by the circuit breaker", s.circuitBreakerSuccesses)
+	w.WriteString("\n")
+	writeLegacyCounters(w, "gateway_circuit_breaker_failures_total", "Failures recorded by the circuit breaker", s.circuitBreakerFailures)
+
+	if s.redisPool != nil {
+		w.WriteString("\n")
+		s.redisPool.write(w)
 	}
-	result += "\n"
+}
 
-	result += "# HELP gateway_circuit_breaker_trips_total Total circuit breaker trips\n"
-	result += "# TYPE gateway_circuit_breaker_trips_total counter\n"
-	for svc, count := range m.circuitBreakerTrips {
-		result += "gateway_circuit_breaker_trips_total{service=\"" + svc + "\"} " + strconv.FormatInt(count, 10) + "\n"
+// writeLegacyCounters writes a per-service counter family
+func writeLegacyCounters(w *bufio.Writer, name, help string, counts map[string]int64) {
+	w.WriteString("# HELP " + name + " " + help + "\n")
+	w.WriteString("# TYPE " + name + " counter\n")
+	for svc, count := range counts {
+		w.WriteString(name + "{service=\"" + svc + "\"} " + strconv.FormatInt(count, 10) + "\n")
 	}
+}
 
-	return result
+// writeLegacyLabeled writes a counter family with one label
+func writeLegacyLabeled(w *bufio.Writer, name, help, label string, counts map[string]int64) {
+	w.WriteString("# HELP " + name + " " + help + "\n")
+	w.WriteString("# TYPE " + name + " counter\n")
+	for value, count := range counts {
+		w.WriteString(name + "{" + label + "=\"" + value + "\"} " + strconv.FormatInt(count, 10) + "\n")
+	}
 }
 
 func Handler() http.HandlerFunc {
@@ -296,12 +644,48 @@ func Handler() http.HandlerFunc {
 
 		// Default to Prometheus format
 		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
-		w.Write([]byte(m.GetPrometheusFormat()))
+		m.WritePrometheus(w)
 	}
 }
 
 // Helper functions
 
+// matchRoutePattern returns the first route template matching path segment by segment.
+// Template segments starting with ':' match any non-empty segment.
+func matchRoutePattern(patterns [][]string, path string) (string, bool) {
+	if len(patterns) == 0 {
+		return "", false
+	}
+
+	segments := strings.Split(path, "/")
+	for _, pattern := range patterns {
+		if len(pattern) != len(segments) {
+			continue
+		}
+
+		matched := true
+		for i, p := range pattern {
+			if strings.HasPrefix(p, ":") {
+				if segments[i] == "" {
+					matched = false
+					break
+				}
+				continue
+			}
+			if p != segments[i] {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return strings.Join(pattern, "/"), true
+		}
+	}
+
+	return "", false
+}
+
 func normalizePath(path string) string {
 	// Simple normalization: replace UUIDs and numeric IDs with placeholder
 	// This keeps cardinality low for metrics
@@ -369,21 +753,16 @@ func looksLikeID(s string) bool {
 	return false
 }
 
+// splitKey splits a "method:path:status" key. The path may itself contain
+// ':' (route placeholders such as /users/:id), so only the first and last
+// separators are significant.
 func splitKey(key string) []string {
-	var result []string
-	var current []byte
-	for _, c := range []byte(key) {
-		if c == ':' {
-			result = append(result, string(current))
-			current = nil
-		} else {
-			current = append(current, c)
-		}
-	}
-	if len(current) > 0 {
-		result = append(result, string(current))
+	first := strings.IndexByte(key, ':')
+	last := strings.LastIndexByte(key, ':')
+	if first < 0 || first == last {
+		return nil
 	}
-	return result
+	return []string{key[:first], key[first+1 : last], key[last+1:]}
 }
 
 func calculatePercentiles(values []float64) (p50, p95, p99 float64) {
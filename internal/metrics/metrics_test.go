@@ -0,0 +1,225 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bimakw/api-gateway/internal/clock"
+)
+
+func TestNormalizePathHeuristic(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/api/users", "/api/users"},
+		{"/api/users/123", "/api/users/:id"},
+		{"/api/v2/users/123", "/api/v2/users/:id"},
+		{"/api/orders/550e8400-e29b-41d4-a716-446655440000", "/api/orders/:id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := normalizePath(tt.path); got != tt.expected {
+				t.Errorf("normalizePath(%q) = %q, want %q", tt.path, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRoutePatternsTakePrecedence(t *testing.T) {
+	m := newMetrics()
+	m.SetRoutePatterns([]string{"/api/2/users/:id", "/api/v2/users/:id"})
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		// Numeric version segment survives because the template keeps it literal
+		{"/api/2/users/123", "/api/2/users/:id"},
+		{"/api/v2/users/123", "/api/v2/users/:id"},
+		// No template matches: heuristic fallback
+		{"/api/3/users/123", "/api/:id/users/:id"},
+		// Placeholders require a non-empty segment
+		{"/api/v2/users/", "/api/v2/users/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := matchRoutePattern(m.routePatterns, tt.path)
+			if !ok {
+				got = normalizePath(tt.path)
+			}
+			if got != tt.expected {
+				t.Errorf("normalized %q = %q, want %q", tt.path, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRecordRequestUsesRoutePatterns(t *testing.T) {
+	m := newMetrics()
+	m.SetRoutePatterns([]string{"/api/2/users/:id"})
+
+	m.RecordRequest("GET", "/api/2/users/123", 200, time.Millisecond)
+	m.RecordRequest("GET", "/api/2/users/456", 200, time.Millisecond)
+
+	if got := m.requestsTotal["GET:/api/2/users/:id:200"]; got != 2 {
+		t.Errorf("requestsTotal for template = %d, want 2", got)
+	}
+	if got := m.requestsTotal["GET:/api/:id/users/:id:200"]; got != 0 {
+		t.Errorf("heuristic key should not be recorded, got %d", got)
+	}
+}
+
+func TestCircuitBreakerOutcomeCounters(t *testing.T) {
+	m := newMetrics()
+	for _, success := range []bool{true, true, false, true, false} {
+		m.RecordCircuitBreakerOutcome("user-service", success)
+	}
+	m.RecordCircuitBreakerOutcome("auth-service", false)
+
+	legacy := m.GetPrometheusFormat()
+	for _, line := range []string{
+		`gateway_circuit_breaker_successes_total{service="user-service"} 3`,
+		`gateway_circuit_breaker_failures_total{service="user-service"} 2`,
+		`gateway_circuit_breaker_failures_total{service="auth-service"} 1`,
+	} {
+		if !strings.Contains(legacy, line) {
+			t.Errorf("legacy format missing %q", line)
+		}
+	}
+	if strings.Contains(legacy, `gateway_circuit_breaker_successes_total{service="auth-service"}`) {
+		t.Error("auth-service recorded no successes")
+	}
+
+	m.SetStandardFormat(true)
+	types, samples, err := parseExposition(m.GetPrometheusFormat())
+	if err != nil {
+		t.Fatalf("exposition does not parse: %v", err)
+	}
+	for _, name := range []string{"gateway_circuit_breaker_successes_total", "gateway_circuit_breaker_failures_total"} {
+		if types[name] != "counter" {
+			t.Errorf("TYPE %s = %q, want counter", name, types[name])
+		}
+	}
+	want := map[string]float64{"gateway_circuit_breaker_successes_total": 3, "gateway_circuit_breaker_failures_total": 2}
+	for _, s := range samples {
+		if v, ok := want[s.name]; ok && s.labels["service"] == "user-service" && s.value != v {
+			t.Errorf("%s = %v, want %v", s.name, s.value, v)
+		}
+	}
+}
+
+func TestRequestRate(t *testing.T) {
+	clk := clock.NewFake(time.Unix(100, 200*int64(time.Millisecond)))
+	m := newMetrics()
+	m.clock = clk
+
+	for i := 0; i < 5; i++ {
+		m.RecordRequest("GET", "/api/users", 200, time.Millisecond)
+	}
+	clk.Advance(1300 * time.Millisecond) // 101.5s
+	for i := 0; i < 20; i++ {
+		m.RecordRequest("GET", "/api/users", 200, time.Millisecond)
+	}
+
+	// requests_per_second covers the last complete second, not the burst in progress
+	if got := m.GetRequestRate(); got.RequestsPerSecond != 5 || got.RequestsPerMinute != 25 {
+		t.Errorf("mid-burst rate = %+v, want 5/s and 25/min", got)
+	}
+
+	clk.Advance(600 * time.Millisecond) // 102.1s
+	if got := m.GetRequestRate(); got.RequestsPerSecond != 20 || got.RequestsPerMinute != 25 {
+		t.Errorf("after burst rate = %+v, want 20/s and 25/min", got)
+	}
+
+	data := m.GetMetricsData()
+	if data["requests_per_second"] != int64(20) || data["requests_per_minute"] != int64(25) {
+		t.Errorf("GetMetricsData rate = %v/%v, want 20/25", data["requests_per_second"], data["requests_per_minute"])
+	}
+
+	// Slots age out of the one-minute window and are reused
+	clk.Advance(58400 * time.Millisecond) // 160.5s
+	m.RecordRequest("GET", "/api/users", 200, time.Millisecond)
+	if got := m.GetRequestRate(); got.RequestsPerMinute != 21 {
+		t.Errorf("rate after a minute = %+v, want 21/min (the 100s slot was reused)", got)
+	}
+}
+
+func TestRedisPoolStats(t *testing.T) {
+	m := newMetrics()
+	if strings.Contains(m.GetPrometheusFormat(), "gateway_redis_pool") {
+		t.Error("pool series exposed without a stats source")
+	}
+
+	m.SetRedisPoolStats(func() RedisPoolStats {
+		return RedisPoolStats{Hits: 40, Misses: 3, Timeouts: 1, TotalConns: 8, IdleConns: 5}
+	})
+
+	legacy := m.GetPrometheusFormat()
+	for _, line := range []string{
+		"gateway_redis_pool_total_conns 8",
+		"gateway_redis_pool_idle_conns 5",
+		"gateway_redis_pool_hits_total 40",
+		"gateway_redis_pool_misses_total 3",
+	} {
+		if !strings.Contains(legacy, line) {
+			t.Errorf("legacy format missing %q", line)
+		}
+	}
+
+	m.SetStandardFormat(true)
+	types, _, err := parseExposition(m.GetPrometheusFormat())
+	if err != nil {
+		t.Fatalf("exposition does not parse: %v", err)
+	}
+	if types["gateway_redis_pool_idle_conns"] != "gauge" || types["gateway_redis_pool_hits_total"] != "counter" {
+		t.Errorf("pool TYPEs = %q/%q, want gauge/counter", types["gateway_redis_pool_idle_conns"], types["gateway_redis_pool_hits_total"])
+	}
+
+	data := m.GetMetricsData()
+	if data["redis_pool_total_conns"] != int64(8) || data["redis_pool_misses"] != int64(3) {
+		t.Errorf("JSON pool stats = %v/%v, want 8/3", data["redis_pool_total_conns"], data["redis_pool_misses"])
+	}
+}
+
+func TestBackendLatencyHistogram(t *testing.T) {
+	m := newMetrics()
+	// Mostly fast with a slow tail: 98% at 8ms, 2% at 3s
+	for i := 0; i < 1000; i++ {
+		latency := 8 * time.Millisecond
+		if i%50 == 0 {
+			latency = 3 * time.Second
+		}
+		m.RecordServiceRequest("user-service", 200, latency)
+	}
+
+	legacy := m.GetPrometheusFormat()
+	for _, line := range []string{
+		"# TYPE gateway_backend_latency_ms histogram",
+		`gateway_backend_latency_ms_bucket{service="user-service",le="5"} 0`,
+		`gateway_backend_latency_ms_bucket{service="user-service",le="10"} 980`,
+		`gateway_backend_latency_ms_bucket{service="user-service",le="2500"} 980`,
+		`gateway_backend_latency_ms_bucket{service="user-service",le="5000"} 1000`,
+		`gateway_backend_latency_ms_bucket{service="user-service",le="+Inf"} 1000`,
+		`gateway_backend_latency_ms_count{service="user-service"} 1000`,
+	} {
+		if !strings.Contains(legacy, line) {
+			t.Errorf("legacy format missing %q", line)
+		}
+	}
+
+	stats := m.GetServiceStats("user-service")
+	if want := (980*8 + 20*3000) / 1000.0; stats.AvgLatencyMs != want {
+		t.Errorf("avg latency = %v, want %v", stats.AvgLatencyMs, want)
+	}
+	if stats.P95LatencyMs < 5 || stats.P95LatencyMs > 10 {
+		t.Errorf("p95 = %v, want within the 5-10ms bucket", stats.P95LatencyMs)
+	}
+	// The slow tail must show up in p99 rather than being averaged away
+	if stats.P99LatencyMs < 2500 || stats.P99LatencyMs > 5000 {
+		t.Errorf("p99 = %v, want within the 2500-5000ms bucket", stats.P99LatencyMs)
+	}
+}
@@ -0,0 +1,289 @@
+package metrics
+
+import (
+	"bufio"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultBuckets are the Prometheus client default latency buckets, in seconds
+var defaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// backendLatencyBucketsMs are defaultBuckets in milliseconds, for the per-service
+// latency histogram behind dashboard averages and percentiles
+var backendLatencyBucketsMs = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// routeKey identifies a request series in the duration histogram
+type routeKey struct {
+	method string
+	path   string
+}
+
+// histogram is a cumulative bucket histogram
+type histogram struct {
+	bounds  []float64 // upper bounds, ascending; shared and never modified
+	buckets []uint64  // buckets[i] counts observations <= bounds[i]
+	count   uint64
+	sum     float64
+}
+
+func newHistogram() *histogram {
+	return newHistogramWith(defaultBuckets)
+}
+
+func newHistogramWith(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, buckets: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, upper := range h.bounds {
+		if v <= upper {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// quantile estimates the q-quantile by linear interpolation within the
+// bucket holding it, like PromQL's histogram_quantile. Observations above
+// the last bound report that bound.
+func (h *histogram) quantile(q float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+	rank := q * float64(h.count)
+	var lower float64
+	var below uint64
+	for i, upper := range h.bounds {
+		if float64(h.buckets[i]) >= rank {
+			inBucket := h.buckets[i] - below
+			if inBucket == 0 {
+				return upper
+			}
+			return lower + (upper-lower)*(rank-float64(below))/float64(inBucket)
+		}
+		lower, below = upper, h.buckets[i]
+	}
+	return h.bounds[len(h.bounds)-1]
+}
+
+// mean returns the average observation (0 when empty)
+func (h *histogram) mean() float64 {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / float64(h.count)
+}
+
+// histogramFor returns the request histogram for key, creating it if needed.
+// Callers must hold m.mu.
+func (m *Metrics) histogramFor(key routeKey) *histogram {
+	hist, ok := m.requestHistograms[key]
+	if !ok {
+		hist = newHistogram()
+		m.requestHistograms[key] = hist
+	}
+	return hist
+}
+
+// SetStandardFormat makes /metrics follow Prometheus client conventions:
+// base-unit names, a real histogram for request duration and escaped labels
+func (m *Metrics) SetStandardFormat(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.standardFormat = enabled
+}
+
+// writeStandard renders the exposition format with series sorted for stable
+// output
+func (s *snapshot) writeStandard(b *bufio.Writer) {
+	writeFamily(b, "gateway_uptime_seconds", "gauge", "Time since gateway started")
+	writeSample(b, "gateway_uptime_seconds", nil, formatValue(s.uptime.Seconds()))
+
+	writeFamily(b, "gateway_requests_in_flight", "gauge", "Current number of requests being processed")
+	writeSample(b, "gateway_requests_in_flight", nil, strconv.FormatInt(s.requestsInFlight, 10))
+
+	writeFamily(b, "gateway_rate_limited_total", "counter", "Total number of rate limited requests")
+	writeSample(b, "gateway_rate_limited_total", nil, strconv.FormatInt(s.rateLimitedTotal, 10))
+
+	writeFamily(b, "gateway_ratelimit_allowed_total", "counter", "Requests admitted by the rate limiter")
+	for _, dimension := range sortedKeys(s.rateLimitAllowed) {
+		writeSample(b, "gateway_ratelimit_allowed_total", []string{"dimension", dimension},
+			strconv.FormatInt(s.rateLimitAllowed[dimension], 10))
+	}
+
+	writeFamily(b, "gateway_ratelimit_denied_total", "counter", "Requests rejected by the rate limiter")
+	for _, dimension := range sortedKeys(s.rateLimitDenied) {
+		writeSample(b, "gateway_ratelimit_denied_total", []string{"dimension", dimension},
+			strconv.FormatInt(s.rateLimitDenied[dimension], 10))
+	}
+
+	writeFamily(b, "gateway_apikey_validations_total", "counter", "API keys that passed validation")
+	writeSample(b, "gateway_apikey_validations_total", nil, strconv.FormatInt(s.apiKeyValidations, 10))
+
+	writeFamily(b, "gateway_apikey_validation_failures_total", "counter", "API keys rejected by validation")
+	for _, reason := range sortedKeys(s.apiKeyFailures) {
+		writeSample(b, "gateway_apikey_validation_failures_total", []string{"reason", reason},
+			strconv.FormatInt(s.apiKeyFailures[reason], 10))
+	}
+
+	writeFamily(b, "gateway_http_requests_total", "counter", "Total number of HTTP requests")
+	for _, key := range sortedKeys(s.requestsTotal) {
+		parts := splitKey(key)
+		if len(parts) < 3 {
+			continue
+		}
+		writeSample(b, "gateway_http_requests_total",
+			[]string{"method", parts[0], "path", parts[1], "code", parts[2]},
+			strconv.FormatInt(s.requestsTotal[key], 10))
+	}
+
+	writeFamily(b, "gateway_http_request_duration_seconds", "histogram", "HTTP request duration in seconds")
+	routes := make([]routeKey, 0, len(s.requestHistograms))
+	for key := range s.requestHistograms {
+		routes = append(routes, key)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].method != routes[j].method {
+			return routes[i].method < routes[j].method
+		}
+		return routes[i].path < routes[j].path
+	})
+	for _, key := range routes {
+		writeHistogram(b, "gateway_http_request_duration_seconds",
+			[]string{"method", key.method, "path", key.path}, s.requestHistograms[key])
+	}
+
+	writeFamily(b, "gateway_backend_requests_total", "counter", "Total requests to backend services")
+	for _, svc := range sortedKeys(s.serviceRequestsTotal) {
+		writeSample(b, "gateway_backend_requests_total", []string{"service", svc},
+			strconv.FormatInt(s.serviceRequestsTotal[svc], 10))
+	}
+
+	writeFamily(b, "gateway_backend_errors_total", "counter", "Total errors from backend services")
+	for _, svc := range sortedKeys(s.serviceErrorsTotal) {
+		writeSample(b, "gateway_backend_errors_total", []string{"service", svc},
+			strconv.FormatInt(s.serviceErrorsTotal[svc], 10))
+	}
+
+	writeFamily(b, "gateway_backend_in_flight", "gauge", "Requests currently awaiting a backend")
+	for _, svc := range sortedKeys(s.serviceInFlight) {
+		writeSample(b, "gateway_backend_in_flight", []string{"service", svc},
+			strconv.FormatInt(s.serviceInFlight[svc], 10))
+	}
+
+	writeFamily(b, "gateway_backend_connections", "gauge", "Connection slots in use on a backend with a connection limit")
+	for _, svc := range sortedKeys(s.backendConnections) {
+		for _, backend := range sortedKeys(s.backendConnections[svc]) {
+			writeSample(b, "gateway_backend_connections", []string{"service", svc, "backend", backend},
+				strconv.FormatInt(s.backendConnections[svc][backend], 10))
+		}
+	}
+
+	writeFamily(b, "gateway_backend_connection_limit_rejections_total", "counter", "Requests shed at a backend connection limit")
+	for _, svc := range sortedKeys(s.backendConnLimitRejections) {
+		writeSample(b, "gateway_backend_connection_limit_rejections_total", []string{"service", svc},
+			strconv.FormatInt(s.backendConnLimitRejections[svc], 10))
+	}
+
+	writeFamily(b, "gateway_backend_request_duration_seconds", "histogram", "Backend request duration in seconds")
+	for _, svc := range sortedKeys(s.serviceHistograms) {
+		writeHistogram(b, "gateway_backend_request_duration_seconds", []string{"service", svc}, s.serviceHistograms[svc])
+	}
+
+	writeFamily(b, "gateway_circuit_breaker_state", "gauge", "Circuit breaker state (1=closed, 0.5=half-open, 0=open)")
+	for _, svc := range sortedKeys(s.circuitBreakerState) {
+		stateValue := "1"
+		switch s.circuitBreakerState[svc] {
+		case "open":
+			stateValue = "0"
+		case "half-open":
+			stateValue = "0.5"
+		}
+		writeSample(b, "gateway_circuit_breaker_state", []string{"service", svc}, stateValue)
+	}
+
+	writeFamily(b, "gateway_circuit_breaker_trips_total", "counter", "Total circuit breaker trips")
+	for _, svc := range sortedKeys(s.circuitBreakerTrips) {
+		writeSample(b, "gateway_circuit_breaker_trips_total", []string{"service", svc},
+			strconv.FormatInt(s.circuitBreakerTrips[svc], 10))
+	}
+
+	writeFamily(b, "gateway_circuit_breaker_short_circuits_total", "counter", "Requests rejected by an open circuit breaker")
+	for _, svc := range sortedKeys(s.circuitBreakerShortCircuits) {
+		writeSample(b, "gateway_circuit_breaker_short_circuits_total", []string{"service", svc},
+			strconv.FormatInt(s.circuitBreakerShortCircuits[svc], 10))
+	}
+
+	writeFamily(b, "gateway_circuit_breaker_successes_total", "counter", "Successes recorded by the circuit breaker")
+	for _, svc := range sortedKeys(s.circuitBreakerSuccesses) {
+		writeSample(b, "gateway_circuit_breaker_successes_total", []string{"service", svc},
+			strconv.FormatInt(s.circuitBreakerSuccesses[svc], 10))
+	}
+
+	writeFamily(b, "gateway_circuit_breaker_failures_total", "counter", "Failures recorded by the circuit breaker")
+	for _, svc := range sortedKeys(s.circuitBreakerFailures) {
+		writeSample(b, "gateway_circuit_breaker_failures_total", []string{"service", svc},
+			strconv.FormatInt(s.circuitBreakerFailures[svc], 10))
+	}
+
+	if s.redisPool != nil {
+		s.redisPool.write(b)
+	}
+}
+
+func writeFamily(b *bufio.Writer, name, metricType, help string) {
+	b.WriteString("# HELP " + name + " " + help + "\n")
+	b.WriteString("# TYPE " + name + " " + metricType + "\n")
+}
+
+// writeSample writes one series; labels alternate name, value
+func writeSample(b *bufio.Writer, name string, labels []string, value string) {
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			labelEscaper.WriteString(b, labels[i+1])
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+func writeHistogram(b *bufio.Writer, name string, labels []string, h *histogram) {
+	bucketLabels := append(append([]string(nil), labels...), "le", "")
+	for i, upper := range h.bounds {
+		bucketLabels[len(bucketLabels)-1] = formatValue(upper)
+		writeSample(b, name+"_bucket", bucketLabels, strconv.FormatUint(h.buckets[i], 10))
+	}
+	bucketLabels[len(bucketLabels)-1] = "+Inf"
+	writeSample(b, name+"_bucket", bucketLabels, strconv.FormatUint(h.count, 10))
+	writeSample(b, name+"_sum", labels, formatValue(h.sum))
+	writeSample(b, name+"_count", labels, strconv.FormatUint(h.count, 10))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatValue renders a float without trailing zeros, as Prometheus clients do
+func formatValue(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,318 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRe  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+type sample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// parseExposition parses the Prometheus text format (version 0.0.4) strictly
+// enough to catch malformed output: TYPE before samples, valid names, quoted
+// and escaped label values, numeric values and the histogram suffix rules.
+func parseExposition(text string) (map[string]string, []sample, error) {
+	types := make(map[string]string)
+	var samples []sample
+
+	for n, line := range strings.Split(text, "\n") {
+		lineNo := n + 1
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			fields := strings.SplitN(line, " ", 4)
+			if len(fields) < 3 || (fields[1] != "HELP" && fields[1] != "TYPE") {
+				continue // plain comment
+			}
+			if !metricNameRe.MatchString(fields[2]) {
+				return nil, nil, fmt.Errorf("line %d: invalid metric name %q", lineNo, fields[2])
+			}
+			if fields[1] == "TYPE" {
+				if len(fields) != 4 {
+					return nil, nil, fmt.Errorf("line %d: TYPE without type", lineNo)
+				}
+				switch fields[3] {
+				case "counter", "gauge", "histogram", "summary", "untyped":
+				default:
+					return nil, nil, fmt.Errorf("line %d: unknown type %q", lineNo, fields[3])
+				}
+				if _, dup := types[fields[2]]; dup {
+					return nil, nil, fmt.Errorf("line %d: duplicate TYPE for %s", lineNo, fields[2])
+				}
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		s, err := parseSampleLine(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+
+		family := s.name
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if base := strings.TrimSuffix(s.name, suffix); base != s.name && types[base] == "histogram" {
+				family = base
+			}
+		}
+		if _, ok := types[family]; !ok {
+			return nil, nil, fmt.Errorf("line %d: sample %s before its TYPE", lineNo, s.name)
+		}
+		if strings.HasSuffix(s.name, "_bucket") && family != s.name {
+			if _, ok := s.labels["le"]; !ok {
+				return nil, nil, fmt.Errorf("line %d: bucket without le label", lineNo)
+			}
+		}
+		samples = append(samples, s)
+	}
+
+	return types, samples, nil
+}
+
+func parseSampleLine(line string) (sample, error) {
+	s := sample{labels: make(map[string]string)}
+
+	end := strings.IndexAny(line, "{ ")
+	if end < 0 {
+		return s, fmt.Errorf("missing value in %q", line)
+	}
+	s.name = line[:end]
+	if !metricNameRe.MatchString(s.name) {
+		return s, fmt.Errorf("invalid metric name %q", s.name)
+	}
+	rest := line[end:]
+
+	if strings.HasPrefix(rest, "{") {
+		rest = rest[1:]
+		for !strings.HasPrefix(rest, "}") {
+			eq := strings.Index(rest, `="`)
+			if eq < 0 {
+				return s, fmt.Errorf("malformed labels in %q", line)
+			}
+			name := rest[:eq]
+			if !labelNameRe.MatchString(name) {
+				return s, fmt.Errorf("invalid label name %q", name)
+			}
+			rest = rest[eq+2:]
+
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(rest); i++ {
+				c := rest[i]
+				if c == '\\' {
+					if i+1 >= len(rest) {
+						return s, fmt.Errorf("dangling escape in %q", line)
+					}
+					switch rest[i+1] {
+					case '\\':
+						value.WriteByte('\\')
+					case '"':
+						value.WriteByte('"')
+					case 'n':
+						value.WriteByte('\n')
+					default:
+						return s, fmt.Errorf("invalid escape in %q", line)
+					}
+					i++
+					continue
+				}
+				if c == '"' {
+					rest = rest[i+1:]
+					closed = true
+					break
+				}
+				value.WriteByte(c)
+			}
+			if !closed {
+				return s, fmt.Errorf("unterminated label value in %q", line)
+			}
+			s.labels[name] = value.String()
+			rest = strings.TrimPrefix(rest, ",")
+		}
+		rest = rest[1:]
+	}
+
+	if !strings.HasPrefix(rest, " ") {
+		return s, fmt.Errorf("missing value in %q", line)
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(rest), 64)
+	if err != nil {
+		return s, fmt.Errorf("invalid value in %q: %w", line, err)
+	}
+	s.value = value
+	return s, nil
+}
+
+func TestStandardFormatParses(t *testing.T) {
+	m := newMetrics()
+	m.SetStandardFormat(true)
+	m.SetRoutePatterns([]string{"/api/users/:id"})
+
+	m.RecordRequest("GET", "/api/users/1", 200, 3*time.Millisecond)
+	m.RecordRequest("GET", "/api/users/2", 200, 300*time.Millisecond)
+	m.RecordRequest("GET", "/api/users/3", 500, 20*time.Second)
+	m.RecordRequest("POST", `/api/"quoted"\path`, 201, 40*time.Millisecond)
+	m.RecordServiceRequest("user-service", 200, 12*time.Millisecond)
+	m.RecordServiceRequest("user-service", 502, 2*time.Second)
+	m.UpdateCircuitBreakerState("user-service", "half-open")
+	m.IncrementCircuitBreakerTrips("user-service")
+
+	types, samples, err := parseExposition(m.GetPrometheusFormat())
+	if err != nil {
+		t.Fatalf("exposition does not parse: %v", err)
+	}
+
+	for _, name := range []string{"gateway_http_request_duration_seconds", "gateway_backend_request_duration_seconds"} {
+		if types[name] != "histogram" {
+			t.Errorf("TYPE %s = %q, want histogram", name, types[name])
+		}
+	}
+
+	find := func(name string, labels map[string]string) (float64, bool) {
+	next:
+		for _, s := range samples {
+			if s.name != name {
+				continue
+			}
+			for k, v := range labels {
+				if s.labels[k] != v {
+					continue next
+				}
+			}
+			return s.value, true
+		}
+		return 0, false
+	}
+
+	route := map[string]string{"method": "GET", "path": "/api/users/:id"}
+	if v, _ := find("gateway_http_requests_total", map[string]string{"method": "GET", "path": "/api/users/:id", "code": "200"}); v != 2 {
+		t.Errorf("requests_total{code=200} = %v, want 2", v)
+	}
+	if v, _ := find("gateway_http_request_duration_seconds_count", route); v != 3 {
+		t.Errorf("duration _count = %v, want 3", v)
+	}
+	if v, _ := find("gateway_http_request_duration_seconds_sum", route); math.Abs(v-20.303) > 1e-9 {
+		t.Errorf("duration _sum = %v, want 20.303", v)
+	}
+
+	// Buckets are cumulative and +Inf equals _count
+	wantBuckets := map[string]float64{"0.005": 1, "0.25": 1, "0.5": 2, "10": 2, "+Inf": 3}
+	for le, want := range wantBuckets {
+		labels := map[string]string{"method": "GET", "path": "/api/users/:id", "le": le}
+		if v, ok := find("gateway_http_request_duration_seconds_bucket", labels); !ok || v != want {
+			t.Errorf("bucket le=%s = %v (found %v), want %v", le, v, ok, want)
+		}
+	}
+
+	if _, ok := find("gateway_http_requests_total", map[string]string{"path": `/api/"quoted"\path`}); !ok {
+		t.Error("escaped label value did not round-trip")
+	}
+	if v, _ := find("gateway_backend_request_duration_seconds_count", map[string]string{"service": "user-service"}); v != 2 {
+		t.Errorf("backend duration _count = %v, want 2", v)
+	}
+}
+
+func TestLegacyFormatUnchangedByDefault(t *testing.T) {
+	m := newMetrics()
+	m.RecordRequest("GET", "/api/users/1", 200, time.Millisecond)
+
+	out := m.GetPrometheusFormat()
+	if !strings.Contains(out, "# TYPE gateway_http_request_duration_ms summary") {
+		t.Error("default format should keep the summary metric")
+	}
+	if !strings.Contains(out, `gateway_http_requests_total{method="GET",path="/api/users/:id",status="200"} 1`) {
+		t.Errorf("legacy request counter missing or mislabeled:\n%s", out)
+	}
+}
+
+// populateManySeries records services*paths distinct request series
+func populateManySeries(m *Metrics, services, paths int) {
+	for s := 0; s < services; s++ {
+		svc := "service-" + strconv.Itoa(s)
+		m.RecordServiceRequest(svc, 200, time.Millisecond)
+		m.UpdateCircuitBreakerState(svc, "closed")
+		for p := 0; p < paths; p++ {
+			m.RecordRequest("GET", "/api/"+svc+"/resource-"+strconv.Itoa(p), 200, time.Millisecond)
+		}
+	}
+}
+
+func TestWritePrometheusManySeries(t *testing.T) {
+	const services, paths = 50, 100
+
+	for _, standard := range []bool{false, true} {
+		m := newMetrics()
+		m.SetStandardFormat(standard)
+		populateManySeries(m, services, paths)
+
+		var b strings.Builder
+		if err := m.WritePrometheus(&b); err != nil {
+			t.Fatalf("WritePrometheus() error = %v", err)
+		}
+		_, samples, err := parseExposition(b.String())
+		if err != nil {
+			t.Fatalf("standard=%v: exposition does not parse: %v", standard, err)
+		}
+
+		requests := make(map[string]float64)
+		backends := 0
+		for _, s := range samples {
+			switch s.name {
+			case "gateway_http_requests_total":
+				requests[s.labels["path"]] = s.value
+			case "gateway_backend_requests_total":
+				backends++
+			}
+		}
+		if len(requests) != services*paths {
+			t.Errorf("standard=%v: request series = %d, want %d", standard, len(requests), services*paths)
+		}
+		for path, v := range requests {
+			if v != 1 {
+				t.Errorf("standard=%v: requests{path=%q} = %v, want 1", standard, path, v)
+				break
+			}
+		}
+		if backends != services {
+			t.Errorf("standard=%v: backend series = %d, want %d", standard, backends, services)
+		}
+	}
+}
+
+func BenchmarkWritePrometheus(b *testing.B) {
+	for _, standard := range []bool{false, true} {
+		name := "legacy"
+		if standard {
+			name = "standard"
+		}
+		b.Run(name, func(b *testing.B) {
+			m := newMetrics()
+			m.SetStandardFormat(standard)
+			populateManySeries(m, 50, 100)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := m.WritePrometheus(io.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import "time"
+
+// rateWindowSeconds is how much per-second history the request rate keeps
+const rateWindowSeconds = 60
+
+// rateWindow is a ring of per-second request counts covering the last minute
+type rateWindow struct {
+	counts  [rateWindowSeconds]int64
+	seconds [rateWindowSeconds]int64 // unix second each slot currently counts
+}
+
+func (w *rateWindow) add(now time.Time) {
+	sec := now.Unix()
+	i := sec % rateWindowSeconds
+	if w.seconds[i] != sec {
+		w.seconds[i] = sec
+		w.counts[i] = 0
+	}
+	w.counts[i]++
+}
+
+// sum counts requests in the seconds (now-n, now], excluding the current
+// partial second when complete is set
+func (w *rateWindow) sum(now time.Time, n int64, complete bool) int64 {
+	end := now.Unix()
+	if complete {
+		end--
+	}
+	var total int64
+	for i := range w.counts {
+		if sec := w.seconds[i]; sec > end-n && sec <= end {
+			total += w.counts[i]
+		}
+	}
+	return total
+}
+
+// RequestRate is the recent request throughput
+type RequestRate struct {
+	// RequestsPerSecond counts requests in the last complete second
+	RequestsPerSecond int64 `json:"requests_per_second"`
+	// RequestsPerMinute counts requests in the trailing 60 seconds
+	RequestsPerMinute int64 `json:"requests_per_minute"`
+}
+
+// GetRequestRate reports recent throughput from the per-second ring
+func (m *Metrics) GetRequestRate() RequestRate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.requestRateLocked()
+}
+
+func (m *Metrics) requestRateLocked() RequestRate {
+	now := m.clock.Now()
+	return RequestRate{
+		RequestsPerSecond: m.requestRate.sum(now, 1, true),
+		RequestsPerMinute: m.requestRate.sum(now, rateWindowSeconds, false),
+	}
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"bufio"
+	"strconv"
+)
+
+// RedisPoolStats mirrors the Redis client's connection pool counters
+type RedisPoolStats struct {
+	Hits       uint32 // free connection found in the pool
+	Misses     uint32 // no free connection, a new one was dialed
+	Timeouts   uint32 // waits for a connection that timed out
+	TotalConns uint32
+	IdleConns  uint32
+}
+
+// SetRedisPoolStats registers the source of Redis pool stats for /metrics
+func (m *Metrics) SetRedisPoolStats(fn func() RedisPoolStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.redisPoolStats = fn
+}
+
+// write emits the pool series in the Prometheus text format
+func (p RedisPoolStats) write(w *bufio.Writer) {
+	writeFamily(w, "gateway_redis_pool_total_conns", "gauge", "Connections in the Redis pool")
+	writeSample(w, "gateway_redis_pool_total_conns", nil, formatUint32(p.TotalConns))
+
+	writeFamily(w, "gateway_redis_pool_idle_conns", "gauge", "Idle connections in the Redis pool")
+	writeSample(w, "gateway_redis_pool_idle_conns", nil, formatUint32(p.IdleConns))
+
+	writeFamily(w, "gateway_redis_pool_hits_total", "counter", "Times a free Redis connection was found in the pool")
+	writeSample(w, "gateway_redis_pool_hits_total", nil, formatUint32(p.Hits))
+
+	writeFamily(w, "gateway_redis_pool_misses_total", "counter", "Times a new Redis connection had to be dialed")
+	writeSample(w, "gateway_redis_pool_misses_total", nil, formatUint32(p.Misses))
+
+	writeFamily(w, "gateway_redis_pool_timeouts_total", "counter", "Times waiting for a Redis connection timed out")
+	writeSample(w, "gateway_redis_pool_timeouts_total", nil, formatUint32(p.Timeouts))
+}
+
+// addTo sets the pool stats on the JSON metrics document
+func (p RedisPoolStats) addTo(data map[string]interface{}) {
+	data["redis_pool_total_conns"] = int64(p.TotalConns)
+	data["redis_pool_idle_conns"] = int64(p.IdleConns)
+	data["redis_pool_hits"] = int64(p.Hits)
+	data["redis_pool_misses"] = int64(p.Misses)
+	data["redis_pool_timeouts"] = int64(p.Timeouts)
+}
+
+func formatUint32(v uint32) string {
+	return strconv.FormatUint(uint64(v), 10)
+}
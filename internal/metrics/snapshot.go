@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// snapshot is a point-in-time copy of the series rendered on /metrics, so
+// formatting and writing to a slow scraper happen without holding m.mu
+type snapshot struct {
+	standardFormat bool
+	uptime         time.Duration
+
+	requestsInFlight  int64
+	rateLimitedTotal  int64
+	rateLimitAllowed  map[string]int64
+	rateLimitDenied   map[string]int64
+	apiKeyValidations int64
+	apiKeyFailures    map[string]int64
+	requestsTotal     map[string]int64
+	requestDurations  []float64 // ms, for the legacy summary
+	requestHistograms map[routeKey]*histogram
+
+	serviceRequestsTotal map[string]int64
+	serviceErrorsTotal   map[string]int64
+	serviceInFlight      map[string]int64
+	serviceHistograms    map[string]*histogram // seconds, standard format
+	// milliseconds, legacy format
+	serviceLatencyHistograms map[string]*histogram
+
+	backendConnections         map[string]map[string]int64
+	backendConnLimitRejections map[string]int64
+
+	circuitBreakerState         map[string]string
+	circuitBreakerTrips         map[string]int64
+	circuitBreakerShortCircuits map[string]int64
+	circuitBreakerSuccesses     map[string]int64
+	circuitBreakerFailures      map[string]int64
+
+	redisPool *RedisPoolStats
+}
+
+// snapshot copies the exposed series. Only the raw maps are copied under the
+// read lock; sorting and percentile math happen afterwards.
+func (m *Metrics) snapshot() *snapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s := &snapshot{
+		standardFormat:              m.standardFormat,
+		uptime:                      time.Since(m.startTime),
+		requestsInFlight:            m.requestsInFlight,
+		rateLimitedTotal:            m.rateLimitedTotal,
+		rateLimitAllowed:            maps.Clone(m.rateLimitAllowed),
+		rateLimitDenied:             maps.Clone(m.rateLimitDenied),
+		apiKeyValidations:           m.apiKeyValidations,
+		apiKeyFailures:              maps.Clone(m.apiKeyFailures),
+		requestsTotal:               maps.Clone(m.requestsTotal),
+		serviceRequestsTotal:        maps.Clone(m.serviceRequestsTotal),
+		serviceErrorsTotal:          maps.Clone(m.serviceErrorsTotal),
+		serviceInFlight:             maps.Clone(m.serviceInFlight),
+		backendConnections:          cloneBackendConnections(m.backendConnections),
+		backendConnLimitRejections:  maps.Clone(m.backendConnLimitRejections),
+		circuitBreakerState:         maps.Clone(m.circuitBreakerState),
+		circuitBreakerTrips:         maps.Clone(m.circuitBreakerTrips),
+		circuitBreakerShortCircuits: maps.Clone(m.circuitBreakerShortCircuits),
+		circuitBreakerSuccesses:     maps.Clone(m.circuitBreakerSuccesses),
+		circuitBreakerFailures:      maps.Clone(m.circuitBreakerFailures),
+	}
+
+	if m.standardFormat {
+		s.requestHistograms = make(map[routeKey]*histogram, len(m.requestHistograms))
+		for key, h := range m.requestHistograms {
+			s.requestHistograms[key] = h.clone()
+		}
+		s.serviceHistograms = make(map[string]*histogram, len(m.serviceHistograms))
+		for svc, h := range m.serviceHistograms {
+			s.serviceHistograms[svc] = h.clone()
+		}
+	} else {
+		s.requestDurations = make([]float64, len(m.requestDurations))
+		for i, r := range m.requestDurations {
+			s.requestDurations[i] = r.duration * 1000
+		}
+		s.serviceLatencyHistograms = make(map[string]*histogram, len(m.serviceLatencyHistograms))
+		for svc, h := range m.serviceLatencyHistograms {
+			s.serviceLatencyHistograms[svc] = h.clone()
+		}
+	}
+
+	if m.redisPoolStats != nil {
+		pool := m.redisPoolStats()
+		s.redisPool = &pool
+	}
+
+	return s
+}
+
+func cloneBackendConnections(in map[string]map[string]int64) map[string]map[string]int64 {
+	out := make(map[string]map[string]int64, len(in))
+	for svc, backends := range in {
+		out[svc] = maps.Clone(backends)
+	}
+	return out
+}
+
+func (h *histogram) clone() *histogram {
+	return &histogram{bounds: h.bounds, buckets: slices.Clone(h.buckets), count: h.count, sum: h.sum}
+}
+
+// WritePrometheus streams the /metrics exposition to w. The metrics lock is
+// held only while snapshotting, so recording is never blocked by a scrape.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	s := m.snapshot()
+
+	bw := bufio.NewWriter(w)
+	if s.standardFormat {
+		s.writeStandard(bw)
+	} else {
+		s.writeLegacy(bw)
+	}
+	return bw.Flush()
+}
+
+// GetPrometheusFormat renders the exposition as a string
+func (m *Metrics) GetPrometheusFormat() string {
+	var b strings.Builder
+	m.WritePrometheus(&b)
+	return b.String()
+}
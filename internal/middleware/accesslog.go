@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access log formats accepted by LoggerConfig.Format
+const (
+	LogFormatJSON     = "json"
+	LogFormatCLF      = "clf"
+	LogFormatCombined = "combined"
+)
+
+// clfTimeLayout is the NCSA timestamp, e.g. 10/Oct/2000:13:55:36 -0700
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// accessLog writes NCSA-style access log lines, one Write per line
+type accessLog struct {
+	mu       sync.Mutex
+	out      io.Writer
+	combined bool
+}
+
+// newAccessLog returns the writer for an NCSA format, or nil for JSON and
+// unknown formats, which keep logging through slog. out defaults to stdout.
+func newAccessLog(format string, out io.Writer) *accessLog {
+	if format != LogFormatCLF && format != LogFormatCombined {
+		return nil
+	}
+	if out == nil {
+		out = os.Stdout
+	}
+	return &accessLog{out: out, combined: format == LogFormatCombined}
+}
+
+func (a *accessLog) write(r *http.Request, status int, bytes int64, start time.Time) {
+	line := accessLogLine(r, status, bytes, start, a.combined)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	io.WriteString(a.out, line)
+}
+
+// accessLogLine renders one request in NCSA Common Log Format:
+//
+//	host ident user [time] "request" status bytes
+//
+// combined appends the quoted Referer and User-Agent. Missing values are "-".
+func accessLogLine(r *http.Request, status int, bytes int64, start time.Time, combined bool) string {
+	user := "-"
+	if name, _, ok := r.BasicAuth(); ok && name != "" {
+		user = clfField(name)
+	}
+	size := "-"
+	if bytes > 0 {
+		size = strconv.FormatInt(bytes, 10)
+	}
+
+	var b strings.Builder
+	b.WriteString(ClientIP(r))
+	b.WriteString(" - ")
+	b.WriteString(user)
+	b.WriteString(" [")
+	b.WriteString(start.Format(clfTimeLayout))
+	b.WriteString(`] "`)
+	b.WriteString(clfField(r.Method + " " + r.URL.RequestURI() + " " + r.Proto))
+	b.WriteString(`" `)
+	b.WriteString(strconv.Itoa(status))
+	b.WriteByte(' ')
+	b.WriteString(size)
+	if combined {
+		b.WriteString(` "`)
+		b.WriteString(clfQuoted(r.Referer()))
+		b.WriteString(`" "`)
+		b.WriteString(clfQuoted(r.UserAgent()))
+		b.WriteByte('"')
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// clfQuoted returns "-" for an empty header, the escaped value otherwise
+func clfQuoted(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return clfField(value)
+}
+
+// clfField escapes quotes, backslashes and control characters so a client
+// can't break a line apart or forge entries
+func clfField(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			b.WriteString(`\x`)
+			b.WriteString(strconv.FormatUint(uint64(c)>>4, 16))
+			b.WriteString(strconv.FormatUint(uint64(c)&0xf, 16))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
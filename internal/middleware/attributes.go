@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// AttributesContextKey holds the *RequestAttributes resolved by Attributes
+const AttributesContextKey contextKey = "request_attributes"
+
+// maxRequestIDLength bounds client-supplied request ids kept as-is
+const maxRequestIDLength = 128
+
+// RequestAttributes are resolved once per request so later middleware read
+// the same values without re-parsing headers
+type RequestAttributes struct {
+	ClientIP  string
+	RequestID string
+	// Service is the service that will handle the request ("" = none)
+	Service string
+}
+
+// Attributes resolves the client IP, request id and target service and stores
+// them in the request context. The request id is taken from X-Request-ID when
+// the client sends a sane one, otherwise generated, and is echoed on the
+// response and forwarded to backends. It should run before any middleware
+// reading the attributes.
+func Attributes(resolveService func(r *http.Request) (string, bool)) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attrs := &RequestAttributes{
+				ClientIP:  getClientIP(r),
+				RequestID: r.Header.Get("X-Request-ID"),
+			}
+			if !validRequestID(attrs.RequestID) {
+				attrs.RequestID = newRequestID()
+				r.Header.Set("X-Request-ID", attrs.RequestID)
+			}
+			if resolveService != nil {
+				attrs.Service, _ = resolveService(r)
+			}
+			w.Header().Set("X-Request-ID", attrs.RequestID)
+
+			ctx := context.WithValue(r.Context(), AttributesContextKey, attrs)
+			ctx = context.WithValue(ctx, RequestIDKey, attrs.RequestID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// AttributesFrom returns the attributes stored by Attributes, or nil when it
+// didn't run
+func AttributesFrom(ctx context.Context) *RequestAttributes {
+	attrs, _ := ctx.Value(AttributesContextKey).(*RequestAttributes)
+	return attrs
+}
+
+// ClientIP returns the client IP resolved by Attributes, resolving it from the
+// request when the middleware didn't run
+func ClientIP(r *http.Request) string {
+	if attrs := AttributesFrom(r.Context()); attrs != nil {
+		return attrs.ClientIP
+	}
+	return getClientIP(r)
+}
+
+// RequestID returns the request id assigned by Attributes ("" when it didn't run)
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(RequestIDKey).(string)
+	return id
+}
+
+// serviceFor returns the service handling r, preferring the one resolved by
+// Attributes over calling resolveService again
+func serviceFor(r *http.Request, resolveService func(r *http.Request) (string, bool)) (string, bool) {
+	if attrs := AttributesFrom(r.Context()); attrs != nil {
+		return attrs.Service, attrs.Service != ""
+	}
+	return resolveService(r)
+}
+
+// validRequestID accepts non-empty printable ASCII ids of bounded length, so
+// client ids can't inject into logs or headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// DefaultRedactHeaders are always masked in body logs
+var DefaultRedactHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"X-API-Key",
+	"Cookie",
+	"Set-Cookie",
+}
+
+const redactedValue = "[REDACTED]"
+
+type BodyLogConfig struct {
+	// MaxBodyBytes truncates logged bodies (0 = default 4096)
+	MaxBodyBytes int
+	// RedactHeaders are masked in addition to DefaultRedactHeaders
+	RedactHeaders []string
+	// SampleRate is the fraction (0.0-1.0) of exchanges logged (0 = all);
+	// server errors are logged regardless
+	SampleRate float64
+	// ErrorsOnly logs only exchanges that end in a server error
+	ErrorsOnly bool
+}
+
+// BodyLogger logs request/response headers and bodies at debug level.
+// Bodies are teed while the handler reads/writes them, never drained up front.
+// When sampling, every exchange is still captured up to MaxBodyBytes since
+// whether it ends in a 5xx is only known afterwards.
+func BodyLogger(logger *slog.Logger, cfg BodyLogConfig) Middleware {
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 4096
+	}
+
+	redact := make(map[string]bool)
+	for _, h := range append(DefaultRedactHeaders, cfg.RedactHeaders...) {
+		redact[http.CanonicalHeaderKey(strings.TrimSpace(h))] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !logger.Enabled(r.Context(), slog.LevelDebug) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			reqBody := &limitedBuffer{limit: cfg.MaxBodyBytes}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = &teeReadCloser{Reader: io.TeeReader(r.Body, reqBody), Closer: r.Body}
+			}
+
+			captured := &bodyCaptureWriter{
+				ResponseWriter: w,
+				statusCode:     http.StatusOK,
+				body:           &limitedBuffer{limit: cfg.MaxBodyBytes},
+			}
+
+			next.ServeHTTP(captured, r)
+
+			if !sampled(cfg) && captured.statusCode < http.StatusInternalServerError {
+				return
+			}
+			logger.Debug("http exchange",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"request_headers", redactHeaders(r.Header, redact),
+				"request_body", reqBody.String(),
+				"request_body_truncated", reqBody.truncated,
+				"status", captured.statusCode,
+				"response_headers", redactHeaders(captured.Header(), redact),
+				"response_body", captured.body.String(),
+				"response_body_truncated", captured.body.truncated,
+			)
+		})
+	}
+}
+
+// sampled decides whether an exchange is logged whatever its outcome
+func sampled(cfg BodyLogConfig) bool {
+	if cfg.ErrorsOnly {
+		return false
+	}
+	return cfg.SampleRate <= 0 || cfg.SampleRate >= 1 || rand.Float64() < cfg.SampleRate
+}
+
+func redactHeaders(h http.Header, redact map[string]bool) map[string]string {
+	result := make(map[string]string, len(h))
+	for key, values := range h {
+		if redact[http.CanonicalHeaderKey(key)] {
+			result[key] = redactedValue
+			continue
+		}
+		result[key] = strings.Join(values, ", ")
+	}
+	return result
+}
+
+// limitedBuffer keeps the first limit bytes written and discards the rest
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+			b.truncated = true
+		} else {
+			b.buf.Write(p)
+		}
+	} else if len(p) > 0 {
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
+
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// bodyCaptureWriter records the status code and a bounded copy of the response body
+type bodyCaptureWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       *limitedBuffer
+}
+
+func (w *bodyCaptureWriter) WriteHeader(code int) {
+	w.statusCode = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *bodyCaptureWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyCaptureWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *bodyCaptureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+func (w *bodyCaptureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newJSONLogger(buf *bytes.Buffer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: level}))
+}
+
+func TestBodyLoggerRedactsSensitiveHeaders(t *testing.T) {
+	var logs bytes.Buffer
+	logger := newJSONLogger(&logs, slog.LevelDebug)
+
+	handler := BodyLogger(logger, BodyLogConfig{RedactHeaders: []string{"X-Internal-Token"}})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.ReadAll(r.Body)
+			w.Header().Set("Set-Cookie", "session=secret")
+			w.Write([]byte(`{"ok":true}`))
+		}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(`{"name":"a"}`))
+	req.Header.Set("Authorization", "Bearer secret-token")
+	req.Header.Set("X-API-Key", "secret-key")
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("X-Internal-Token", "secret-internal")
+	req.Header.Set("X-Request-Source", "test")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	out := logs.String()
+	for _, secret := range []string{"secret-token", "secret-key", "session=secret", "secret-internal"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log output leaked %q: %s", secret, out)
+		}
+	}
+
+	var entry struct {
+		RequestHeaders  map[string]string `json:"request_headers"`
+		ResponseHeaders map[string]string `json:"response_headers"`
+		RequestBody     string            `json:"request_body"`
+		ResponseBody    string            `json:"response_body"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("unmarshal log entry: %v", err)
+	}
+	if entry.RequestHeaders["Authorization"] != redactedValue {
+		t.Errorf("Authorization = %q, want redacted", entry.RequestHeaders["Authorization"])
+	}
+	if entry.ResponseHeaders["Set-Cookie"] != redactedValue {
+		t.Errorf("Set-Cookie = %q, want redacted", entry.ResponseHeaders["Set-Cookie"])
+	}
+	if entry.RequestHeaders["X-Request-Source"] != "test" {
+		t.Errorf("X-Request-Source = %q, want test", entry.RequestHeaders["X-Request-Source"])
+	}
+	if entry.RequestBody != `{"name":"a"}` || entry.ResponseBody != `{"ok":true}` {
+		t.Errorf("bodies = %q / %q", entry.RequestBody, entry.ResponseBody)
+	}
+}
+
+func TestBodyLoggerPreservesBodyForBackend(t *testing.T) {
+	var logs bytes.Buffer
+	logger := newJSONLogger(&logs, slog.LevelDebug)
+
+	payload := strings.Repeat("x", 100)
+	var received string
+	handler := BodyLogger(logger, BodyLogConfig{MaxBodyBytes: 10})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			received = string(b)
+		}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(payload)))
+
+	if received != payload {
+		t.Errorf("backend received %d bytes, want %d", len(received), len(payload))
+	}
+
+	var entry struct {
+		RequestBody          string `json:"request_body"`
+		RequestBodyTruncated bool   `json:"request_body_truncated"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("unmarshal log entry: %v", err)
+	}
+	if entry.RequestBody != payload[:10] || !entry.RequestBodyTruncated {
+		t.Errorf("logged body = %q (truncated=%v), want first 10 bytes truncated", entry.RequestBody, entry.RequestBodyTruncated)
+	}
+}
+
+func TestBodyLoggerSkipsWhenDebugDisabled(t *testing.T) {
+	var logs bytes.Buffer
+	logger := newJSONLogger(&logs, slog.LevelInfo)
+
+	handler := BodyLogger(logger, BodyLogConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if logs.Len() != 0 {
+		t.Errorf("expected no log output at info level, got %s", logs.String())
+	}
+}
+
+func TestBodyLoggerSampling(t *testing.T) {
+	const total = 2000
+	failing := func(i int) bool { return i%10 == 0 }
+
+	tests := []struct {
+		name       string
+		cfg        BodyLogConfig
+		wantRate   float64 // expected fraction of successful exchanges logged
+		wantErrors bool
+	}{
+		{"quarter sampled", BodyLogConfig{SampleRate: 0.25}, 0.25, true},
+		{"errors only", BodyLogConfig{ErrorsOnly: true}, 0, true},
+		{"unsampled logs all", BodyLogConfig{}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			handler := BodyLogger(newJSONLogger(&logs, slog.LevelDebug), tt.cfg)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Query().Get("fail") != "" {
+						w.WriteHeader(http.StatusBadGateway)
+					}
+					w.Write([]byte("body"))
+				}))
+
+			for i := range total {
+				target := "/api/users?i=" + strconv.Itoa(i)
+				if failing(i) {
+					target += "&fail=1"
+				}
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+			}
+
+			var successes, errors int
+			dec := json.NewDecoder(&logs)
+			for dec.More() {
+				var entry struct {
+					Status       int    `json:"status"`
+					ResponseBody string `json:"response_body"`
+				}
+				if err := dec.Decode(&entry); err != nil {
+					t.Fatalf("decode log entry: %v", err)
+				}
+				if entry.ResponseBody != "body" {
+					t.Errorf("response_body = %q, want body", entry.ResponseBody)
+				}
+				if entry.Status >= http.StatusInternalServerError {
+					errors++
+				} else {
+					successes++
+				}
+			}
+
+			if wantErrors := total / 10; errors != wantErrors {
+				t.Errorf("logged %d error exchanges, want all %d", errors, wantErrors)
+			}
+			got := float64(successes) / float64(total-total/10)
+			if got < tt.wantRate-0.05 || got > tt.wantRate+0.05 {
+				t.Errorf("logged %.3f of successful exchanges, want about %.2f", got, tt.wantRate)
+			}
+		})
+	}
+}
@@ -1,26 +1,34 @@
 package middleware
 
 import (
+	"bufio"
 	"context"
-	"crypto/sha256"
-	"crypto/subtle"
 	"encoding/base64"
+	"errors"
+	"io"
 	"log/slog"
+	"math/rand"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/bimakw/api-gateway/internal/adminauth"
+	"github.com/bimakw/api-gateway/internal/admission"
 	"github.com/bimakw/api-gateway/internal/apikey"
 	"github.com/bimakw/api-gateway/internal/metrics"
+	"github.com/bimakw/api-gateway/internal/proxyproto"
 	"github.com/bimakw/api-gateway/internal/ratelimit"
 )
 
 type contextKey string
 
 const (
-	APIKeyContextKey contextKey = "api_key"
-	RequestIDKey     contextKey = "request_id"
+	APIKeyContextKey    contextKey = "api_key"
+	RequestIDKey        contextKey = "request_id"
+	AdminRoleContextKey contextKey = "admin_role"
+	AdminUserContextKey contextKey = "admin_user"
 )
 
 // Middleware is a function that wraps an http.Handler
@@ -34,8 +42,23 @@ func Chain(h http.Handler, middlewares ...Middleware) http.Handler {
 	return h
 }
 
+type LoggerConfig struct {
+	// SlowRequestThreshold limits logging to requests at least this slow,
+	// logged at Warn (0 = log every request at Info)
+	SlowRequestThreshold time.Duration
+	// SampleRate is the fraction (0.0-1.0) of faster requests still logged
+	// when SlowRequestThreshold is set
+	SampleRate float64
+	// Format is LogFormatJSON (slog records, the default), LogFormatCLF or
+	// LogFormatCombined; the NCSA formats write plain lines to Output
+	Format string
+	Output io.Writer
+}
+
 // Logger logs request details
-func Logger(logger *slog.Logger) Middleware {
+func Logger(logger *slog.Logger, cfg LoggerConfig) Middleware {
+	access := newAccessLog(cfg.Format, cfg.Output)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
@@ -45,16 +68,44 @@ func Logger(logger *slog.Logger) Middleware {
 
 			next.ServeHTTP(wrapped, r)
 
+			emit := func(level slog.Level, msg string, attrs []any) {
+				if access != nil {
+					access.write(r, wrapped.statusCode, wrapped.bytes, start)
+					return
+				}
+				logger.Log(r.Context(), level, msg, attrs...)
+			}
+
 			duration := time.Since(start)
 
-			logger.Info("request",
+			attrs := []any{
 				"method", r.Method,
 				"path", r.URL.Path,
 				"status", wrapped.statusCode,
 				"duration_ms", duration.Milliseconds(),
-				"client_ip", getClientIP(r),
+				"client_ip", ClientIP(r),
+				"request_id", RequestID(r.Context()),
 				"user_agent", r.UserAgent(),
-			)
+			}
+
+			if cfg.SlowRequestThreshold <= 0 {
+				emit(slog.LevelInfo, "request", attrs)
+				return
+			}
+
+			if duration >= cfg.SlowRequestThreshold {
+				// The proxy reports the backend that served the final attempt
+				attrs = append(attrs,
+					"backend", wrapped.Header().Get("X-Backend"),
+					"threshold_ms", cfg.SlowRequestThreshold.Milliseconds(),
+				)
+				emit(slog.LevelWarn, "slow request", attrs)
+				return
+			}
+
+			if cfg.SampleRate > 0 && rand.Float64() < cfg.SampleRate {
+				emit(slog.LevelInfo, "request", attrs)
+			}
 		})
 	}
 }
@@ -88,17 +139,86 @@ func Metrics() Middleware {
 	}
 }
 
+// RateLimitConfig tunes the RateLimit middleware
+type RateLimitConfig struct {
+	BurstSize int
+	// WarnThreshold adds X-RateLimit-Warning to allowed responses once the
+	// remaining tokens fall below this fraction of BurstSize (0 = never)
+	WarnThreshold float64
+
+	// AnonymousRPM and AuthenticatedRPM replace the limiter's rate for
+	// IP-keyed and API-key requests (0 = limiter default). The burst is capped
+	// at the rate so a low limit can't be exceeded all at once.
+	AnonymousRPM     int
+	AuthenticatedRPM int
+
+	// ExemptPaths are path prefixes never rate limited, such as the
+	// gateway's own health and metrics endpoints
+	ExemptPaths []string
+}
+
+// exempt reports whether path bypasses the limiter
+func (c RateLimitConfig) exempt(path string) bool {
+	for _, prefix := range c.ExemptPaths {
+		if pathHasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitFor returns the rate and burst for a request class (0 rate = limiter default)
+func (c RateLimitConfig) limitFor(authenticated bool) (rpm, burst int) {
+	rpm = c.AnonymousRPM
+	if authenticated {
+		rpm = c.AuthenticatedRPM
+	}
+	burst = c.BurstSize
+	if rpm > 0 && rpm < burst {
+		burst = rpm
+	}
+	return rpm, burst
+}
+
 // RateLimit applies rate limiting based on IP or API key
-func RateLimit(limiter *ratelimit.RateLimiter, burstSize int) Middleware {
+func RateLimit(limiter *ratelimit.RateLimiter, cfg RateLimitConfig) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// CORS preflights carry no credentials and must not drain the
+			// client's bucket; exempt paths aren't counted at all
+			if isPreflight(r) || cfg.exempt(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			// Use API key if present, otherwise use IP
-			key := getClientIP(r)
-			if apiKey, ok := r.Context().Value(APIKeyContextKey).(*apikey.APIKey); ok {
+			key := ClientIP(r)
+			dimension := metrics.RateLimitAnonymous
+			apiKey, authenticated := r.Context().Value(APIKeyContextKey).(*apikey.APIKey)
+			if IsAdminPath(r.URL.Path) {
+				// Admin logins are limited by the connection's address: a
+				// forwarded header is the client's to choose, and an API key
+				// mustn't lift the cap on password guesses
+				key = "admin:" + remoteIP(r)
+				authenticated = false
+			} else if authenticated {
+				if apiKey.Unlimited {
+					w.Header().Set("X-RateLimit-Bypass", "true")
+					next.ServeHTTP(w, r)
+					return
+				}
 				key = "apikey:" + apiKey.ID
+				dimension = metrics.RateLimitAPIKey
 			}
 
-			result, err := limiter.AllowWithBurst(r.Context(), key, burstSize)
+			rpm, burst := cfg.limitFor(authenticated)
+			var result *ratelimit.Result
+			var err error
+			if rpm > 0 {
+				result, err = limiter.AllowWithRate(r.Context(), key, rpm, burst)
+			} else {
+				result, err = limiter.AllowWithBurst(r.Context(), key, burst)
+			}
 			if err != nil {
 				http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
 				return
@@ -108,10 +228,8 @@ func RateLimit(limiter *ratelimit.RateLimiter, burstSize int) Middleware {
 			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
 			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(result.ResetAfter).Unix(), 10))
 
+			metrics.Get().RecordRateLimitDecision(dimension, result.Allowed)
 			if !result.Allowed {
-				// Record rate limited request in metrics
-				metrics.Get().IncrementRateLimited()
-
 				w.Header().Set("Retry-After", strconv.Itoa(int(result.ResetAfter.Seconds())))
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusTooManyRequests)
@@ -119,15 +237,72 @@ func RateLimit(limiter *ratelimit.RateLimiter, burstSize int) Middleware {
 				return
 			}
 
+			// Early signal so well-behaved clients can back off before a 429
+			if float64(result.Remaining) < cfg.WarnThreshold*float64(burst) {
+				w.Header().Set("X-RateLimit-Warning", "approaching limit")
+			}
+
+			// A leaky bucket queues the request until its turn
+			if result.Delay > 0 {
+				select {
+				case <-limiter.Clock().After(result.Delay):
+				case <-r.Context().Done():
+					return
+				}
+			}
+
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// APIKeyAuthConfig decides which paths need an API key. Path lists are
+// prefixes; when both lists match, the longer prefix wins.
+type APIKeyAuthConfig struct {
+	// Required rejects requests without a key on paths not listed below
+	Required bool
+	// ExemptPaths skip key validation entirely
+	ExemptPaths []string
+	// RequiredPaths reject requests without a key
+	RequiredPaths []string
+	// Proxied, when non-nil, reports whether a request goes to a backend.
+	// Required then only applies to those, so gateway endpoints such as
+	// /health and /admin stay reachable without a key.
+	Proxied func(r *http.Request) bool
+}
+
+// policy reports whether r is exempt from key validation and, if not,
+// whether a key is required
+func (c APIKeyAuthConfig) policy(r *http.Request) (exempt, required bool) {
+	path := r.URL.Path
+	required = c.Required && (c.Proxied == nil || c.Proxied(r))
+	longest := -1
+	for _, prefix := range c.ExemptPaths {
+		if pathHasPrefix(path, prefix) && len(prefix) > longest {
+			longest = len(prefix)
+			exempt, required = true, false
+		}
+	}
+	for _, prefix := range c.RequiredPaths {
+		// Required wins ties so a duplicated prefix fails closed
+		if pathHasPrefix(path, prefix) && len(prefix) >= longest {
+			longest = len(prefix)
+			exempt, required = false, true
+		}
+	}
+	return exempt, required
+}
+
 // APIKeyAuth validates API key from header
-func APIKeyAuth(manager *apikey.Manager, required bool) Middleware {
+func APIKeyAuth(manager *apikey.Manager, cfg APIKeyAuthConfig) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			exempt, required := cfg.policy(r)
+			if exempt {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			// Check for API key in header
 			rawKey := r.Header.Get("X-API-Key")
 			if rawKey == "" {
@@ -151,6 +326,7 @@ func APIKeyAuth(manager *apikey.Manager, required bool) Middleware {
 
 			// Validate the key
 			apiKey, err := manager.ValidateKey(r.Context(), rawKey)
+			recordAPIKeyValidation(err)
 			if err != nil {
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusUnauthorized)
@@ -165,15 +341,114 @@ func APIKeyAuth(manager *apikey.Manager, required bool) Middleware {
 	}
 }
 
-// CORS adds CORS headers
-func CORS(allowedOrigins []string) Middleware {
+// recordAPIKeyValidation counts a validation outcome by reason. Lookup
+// failures say nothing about the key and aren't counted.
+func recordAPIKeyValidation(err error) {
+	switch {
+	case err == nil:
+		metrics.Get().RecordAPIKeyValidation()
+	case errors.Is(err, apikey.ErrInvalidKey):
+		metrics.Get().RecordAPIKeyFailure(metrics.APIKeyNotFound)
+	case errors.Is(err, apikey.ErrKeyExpired):
+		metrics.Get().RecordAPIKeyFailure(metrics.APIKeyExpired)
+	case errors.Is(err, apikey.ErrKeyDisabled):
+		metrics.Get().RecordAPIKeyFailure(metrics.APIKeyDisabled)
+	}
+}
+
+// APIKeyScope rejects authenticated requests whose key is not scoped to the target service.
+// resolveService maps a request to the service that will handle it, reporting
+// false for requests no service handles (e.g. the gateway's own endpoints).
+func APIKeyScope(resolveService func(r *http.Request) (string, bool)) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiKey, ok := r.Context().Value(APIKeyContextKey).(*apikey.APIKey)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			serviceName, ok := serviceFor(r, resolveService)
+			if ok && !apiKey.CanAccessService(serviceName) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"error":"Forbidden","message":"API key is not allowed to access ` + serviceName + `"}`))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Admission gates requests through ctrl, using the API key's priority
+// (anonymous requests get priority 0). Shed requests receive 503.
+func Admission(ctrl *admission.Controller) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			priority := 0
+			if apiKey, ok := r.Context().Value(APIKeyContextKey).(*apikey.APIKey); ok {
+				priority = apiKey.Priority
+			}
+
+			release, err := ctrl.Acquire(r.Context(), priority)
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"error":"Service Unavailable","message":"Gateway is overloaded, please retry"}`))
+				return
+			}
+			defer release()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ConcurrencyLimit sheds requests with 503 and Retry-After once max are in
+// flight, instead of letting them queue without bound. The slot is released
+// when the request completes, even if a later handler panics. It belongs at
+// the top of the chain so shed requests cost as little as possible.
+func ConcurrencyLimit(max int, logger *slog.Logger) Middleware {
+	slots := make(chan struct{}, max)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+			default:
+				logger.Debug("Request shed at concurrency limit", "limit", max, "path", r.URL.Path)
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"error":"Service Unavailable","message":"Gateway is at capacity, please retry"}`))
+				return
+			}
+			defer func() { <-slots }()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CORS adds CORS headers. serviceOrigins, when non-nil, returns the allowed
+// origins of the service handling a request; a service policy replaces the
+// global allowedOrigins for that request, preflights included.
+func CORS(allowedOrigins []string, serviceOrigins func(r *http.Request) ([]string, bool)) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			origin := r.Header.Get("Origin")
 
+			origins := allowedOrigins
+			if serviceOrigins != nil {
+				if own, ok := serviceOrigins(r); ok {
+					origins = own
+				}
+			}
+
 			// Check if origin is allowed
 			allowed := false
-			for _, o := range allowedOrigins {
+			for _, o := range origins {
 				if o == "*" || o == origin {
 					allowed = true
 					break
@@ -197,6 +472,11 @@ func CORS(allowedOrigins []string) Middleware {
 	}
 }
 
+// isPreflight reports whether r is a CORS preflight request
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
 // Recover recovers from panics
 func Recover(logger *slog.Logger) Middleware {
 	return func(next http.Handler) http.Handler {
@@ -214,17 +494,84 @@ func Recover(logger *slog.Logger) Middleware {
 	}
 }
 
-// AdminAuth provides Basic Authentication for admin endpoints
-// Uses constant-time comparison to prevent timing attacks
-func AdminAuth(username, password string, logger *slog.Logger) Middleware {
-	// Pre-compute hashes for constant-time comparison
-	expectedUsernameHash := sha256.Sum256([]byte(username))
-	expectedPasswordHash := sha256.Sum256([]byte(password))
+// RejectSmuggling rejects requests with ambiguous body framing before they
+// reach a backend: Content-Length together with Transfer-Encoding, or more
+// than one Content-Length. net/http already refuses conflicting lengths on
+// HTTP/1.1 and drops Content-Length from chunked requests; this catches what
+// reaches the handler anyway. The connection is closed since the client and
+// gateway may disagree on where the next request starts.
+func RejectSmuggling(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			contentLengths := r.Header.Values("Content-Length")
+			chunked := len(r.TransferEncoding) > 0 || r.Header.Get("Transfer-Encoding") != ""
+
+			reason := ""
+			switch {
+			case chunked && len(contentLengths) > 0:
+				reason = "Content-Length and Transfer-Encoding are both set"
+			case len(contentLengths) > 1 || (len(contentLengths) == 1 && strings.Contains(contentLengths[0], ",")):
+				reason = "Multiple Content-Length values"
+			}
+			if reason == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger.Warn("rejected ambiguous request framing", "reason", reason, "path", r.URL.Path, "client_ip", ClientIP(r))
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"Bad Request","message":"` + reason + `"}`))
+		})
+	}
+}
+
+// MaxHeaderSize rejects requests whose request line and headers add up to
+// more than limit bytes with a JSON 431, before they reach the proxy. Sizes
+// are counted as on the wire in HTTP/1.1, so the limit means the same for
+// HTTP/2 clients.
+func MaxHeaderSize(limit int, logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			size := headerSize(r)
+			if size <= limit {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger.Warn("rejected oversized request headers", "size", size, "limit", limit, "path", r.URL.Path, "client_ip", ClientIP(r))
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusRequestHeaderFieldsTooLarge)
+			w.Write([]byte(`{"error":"Request Header Fields Too Large","message":"Request headers exceed ` + strconv.Itoa(limit) + ` bytes"}`))
+		})
+	}
+}
+
+// headerSize is the length of r's request line and header block, Host
+// included, as an HTTP/1.1 client would send them
+func headerSize(r *http.Request) int {
+	size := len(r.Method) + 1 + len(r.URL.RequestURI()) + 1 + len(r.Proto) + 2
+	if r.Host != "" {
+		size += len("Host: \r\n") + len(r.Host)
+	}
+	for name, values := range r.Header {
+		for _, value := range values {
+			size += len(name) + len(": \r\n") + len(value)
+		}
+	}
+	return size
+}
 
+// AdminAuth provides Basic Authentication for admin endpoints.
+// The authenticated role is stored in the request context; read-only users
+// are limited to safe methods.
+func AdminAuth(store *adminauth.Store, logger *slog.Logger) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Only protect /admin/* paths
-			if !strings.HasPrefix(r.URL.Path, "/admin") {
+			if !IsAdminPath(r.URL.Path) {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -261,28 +608,57 @@ func AdminAuth(username, password string, logger *slog.Logger) Middleware {
 			providedUsername := credentials[:colonIdx]
 			providedPassword := credentials[colonIdx+1:]
 
-			// Constant-time comparison to prevent timing attacks
-			providedUsernameHash := sha256.Sum256([]byte(providedUsername))
-			providedPasswordHash := sha256.Sum256([]byte(providedPassword))
-
-			usernameMatch := subtle.ConstantTimeCompare(providedUsernameHash[:], expectedUsernameHash[:]) == 1
-			passwordMatch := subtle.ConstantTimeCompare(providedPasswordHash[:], expectedPasswordHash[:]) == 1
-
-			if !usernameMatch || !passwordMatch {
+			// Password hashes are compared in constant time
+			role, ok := store.Authenticate(providedUsername, providedPassword)
+			if !ok {
 				logger.Warn("admin auth failed",
-					"client_ip", getClientIP(r),
+					"client_ip", ClientIP(r),
 					"path", r.URL.Path,
 				)
 				adminAuthFailed(w, "Invalid credentials")
 				return
 			}
 
+			if !role.Allows(r.Method) {
+				logger.Warn("admin action denied",
+					"username", providedUsername,
+					"role", role,
+					"method", r.Method,
+					"path", r.URL.Path,
+				)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"error":"Forbidden","message":"Role ` + string(role) + ` cannot modify admin resources"}`))
+				return
+			}
+
 			// Authentication successful
-			next.ServeHTTP(w, r)
+			ctx := context.WithValue(r.Context(), AdminRoleContextKey, role)
+			ctx = context.WithValue(ctx, AdminUserContextKey, providedUsername)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// IsAdminPath reports whether path is /admin or below it, mapped or not.
+// Lookalikes such as /administrator or /admin-ui are not admin paths: the mux
+// routes them to the proxy.
+func IsAdminPath(path string) bool {
+	return path == "/admin" || strings.HasPrefix(path, "/admin/")
+}
+
+// pathHasPrefix reports whether path is prefix or lies beneath it
+func pathHasPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || path[len(prefix)] == '/'
+}
+
 func adminAuthFailed(w http.ResponseWriter, message string) {
 	w.Header().Set("WWW-Authenticate", `Basic realm="API Gateway Admin", charset="UTF-8"`)
 	w.Header().Set("Content-Type", "application/json")
@@ -290,10 +666,12 @@ func adminAuthFailed(w http.ResponseWriter, message string) {
 	w.Write([]byte(`{"error":"Unauthorized","message":"` + message + `"}`))
 }
 
-// responseWriter wraps http.ResponseWriter to capture status code
+// responseWriter wraps http.ResponseWriter to capture status code and
+// response size
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int64
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
@@ -301,19 +679,54 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		ips := strings.Split(xff, ",")
-		return strings.TrimSpace(ips[0])
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers (SSE, chunked responses) flush through the wrapper
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
 	}
+}
 
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
+// Hijack lets connection upgrades (e.g. WebSocket) take over the underlying connection
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := rw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+func getClientIP(r *http.Request) string {
+	// Behind a PROXY protocol load balancer the connection carries the real
+	// client address and forwarded headers are whatever the client sent
+	if !proxyproto.FromProxyHeader(r.Context()) {
+		// Check X-Forwarded-For header
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			ips := strings.Split(xff, ",")
+			return strings.TrimSpace(ips[0])
+		}
+
+		// Check X-Real-IP header
+		if xri := r.Header.Get("X-Real-IP"); xri != "" {
+			return xri
+		}
 	}
 
 	// Fall back to RemoteAddr
+	return remoteIP(r)
+}
+
+// remoteIP returns the IP of the connection's peer, ignoring forwarded headers
+func remoteIP(r *http.Request) string {
 	ip := r.RemoteAddr
 	if colonIndex := strings.LastIndex(ip, ":"); colonIndex != -1 {
 		ip = ip[:colonIndex]
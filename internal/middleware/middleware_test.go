@@ -0,0 +1,989 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bimakw/api-gateway/internal/adminauth"
+	"github.com/bimakw/api-gateway/internal/apikey"
+	"github.com/bimakw/api-gateway/internal/clock"
+	"github.com/bimakw/api-gateway/internal/metrics"
+	"github.com/bimakw/api-gateway/internal/ratelimit"
+	"github.com/redis/go-redis/v9"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+// withAPIKey returns a request carrying key in its context, as APIKeyAuth would
+func withAPIKey(r *http.Request, key *apikey.APIKey) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), APIKeyContextKey, key))
+}
+
+func testServiceResolver(r *http.Request) (string, bool) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/users"):
+		return "user-service", true
+	case strings.HasPrefix(r.URL.Path, "/api/auth"):
+		return "auth-service", true
+	}
+	return "", false
+}
+
+func TestAPIKeyScope(t *testing.T) {
+	scoped := &apikey.APIKey{ID: "scoped", AllowedServices: []string{"user-service"}}
+	unscoped := &apikey.APIKey{ID: "unscoped"}
+
+	tests := []struct {
+		name string
+		key  *apikey.APIKey
+		path string
+		want int
+	}{
+		{"scoped key allowed service", scoped, "/api/users/1", http.StatusOK},
+		{"scoped key disallowed service", scoped, "/api/auth/login", http.StatusForbidden},
+		{"unscoped key any service", unscoped, "/api/auth/login", http.StatusOK},
+		{"anonymous request", nil, "/api/auth/login", http.StatusOK},
+		{"scoped key unrouted path", scoped, "/health", http.StatusOK},
+	}
+
+	handler := APIKeyScope(testServiceResolver)(okHandler)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.key != nil {
+				req = withAPIKey(req, tt.key)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuthPathPolicy(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	manager := apikey.NewManager(client)
+	created, err := manager.CreateKey(context.Background(), &apikey.CreateKeyRequest{Name: "test"})
+	if err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+
+	var sawKey bool
+	handler := APIKeyAuth(manager, APIKeyAuthConfig{
+		ExemptPaths:   []string{"/api/users/public", "/health"},
+		RequiredPaths: []string{"/api/users", "/api/orders"},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, sawKey = r.Context().Value(APIKeyContextKey).(*apikey.APIKey)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name    string
+		path    string
+		key     string
+		want    int
+		wantKey bool
+	}{
+		{"required path without key", "/api/users/1", "", http.StatusUnauthorized, false},
+		{"required path with key", "/api/users/1", created.RawKey, http.StatusOK, true},
+		{"exempt path without key", "/api/users/public/docs", "", http.StatusOK, false},
+		{"exempt path skips validation", "/health", "bogus", http.StatusOK, false},
+		{"prefix matches whole segments", "/api/ordersummary", "", http.StatusOK, false},
+		{"unlisted path keeps optional auth", "/api/auth/login", "", http.StatusOK, false},
+		{"unlisted path still validates keys", "/api/auth/login", "bogus", http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sawKey = false
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if sawKey != tt.wantKey {
+				t.Errorf("key in context = %v, want %v", sawKey, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestAPIKeyValidationMetrics(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	manager := apikey.NewManager(client)
+	ctx := context.Background()
+
+	newKey := func() *apikey.CreateKeyResponse {
+		created, err := manager.CreateKey(ctx, &apikey.CreateKeyRequest{Name: "test"})
+		if err != nil {
+			t.Fatalf("CreateKey() error = %v", err)
+		}
+		return created
+	}
+	valid := newKey()
+	disabled := newKey()
+	if err := manager.RevokeKey(ctx, disabled.APIKey.ID); err != nil {
+		t.Fatalf("RevokeKey() error = %v", err)
+	}
+	expired := newKey()
+	past := time.Now().Add(-time.Hour)
+	if _, err := manager.UpdateKey(ctx, expired.APIKey.ID, &apikey.UpdateKeyRequest{ExpiresAt: &past}); err != nil {
+		t.Fatalf("UpdateKey() error = %v", err)
+	}
+
+	handler := APIKeyAuth(manager, APIKeyAuthConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name   string
+		key    string
+		reason string // "" = success
+	}{
+		{"valid key", valid.RawKey, ""},
+		{"unknown key", "gw_unknown", metrics.APIKeyNotFound},
+		{"revoked key", disabled.RawKey, metrics.APIKeyDisabled},
+		{"expired key", expired.RawKey, metrics.APIKeyExpired},
+	}
+	reasons := []string{metrics.APIKeyNotFound, metrics.APIKeyDisabled, metrics.APIKeyExpired}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := make(map[string]int64)
+			succeededBefore, _ := metrics.Get().APIKeyValidations("")
+			for _, reason := range reasons {
+				_, before[reason] = metrics.Get().APIKeyValidations(reason)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+			req.Header.Set("X-API-Key", tt.key)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			succeeded, _ := metrics.Get().APIKeyValidations("")
+			wantSucceeded := succeededBefore
+			if tt.reason == "" {
+				wantSucceeded++
+			}
+			if succeeded != wantSucceeded {
+				t.Errorf("validations = %d, want %d", succeeded, wantSucceeded)
+			}
+			for _, reason := range reasons {
+				_, failed := metrics.Get().APIKeyValidations(reason)
+				want := before[reason]
+				if reason == tt.reason {
+					want++
+				}
+				if failed != want {
+					t.Errorf("failures{reason=%q} = %d, want %d", reason, failed, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuthGlobalRequiredWithExemptions(t *testing.T) {
+	handler := APIKeyAuth(nil, APIKeyAuthConfig{Required: true, ExemptPaths: []string{"/health"}})(okHandler)
+
+	for path, want := range map[string]int{"/health": http.StatusOK, "/api/users": http.StatusUnauthorized} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func TestIsAdminPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/admin", true},
+		{"/admin/", true},
+		{"/admin/foo", true},
+		{"/administrator", false},
+		{"/admin-ui", false},
+		{"/adminx/foo", false},
+		{"/api/admin", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsAdminPath(tt.path); got != tt.want {
+			t.Errorf("IsAdminPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestWrappedWriterFlushesIncrementally(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	release := make(chan struct{})
+
+	streaming := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			t.Error("wrapped writer does not implement http.Flusher")
+			return
+		}
+		w.Write([]byte("first\n"))
+		flusher.Flush()
+		<-release
+		w.Write([]byte("second\n"))
+	})
+
+	handler := Chain(streaming,
+		Recover(logger),
+		Metrics(),
+		Logger(logger, LoggerConfig{}),
+		BodyLogger(logger, BodyLogConfig{}),
+	)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	defer close(release)
+
+	resp, err := http.Get(srv.URL + "/stream")
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The first chunk must arrive while the handler is still blocked
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read error = %v", err)
+	}
+	if line != "first\n" {
+		t.Errorf("first chunk = %q, want %q", line, "first\n")
+	}
+}
+
+func TestWrappedWriterHijack(t *testing.T) {
+	hijacked := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Hijack() error = %v", err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		buf.Flush()
+	})
+
+	srv := httptest.NewServer(Chain(hijacked, Metrics()))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/upgrade", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("status = %d, want 101", resp.StatusCode)
+	}
+
+	// Writers without hijack support report ErrNotSupported instead of panicking
+	rw := &responseWriter{ResponseWriter: httptest.NewRecorder()}
+	if _, _, err := rw.Hijack(); err != http.ErrNotSupported {
+		t.Errorf("Hijack() on recorder error = %v, want ErrNotSupported", err)
+	}
+}
+
+func newTestLimiter(t *testing.T) *ratelimit.RateLimiter {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return ratelimit.New(client, 2, time.Minute)
+}
+
+func TestRateLimitUnlimitedKey(t *testing.T) {
+	handler := RateLimit(newTestLimiter(t), RateLimitConfig{BurstSize: 2})(okHandler)
+
+	tests := []struct {
+		name        string
+		key         *apikey.APIKey
+		wantLimited bool
+		wantBypass  bool
+	}{
+		{"unlimited key", &apikey.APIKey{ID: "internal", Unlimited: true}, false, true},
+		{"normal key", &apikey.APIKey{ID: "normal"}, true, false},
+		{"anonymous", nil, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limited := false
+			for i := 0; i < 5; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+				if tt.key != nil {
+					req = withAPIKey(req, tt.key)
+				}
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				if rec.Code == http.StatusTooManyRequests {
+					limited = true
+				}
+				if got := rec.Header().Get("X-RateLimit-Bypass") == "true"; got != tt.wantBypass {
+					t.Errorf("request %d: bypass header = %v, want %v", i+1, got, tt.wantBypass)
+				}
+			}
+			if limited != tt.wantLimited {
+				t.Errorf("throttled = %v, want %v", limited, tt.wantLimited)
+			}
+		})
+	}
+}
+
+func TestRateLimitAnonymousAndAuthenticatedLimits(t *testing.T) {
+	handler := RateLimit(newTestLimiter(t), RateLimitConfig{
+		BurstSize:        10,
+		AnonymousRPM:     3,
+		AuthenticatedRPM: 600,
+	})(okHandler)
+
+	// allowed counts requests let through out of 12 sent back to back
+	allowed := func(key *apikey.APIKey) int {
+		n := 0
+		for i := 0; i < 12; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+			if key != nil {
+				req = withAPIKey(req, key)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code == http.StatusOK {
+				n++
+			}
+		}
+		return n
+	}
+
+	if got := allowed(nil); got != 3 {
+		t.Errorf("anonymous allowed = %d, want 3", got)
+	}
+	if got := allowed(&apikey.APIKey{ID: "partner"}); got != 10 {
+		t.Errorf("authenticated allowed = %d, want the full burst of 10", got)
+	}
+}
+
+func TestRateLimitDecisionMetrics(t *testing.T) {
+	handler := RateLimit(newTestLimiter(t), RateLimitConfig{BurstSize: 2})(okHandler)
+
+	tests := []struct {
+		dimension string
+		key       *apikey.APIKey
+	}{
+		{metrics.RateLimitAnonymous, nil},
+		{metrics.RateLimitAPIKey, &apikey.APIKey{ID: "metered"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dimension, func(t *testing.T) {
+			allowedBefore, deniedBefore := metrics.Get().RateLimitDecisions(tt.dimension)
+			totalBefore := metrics.Get().GetMetricsData()["rate_limited_total"].(int64)
+
+			// A burst of 2 admits two requests and rejects the third
+			for i := 0; i < 3; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+				req.RemoteAddr = "198.51.100.20:1234"
+				if tt.key != nil {
+					req = withAPIKey(req, tt.key)
+				}
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			allowed, denied := metrics.Get().RateLimitDecisions(tt.dimension)
+			if got := allowed - allowedBefore; got != 2 {
+				t.Errorf("allowed delta = %d, want 2", got)
+			}
+			if got := denied - deniedBefore; got != 1 {
+				t.Errorf("denied delta = %d, want 1", got)
+			}
+			if got := metrics.Get().GetMetricsData()["rate_limited_total"].(int64) - totalBefore; got != 1 {
+				t.Errorf("rate_limited_total delta = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestRateLimitWarningBand(t *testing.T) {
+	handler := RateLimit(newTestLimiter(t), RateLimitConfig{BurstSize: 10, WarnThreshold: 0.3})(okHandler)
+
+	for i := 1; i <= 11; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+		req.RemoteAddr = "203.0.113.9:1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		// Remaining counts down 9..0; below 3 is the warning band
+		remaining, _ := strconv.Atoi(rec.Header().Get("X-RateLimit-Remaining"))
+		wantWarning := rec.Code == http.StatusOK && remaining < 3
+		if got := rec.Header().Get("X-RateLimit-Warning") != ""; got != wantWarning {
+			t.Errorf("request %d (remaining %d, status %d): warning = %v, want %v", i, remaining, rec.Code, got, wantWarning)
+		}
+		if i <= 10 && rec.Code != http.StatusOK {
+			t.Errorf("request %d: status = %d, the warning must not change admission", i, rec.Code)
+		}
+		if i == 8 && rec.Header().Get("X-RateLimit-Warning") != "approaching limit" {
+			t.Errorf("request 8: expected the first warning")
+		}
+	}
+}
+
+func TestRateLimitSkipsPreflight(t *testing.T) {
+	handler := RateLimit(newTestLimiter(t), RateLimitConfig{BurstSize: 2})(okHandler)
+
+	send := func(method string, preflight bool) int {
+		req := httptest.NewRequest(method, "/api/users", nil)
+		req.RemoteAddr = "203.0.113.7:1234"
+		if preflight {
+			req.Header.Set("Origin", "https://app.example.com")
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i := 0; i < 10; i++ {
+		if code := send(http.MethodOptions, true); code != http.StatusOK {
+			t.Fatalf("preflight %d status = %d, want 200", i+1, code)
+		}
+	}
+
+	// The bucket is untouched, so the client still has its full burst
+	for i := 0; i < 2; i++ {
+		if code := send(http.MethodGet, false); code != http.StatusOK {
+			t.Errorf("request %d after preflights status = %d, want 200", i+1, code)
+		}
+	}
+	if code := send(http.MethodGet, false); code != http.StatusTooManyRequests {
+		t.Errorf("request beyond burst status = %d, want 429", code)
+	}
+}
+
+func TestRateLimitExemptPaths(t *testing.T) {
+	handler := RateLimit(newTestLimiter(t), RateLimitConfig{
+		BurstSize:   2,
+		ExemptPaths: []string{"/health", "/metrics"},
+	})(okHandler)
+
+	send := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "203.0.113.9:1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A scraper far beyond the burst is never throttled
+	for i := 0; i < 20; i++ {
+		for _, path := range []string{"/metrics", "/health"} {
+			rec := send(path)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s scrape %d status = %d, want 200", path, i+1, rec.Code)
+			}
+			if rec.Header().Get("X-RateLimit-Remaining") != "" {
+				t.Fatalf("%s carries rate limit headers", path)
+			}
+		}
+	}
+
+	// Proxied paths from the same client still have the full burst, then 429
+	for i := 0; i < 2; i++ {
+		if rec := send("/api/users"); rec.Code != http.StatusOK {
+			t.Errorf("proxied request %d status = %d, want 200", i+1, rec.Code)
+		}
+	}
+	if rec := send("/api/users"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("proxied request beyond burst status = %d, want 429", rec.Code)
+	}
+	// Prefixes match whole segments only
+	if rec := send("/metricsfoo"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("/metricsfoo status = %d, want 429", rec.Code)
+	}
+	// Admin endpoints aren't exempt by default. They have their own bucket
+	// per connection, which a forged X-Forwarded-For doesn't reset.
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/admin/circuit-breakers", nil)
+		req.RemoteAddr = "203.0.113.9:1234"
+		req.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(i+1))
+		req = withAPIKey(req, &apikey.APIKey{ID: "unlimited", Unlimited: true})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		want := http.StatusOK
+		if i == 2 {
+			want = http.StatusTooManyRequests
+		}
+		if rec.Code != want {
+			t.Errorf("admin request %d status = %d, want %d", i+1, rec.Code, want)
+		}
+	}
+}
+
+func TestRateLimitLeakyBucketSpacesRequests(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	limiter := ratelimit.New(client, 1200, time.Minute) // one request per 50ms
+	if err := limiter.SetAlgorithm(ratelimit.AlgorithmLeakyBucket); err != nil {
+		t.Fatalf("SetAlgorithm() error = %v", err)
+	}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	limiter.SetClock(clk)
+
+	const interval = 50 * time.Millisecond
+	reached := make(chan time.Time, 4)
+	handler := RateLimit(limiter, RateLimitConfig{BurstSize: 3})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached <- clk.Now()
+	}))
+
+	codes := make(chan int, 4)
+	send := func() {
+		go func() {
+			req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+			req.RemoteAddr = "203.0.113.11:1234"
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			codes <- rec.Code
+		}()
+	}
+	waitForTimers := func(n int) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); clk.Timers() < n; {
+			if time.Now().After(deadline) {
+				t.Fatalf("queued requests = %d, want %d", clk.Timers(), n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	expectReached := func(want time.Time) {
+		t.Helper()
+		select {
+		case at := <-reached:
+			if !at.Equal(want) {
+				t.Errorf("request reached the handler after %v, want %v", at.Sub(start), want.Sub(start))
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no request reached the handler at %v", want.Sub(start))
+		}
+	}
+
+	// A burst of 4: the first goes straight through, the next two queue one
+	// interval apart and the fourth finds the queue of 3 full
+	send()
+	expectReached(start)
+	send()
+	waitForTimers(1)
+	send()
+	waitForTimers(2)
+	send()
+	if counts := map[int]int{<-codes: 1, <-codes: 1}; counts[http.StatusOK] != 1 || counts[http.StatusTooManyRequests] != 1 {
+		t.Fatalf("first and fourth request statuses = %v, want 200 and 429", counts)
+	}
+
+	clk.Advance(interval - time.Millisecond)
+	select {
+	case <-reached:
+		t.Fatal("queued request released before its turn")
+	default:
+	}
+	clk.Advance(time.Millisecond)
+	expectReached(start.Add(interval))
+	clk.Advance(interval)
+	expectReached(start.Add(2 * interval))
+	for range 2 {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("queued request status = %d, want 200", code)
+		}
+	}
+}
+
+func TestAdminAuthRoles(t *testing.T) {
+	store, err := adminauth.NewStore([]adminauth.User{
+		{Username: "root", PasswordHash: adminauth.HashPassword("rootpw"), Role: adminauth.RoleAdmin},
+		{Username: "viewer", PasswordHash: adminauth.HashPassword("viewpw"), Role: adminauth.RoleReadOnly},
+	})
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	var gotRole adminauth.Role
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRole, _ = r.Context().Value(AdminRoleContextKey).(adminauth.Role)
+		w.WriteHeader(http.StatusOK)
+	})
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := AdminAuth(store, logger)(inner)
+
+	tests := []struct {
+		user, pass string
+		method     string
+		path       string
+		want       int
+		wantRole   adminauth.Role
+	}{
+		{"viewer", "viewpw", http.MethodGet, "/admin/circuit-breakers", http.StatusOK, adminauth.RoleReadOnly},
+		{"viewer", "viewpw", http.MethodPost, "/admin/circuit-breakers/user-service/reset", http.StatusForbidden, ""},
+		{"viewer", "viewpw", http.MethodDelete, "/admin/apikeys/abc", http.StatusForbidden, ""},
+		{"root", "rootpw", http.MethodPost, "/admin/circuit-breakers/user-service/reset", http.StatusOK, adminauth.RoleAdmin},
+		{"root", "rootpw", http.MethodDelete, "/admin/apikeys/abc", http.StatusOK, adminauth.RoleAdmin},
+		{"viewer", "wrong", http.MethodGet, "/admin/circuit-breakers", http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.user+" "+tt.method+" "+tt.path, func(t *testing.T) {
+			gotRole = ""
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.SetBasicAuth(tt.user, tt.pass)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if gotRole != tt.wantRole {
+				t.Errorf("context role = %q, want %q", gotRole, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestLoggerSlowRequestsOnly(t *testing.T) {
+	var logs bytes.Buffer
+	logger := newJSONLogger(&logs, slog.LevelInfo)
+
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend", "backend-1:8080")
+		if r.URL.Path == "/slow" {
+			time.Sleep(30 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := Logger(logger, LoggerConfig{SlowRequestThreshold: 20 * time.Millisecond})(inner)
+
+	for _, path := range []string{"/fast", "/slow", "/fast"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d log lines, want only the slow request:\n%s", len(lines), logs.String())
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("log line is not JSON: %v", err)
+	}
+	if entry["level"] != "WARN" || entry["msg"] != "slow request" {
+		t.Errorf("level/msg = %v/%v, want WARN/slow request", entry["level"], entry["msg"])
+	}
+	if entry["path"] != "/slow" || entry["backend"] != "backend-1:8080" {
+		t.Errorf("path/backend = %v/%v, want /slow/backend-1:8080", entry["path"], entry["backend"])
+	}
+	if ms, _ := entry["duration_ms"].(float64); ms < 20 {
+		t.Errorf("duration_ms = %v, want >= 20", entry["duration_ms"])
+	}
+}
+
+func TestLoggerSamplesFastRequests(t *testing.T) {
+	var logs bytes.Buffer
+	logger := newJSONLogger(&logs, slog.LevelInfo)
+
+	handler := Logger(logger, LoggerConfig{SlowRequestThreshold: time.Hour, SampleRate: 1})(okHandler)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+
+	if !strings.Contains(logs.String(), `"msg":"request"`) {
+		t.Errorf("sample rate 1 should log fast requests, got %q", logs.String())
+	}
+}
+
+func TestRejectSmuggling(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := RejectSmuggling(logger)(okHandler)
+
+	tests := []struct {
+		name             string
+		contentLength    []string
+		transferEncoding []string
+		want             int
+	}{
+		{"content-length only", []string{"5"}, nil, http.StatusOK},
+		{"chunked only", nil, []string{"chunked"}, http.StatusOK},
+		{"content-length and chunked", []string{"5"}, []string{"chunked"}, http.StatusBadRequest},
+		{"duplicate content-length", []string{"5", "5"}, nil, http.StatusBadRequest},
+		{"conflicting content-length", []string{"5", "6"}, nil, http.StatusBadRequest},
+		{"content-length list", []string{"5, 6"}, nil, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader("hello"))
+			for _, v := range tt.contentLength {
+				req.Header.Add("Content-Length", v)
+			}
+			for _, v := range tt.transferEncoding {
+				req.Header.Add("Transfer-Encoding", v)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusBadRequest && rec.Header().Get("Connection") != "close" {
+				t.Errorf("Connection = %q, want close", rec.Header().Get("Connection"))
+			}
+		})
+	}
+}
+
+func TestMaxHeaderSize(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := MaxHeaderSize(1024, logger)(okHandler)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"small headers", map[string]string{"X-Request-Id": "abc"}, http.StatusOK},
+		{"one large header", map[string]string{"Cookie": strings.Repeat("a", 2048)}, http.StatusRequestHeaderFieldsTooLarge},
+		{"many headers adding up", func() map[string]string {
+			headers := make(map[string]string)
+			for i := range 40 {
+				headers["X-Custom-"+strconv.Itoa(i)] = strings.Repeat("b", 20)
+			}
+			return headers
+		}(), http.StatusRequestHeaderFieldsTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusOK {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body is not JSON: %v (%q)", err, rec.Body.String())
+			}
+			if body["error"] != "Request Header Fields Too Large" {
+				t.Errorf("error = %q", body["error"])
+			}
+		})
+	}
+}
+
+func TestAttributesPopulatedOnce(t *testing.T) {
+	resolves := 0
+	resolver := func(r *http.Request) (string, bool) {
+		resolves++
+		return testServiceResolver(r)
+	}
+
+	var got *RequestAttributes
+	var gotIP, gotID string
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = AttributesFrom(r.Context())
+		// Later header changes don't alter the resolved client IP
+		r.Header.Set("X-Forwarded-For", "192.0.2.99")
+		gotIP = ClientIP(r)
+		gotID = RequestID(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := Chain(inner, Attributes(resolver), APIKeyScope(resolver))
+
+	t.Run("generated request id", func(t *testing.T) {
+		resolves = 0
+		req := withAPIKey(httptest.NewRequest(http.MethodGet, "/api/users/1", nil), &apikey.APIKey{ID: "k"})
+		req.Header.Set("X-Forwarded-For", "203.0.113.5, 10.0.0.1")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if got == nil {
+			t.Fatal("attributes missing from context")
+		}
+		if got.ClientIP != "203.0.113.5" || gotIP != "203.0.113.5" {
+			t.Errorf("client IP = %q / ClientIP() = %q, want 203.0.113.5", got.ClientIP, gotIP)
+		}
+		if got.Service != "user-service" {
+			t.Errorf("service = %q, want user-service", got.Service)
+		}
+		if len(got.RequestID) != 32 || gotID != got.RequestID {
+			t.Errorf("request id = %q / RequestID() = %q, want a generated 32-char id", got.RequestID, gotID)
+		}
+		if rec.Header().Get("X-Request-ID") != got.RequestID {
+			t.Errorf("X-Request-ID = %q, want %q", rec.Header().Get("X-Request-ID"), got.RequestID)
+		}
+		if resolves != 1 {
+			t.Errorf("service resolved %d times, want once", resolves)
+		}
+	})
+
+	t.Run("client request id", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/unrouted", nil)
+		req.Header.Set("X-Request-ID", "trace-abc-123")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if got.RequestID != "trace-abc-123" {
+			t.Errorf("request id = %q, want the client's", got.RequestID)
+		}
+		if got.Service != "" {
+			t.Errorf("service = %q, want none", got.Service)
+		}
+	})
+
+	t.Run("unsafe request id replaced", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+		req.Header.Set("X-Request-ID", "bad id\twith spaces")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if got.RequestID == "bad id\twith spaces" {
+			t.Error("unsafe client request id kept")
+		}
+	})
+}
+
+func TestConcurrencyLimitShedsExcessLoad(t *testing.T) {
+	const limit, total = 3, 20
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	entered := make(chan struct{}, total)
+	release := make(chan struct{})
+	handler := ConcurrencyLimit(limit, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// Fill every slot, then hit the gateway with the rest of the load
+	codes := make(chan int, total)
+	serve := func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+		if rec.Code == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+			t.Error("shed response without Retry-After")
+		}
+		codes <- rec.Code
+	}
+	for i := 0; i < limit; i++ {
+		go serve()
+		<-entered
+	}
+	for i := limit; i < total; i++ {
+		go serve()
+	}
+	for i := limit; i < total; i++ {
+		if code := <-codes; code != http.StatusServiceUnavailable {
+			t.Errorf("request beyond the cap got %d, want 503", code)
+		}
+	}
+
+	close(release)
+	for i := 0; i < limit; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("admitted request got %d, want 200", code)
+		}
+	}
+
+	// A panic unwinding through the limiter still frees its slot
+	panicky := ConcurrencyLimit(1, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	for i := 0; i < 2; i++ {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("request %d was shed, want it to reach the handler (slot leaked)", i+1)
+				}
+			}()
+			panicky.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+	}
+}
+
+func TestLoggerAccessLogFormats(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	clf := `^203\.0\.113\.7 - alice \[\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "POST /api/users\?page=2 HTTP/1\.1" 201 5`
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{LogFormatCLF, clf + `\n$`},
+		{LogFormatCombined, clf + ` "https://app\.example/" "curl/8\.0 \\"quoted\\""\n$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			handler := Logger(logger, LoggerConfig{Format: tt.format, Output: &out})(inner)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/users?page=2", nil)
+			req.RemoteAddr = "203.0.113.7:51000"
+			req.SetBasicAuth("alice", "secret")
+			req.Header.Set("Referer", "https://app.example/")
+			req.Header.Set("User-Agent", `curl/8.0 "quoted"`)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if !regexp.MustCompile(tt.want).MatchString(out.String()) {
+				t.Errorf("access log line = %q, want match for %s", out.String(), tt.want)
+			}
+		})
+	}
+
+	t.Run("empty response", func(t *testing.T) {
+		var out bytes.Buffer
+		handler := Logger(logger, LoggerConfig{Format: LogFormatCLF, Output: &out})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if !strings.HasSuffix(out.String(), `"GET / HTTP/1.1" 204 -`+"\n") {
+			t.Errorf("access log line = %q, want size \"-\" for an empty body", out.String())
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// DefaultBodyBufferThreshold is the largest request body kept in memory for retries
+const DefaultBodyBufferThreshold = 4 << 20 // 4 MiB
+
+// bufferedBody holds a request body so it can be replayed on retries. Bodies
+// up to the threshold stay in memory; larger ones spill to a temp file.
+type bufferedBody struct {
+	data []byte
+	file *os.File
+	size int64
+}
+
+// bufferBody reads src fully. Bodies larger than threshold are written to a
+// temp file in dir (os.TempDir when empty); Close removes it.
+func bufferBody(src io.Reader, threshold int64, dir string) (*bufferedBody, error) {
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(src, threshold+1))
+	if err != nil {
+		return nil, err
+	}
+	if n <= threshold {
+		return &bufferedBody{data: buf.Bytes(), size: n}, nil
+	}
+
+	file, err := os.CreateTemp(dir, "gateway-body-*")
+	if err != nil {
+		return nil, err
+	}
+	b := &bufferedBody{file: file}
+
+	// Spill what was read so far, then stream the remainder to disk
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		b.Close()
+		return nil, err
+	}
+	rest, err := io.Copy(file, src)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	b.size = n + rest
+	return b, nil
+}
+
+// spilled reports whether the body lives on disk rather than in memory
+func (b *bufferedBody) spilled() bool {
+	return b.file != nil
+}
+
+// reader returns a fresh reader positioned at the start of the body
+func (b *bufferedBody) reader() io.ReadCloser {
+	if b.file != nil {
+		return io.NopCloser(io.NewSectionReader(b.file, 0, b.size))
+	}
+	return io.NopCloser(bytes.NewReader(b.data))
+}
+
+// Close releases the temp file, if any
+func (b *bufferedBody) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	b.file.Close()
+	return os.Remove(b.file.Name())
+}
+
+// cappedBody ends a backend response one byte past the size limit, so an
+// oversized response is detected without reading it in full
+type cappedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (c *cappedBody) Read(p []byte) (int, error) {
+	if c.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.ReadCloser.Read(p)
+	c.remaining -= int64(n)
+	return n, err
+}
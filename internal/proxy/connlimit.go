@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/bimakw/api-gateway/internal/loadbalancer"
+	"github.com/bimakw/api-gateway/internal/metrics"
+)
+
+// connLimiter caps the requests in flight to each backend of a service, so a
+// burst can't exhaust a backend's connections
+type connLimiter struct {
+	service string
+	wait    time.Duration
+	slots   map[string]*backendSlots // key: backend URL string
+}
+
+type backendSlots struct {
+	sem   chan struct{}
+	label string // credentials redacted, for metrics
+}
+
+// newConnLimiter returns a limiter admitting max concurrent requests per
+// backend, or nil when max is not positive
+func newConnLimiter(service string, backends []*loadbalancer.Backend, max int, wait time.Duration) *connLimiter {
+	if max <= 0 {
+		return nil
+	}
+	l := &connLimiter{service: service, wait: wait, slots: make(map[string]*backendSlots, len(backends))}
+	for _, b := range backends {
+		l.slots[b.URL.String()] = &backendSlots{sem: make(chan struct{}, max), label: b.URL.Redacted()}
+	}
+	return l
+}
+
+// acquire takes a slot on backend, waiting up to l.wait for one to free up.
+// It reports false when none did; otherwise release must be called once the
+// attempt is done. A nil limiter admits everything.
+func (l *connLimiter) acquire(ctx context.Context, backend *loadbalancer.Backend) (release func(), ok bool) {
+	if l == nil {
+		return func() {}, true
+	}
+	slots := l.slots[backend.URL.String()]
+	if slots == nil {
+		return func() {}, true
+	}
+
+	select {
+	case slots.sem <- struct{}{}:
+	default:
+		if l.wait <= 0 {
+			return nil, false
+		}
+		timer := time.NewTimer(l.wait)
+		defer timer.Stop()
+		select {
+		case slots.sem <- struct{}{}:
+		case <-timer.C:
+			return nil, false
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+
+	metrics.Get().AddBackendConnections(l.service, slots.label, 1)
+	return func() {
+		<-slots.sem
+		metrics.Get().AddBackendConnections(l.service, slots.label, -1)
+	}, true
+}
@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// negotiateEncoding makes a compressed backend response acceptable to the
+// client. An encoding the client accepts passes through untouched; gzip or
+// deflate it doesn't accept is decoded, and re-encoded as gzip when the
+// client takes that instead. Other encodings can't be decoded here and are
+// forwarded as they are.
+func negotiateEncoding(resp *http.Response) {
+	coding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if coding == "" || coding == "identity" {
+		return
+	}
+	acceptEncoding := resp.Request.Header.Get("Accept-Encoding")
+	if acceptsEncoding(acceptEncoding, coding) || !decodable(coding) {
+		return
+	}
+
+	recompress := acceptsEncoding(acceptEncoding, "gzip")
+	if recompress {
+		resp.Header.Set("Content-Encoding", "gzip")
+	} else {
+		resp.Header.Del("Content-Encoding")
+	}
+	// The bytes on the wire change, so their length is unknown and a strong
+	// validator no longer describes them
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		resp.Header.Set("ETag", "W/"+etag)
+	}
+	if !varyIncludes(resp.Header, "Accept-Encoding") {
+		resp.Header.Add("Vary", "Accept-Encoding")
+	}
+
+	if bodyless(resp) {
+		return
+	}
+	var body io.ReadCloser = &decodedBody{src: resp.Body, coding: coding}
+	if recompress {
+		body = newGzipBody(body)
+	}
+	resp.Body = body
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header value allows
+// coding, explicitly or through "*". No header at all accepts anything.
+func acceptsEncoding(acceptEncoding, coding string) bool {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return true
+	}
+	explicit, wildcard := -1.0, -1.0
+	for _, entry := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(entry, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = parsed
+			}
+		}
+		switch {
+		case name == coding || (coding == "gzip" && name == "x-gzip"):
+			explicit = q
+		case name == "*":
+			wildcard = q
+		}
+	}
+	if explicit >= 0 {
+		return explicit > 0
+	}
+	return wildcard > 0
+}
+
+// decodable reports whether the gateway can decode a content coding
+func decodable(coding string) bool {
+	return coding == "gzip" || coding == "x-gzip" || coding == "deflate"
+}
+
+func varyIncludes(header http.Header, name string) bool {
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field == "*" || strings.EqualFold(field, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bodyless reports whether resp can't carry a body to transcode
+func bodyless(resp *http.Response) bool {
+	return resp.Request.Method == http.MethodHead ||
+		resp.StatusCode == http.StatusNoContent ||
+		resp.StatusCode == http.StatusNotModified
+}
+
+// decodedBody decodes a gzip or deflate body. The decoder is opened on the
+// first Read, since opening it already reads the stream header.
+type decodedBody struct {
+	src     io.ReadCloser
+	coding  string
+	decoder io.ReadCloser
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.decoder == nil {
+		var err error
+		if b.coding == "deflate" {
+			// HTTP's deflate is the zlib format, not raw DEFLATE
+			b.decoder, err = zlib.NewReader(b.src)
+		} else {
+			b.decoder, err = gzip.NewReader(b.src)
+		}
+		if err != nil {
+			b.decoder = nil
+			return 0, err
+		}
+	}
+	return b.decoder.Read(p)
+}
+
+func (b *decodedBody) Close() error {
+	if b.decoder != nil {
+		b.decoder.Close()
+	}
+	return b.src.Close()
+}
+
+// gzipBody compresses the wrapped body as it's read. Each chunk read from it
+// is flushed on its own so streamed responses aren't held back.
+type gzipBody struct {
+	src   io.ReadCloser
+	out   bytes.Buffer
+	zw    *gzip.Writer
+	chunk []byte
+	done  bool
+}
+
+func newGzipBody(src io.ReadCloser) *gzipBody {
+	b := &gzipBody{src: src, chunk: make([]byte, 32*1024)}
+	b.zw = gzip.NewWriter(&b.out)
+	return b
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	for b.out.Len() == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+		n, err := b.src.Read(b.chunk)
+		if n > 0 {
+			b.zw.Write(b.chunk[:n])
+			b.zw.Flush()
+		}
+		if err == io.EOF {
+			b.zw.Close()
+			b.done = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	return b.out.Read(p)
+}
+
+func (b *gzipBody) Close() error {
+	return b.src.Close()
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"text/template"
+
+	"github.com/bimakw/api-gateway/config"
+	"github.com/bimakw/api-gateway/internal/retry"
+)
+
+// statusClientClosedRequest is the non-standard status (borrowed from nginx)
+// recorded when the client went away before the backend answered
+const statusClientClosedRequest = 499
+
+// Error codes in the JSON body of failed backend round trips
+const (
+	errorCodeUpstreamTimeout = "upstream_timeout"
+	errorCodeUpstreamRefused = "upstream_connection_refused"
+	errorCodeUpstreamGoAway  = "upstream_goaway"
+	errorCodeUpstreamError   = "upstream_error"
+)
+
+// Formats of the error responses the gateway produces itself
+const (
+	// ErrorFormatJSON wraps errors in {"error","code","message"} (the default)
+	ErrorFormatJSON = "json"
+	// ErrorFormatMinimal sends only the status and headers, leaving the body
+	// empty so clients don't parse a schema that isn't the backend's
+	ErrorFormatMinimal = "minimal"
+	// ErrorFormatTemplate renders config.ProxyConfig.ErrorTemplate
+	ErrorFormatTemplate = "template"
+)
+
+// gatewayError is an error response produced by the gateway rather than a
+// backend. Its fields are what custom error templates can use.
+type gatewayError struct {
+	Status  int
+	Error   string
+	Code    string
+	Message string
+	Service string
+}
+
+// errorWriter renders gateway errors in the configured format
+type errorWriter struct {
+	format      string
+	template    *template.Template
+	contentType string
+}
+
+// newErrorWriter validates the error format and parses the template, which
+// gets a "json" function for quoting values
+func newErrorWriter(cfg config.ProxyConfig) (*errorWriter, error) {
+	ew := &errorWriter{format: cfg.ErrorFormat, contentType: cfg.ErrorContentType}
+	switch ew.format {
+	case "":
+		ew.format = ErrorFormatJSON
+	case ErrorFormatJSON, ErrorFormatMinimal:
+	case ErrorFormatTemplate:
+		tmpl, err := template.New("error").Funcs(template.FuncMap{
+			"json": func(v any) (string, error) {
+				b, err := json.Marshal(v)
+				return string(b), err
+			},
+		}).Parse(cfg.ErrorTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid error template: %w", err)
+		}
+		ew.template = tmpl
+	default:
+		return nil, fmt.Errorf("unknown error format %q (want %s, %s or %s)",
+			ew.format, ErrorFormatJSON, ErrorFormatMinimal, ErrorFormatTemplate)
+	}
+	if ew.contentType == "" {
+		ew.contentType = "application/json"
+	}
+	return ew, nil
+}
+
+// write sends e with its status. Headers already set on w, such as
+// Retry-After or Allow, are kept in every format.
+func (ew *errorWriter) write(w http.ResponseWriter, e gatewayError) {
+	switch ew.format {
+	case ErrorFormatMinimal:
+		w.WriteHeader(e.Status)
+		return
+	case ErrorFormatTemplate:
+		var buf bytes.Buffer
+		if err := ew.template.Execute(&buf, e); err == nil {
+			w.Header().Set("Content-Type", ew.contentType)
+			w.WriteHeader(e.Status)
+			w.Write(buf.Bytes())
+			return
+		}
+		// A template failing on these fields falls back to the JSON envelope
+	}
+
+	body, _ := json.Marshal(struct {
+		Error   string `json:"error"`
+		Code    string `json:"code,omitempty"`
+		Message string `json:"message"`
+	}{e.Error, e.Code, e.Message})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	w.Write(body)
+}
+
+// backendFailure is how a failed backend round trip is reported to the client
+type backendFailure struct {
+	status int
+	code   string
+	title  string
+}
+
+// classifyBackendError maps a transport error to a status: timeouts become
+// 504, a canceled request 499 and everything else, refused connections and
+// draining HTTP/2 connections included, 502
+func classifyBackendError(err error) backendFailure {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return backendFailure{status: statusClientClosedRequest}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return backendFailure{http.StatusGatewayTimeout, errorCodeUpstreamTimeout, "Gateway timeout"}
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return backendFailure{http.StatusBadGateway, errorCodeUpstreamRefused, "Bad gateway"}
+	case retry.IsGoAway(err):
+		return backendFailure{http.StatusBadGateway, errorCodeUpstreamGoAway, "Bad gateway"}
+	}
+	return backendFailure{http.StatusBadGateway, errorCodeUpstreamError, "Bad gateway"}
+}
+
+// newErrorHandler returns the httputil.ReverseProxy ErrorHandler for one
+// backend. Failures are written by errs with an error code; a client that
+// disconnected gets only a 499 status, for logs and metrics, since nobody
+// is left to read a body.
+func newErrorHandler(logger *slog.Logger, errs *errorWriter, serviceName string, target *url.URL) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		if rec, ok := w.(*retryableResponseRecorder); ok {
+			rec.backendErr = err
+		}
+		failure := classifyBackendError(err)
+		if r.Context().Err() == context.Canceled {
+			failure = backendFailure{status: statusClientClosedRequest}
+		}
+
+		if failure.status == statusClientClosedRequest {
+			logger.Info("Client closed request",
+				"service", serviceName,
+				"backend", target.String(),
+				"path", r.URL.Path,
+			)
+			w.WriteHeader(statusClientClosedRequest)
+			return
+		}
+
+		logger.Warn("Backend error",
+			"service", serviceName,
+			"backend", target.String(),
+			"code", failure.code,
+			"error", err.Error(),
+		)
+		errs.write(w, gatewayError{
+			Status:  failure.status,
+			Error:   failure.title,
+			Code:    failure.code,
+			Message: err.Error(),
+			Service: serviceName,
+		})
+	}
+}
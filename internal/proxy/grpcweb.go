@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+
+	// grpcWebTrailerFlag marks the length-prefixed frame carrying trailers
+	grpcWebTrailerFlag = 0x80
+)
+
+// grpcWebKey carries the client's gRPC-Web content type from the director to
+// the response translation
+type grpcWebKey struct{}
+
+// isGRPCWebRequest reports whether r is a gRPC-Web call, binary or base64 text
+func isGRPCWebRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return strings.HasPrefix(mediaType, grpcWebContentType)
+}
+
+// grpcTransport returns a transport speaking HTTP/2 only, as gRPC requires:
+// h2 over TLS and prior-knowledge h2c for plain http backends
+func grpcTransport(base *http.Transport) http.RoundTripper {
+	transport := base.Clone()
+	transport.Protocols = new(http.Protocols)
+	transport.Protocols.SetHTTP2(true)
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	return grpcRoundTripper{transport}
+}
+
+// grpcRoundTripper announces trailer support on translated calls. It's set
+// here because httputil drops TE from the outgoing request after the director.
+type grpcRoundTripper struct {
+	*http.Transport
+}
+
+func (t grpcRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if _, ok := req.Context().Value(grpcWebKey{}).(string); ok {
+		req.Header.Set("Te", "trailers")
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+// translateGRPCWebRequest turns an outgoing gRPC-Web request into plain
+// gRPC: the content type drops "-web" and a base64 text body is decoded.
+// Message frames are identical in both protocols, so a
+// binary body is forwarded untouched.
+func translateGRPCWebRequest(req *http.Request) {
+	contentType := req.Header.Get("Content-Type")
+	*req = *req.WithContext(context.WithValue(req.Context(), grpcWebKey{}, contentType))
+
+	if isGRPCWebText(contentType) && req.Body != nil {
+		encoded, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		body, decodeErr := decodeGRPCWebText(encoded)
+		if err != nil || decodeErr != nil {
+			// Forward nothing rather than garbage; the backend answers
+			// the empty call with an error status
+			body = nil
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+		req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	req.Header.Set("Content-Type", toGRPCContentType(contentType))
+}
+
+// translateGRPCWebResponse re-frames a gRPC response for a gRPC-Web client:
+// data frames pass through and the HTTP/2 trailers are appended as a final
+// trailer frame, base64 encoded again for text clients. Responses to requests
+// that weren't gRPC-Web are left alone.
+func translateGRPCWebResponse(resp *http.Response) {
+	clientType, ok := resp.Request.Context().Value(grpcWebKey{}).(string)
+	if !ok {
+		return
+	}
+
+	// Trailers go into the body, so none are announced to the client; the
+	// transport sets resp.Trailer again once the body reaches EOF
+	resp.Trailer = nil
+	resp.Header.Del("Trailer")
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); strings.HasPrefix(mediaType, "application/grpc") {
+		resp.Header.Set("Content-Type", toGRPCWebContentType(resp.Header.Get("Content-Type"), isGRPCWebText(clientType)))
+	}
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+
+	var body io.ReadCloser = &grpcWebBody{ReadCloser: resp.Body, resp: resp}
+	if isGRPCWebText(clientType) {
+		body = newBase64Body(body)
+	}
+	resp.Body = body
+}
+
+// grpcWebBody yields the backend's data frames followed by a trailer frame
+// built from the trailers received at EOF
+type grpcWebBody struct {
+	io.ReadCloser
+	resp    *http.Response
+	pending *bytes.Reader
+}
+
+func (b *grpcWebBody) Read(p []byte) (int, error) {
+	if b.pending != nil {
+		return b.pending.Read(p)
+	}
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		// Taken off the response so they aren't also sent as HTTP trailers
+		trailer := b.resp.Trailer
+		b.resp.Trailer = nil
+		b.pending = bytes.NewReader(encodeGRPCWebTrailer(trailer))
+		if n > 0 {
+			return n, nil
+		}
+		return b.pending.Read(p)
+	}
+	return n, err
+}
+
+// encodeGRPCWebTrailer frames trailers as lower-case "name: value" lines.
+// A trailers-only response carries its status in the headers instead, so no
+// frame is written when there are no trailers.
+func encodeGRPCWebTrailer(trailer http.Header) []byte {
+	if len(trailer) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(trailer))
+	for name := range trailer {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var block bytes.Buffer
+	for _, name := range names {
+		for _, value := range trailer[name] {
+			block.WriteString(strings.ToLower(name))
+			block.WriteString(": ")
+			block.WriteString(value)
+			block.WriteString("\r\n")
+		}
+	}
+
+	frame := make([]byte, 5, 5+block.Len())
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(block.Len()))
+	return append(frame, block.Bytes()...)
+}
+
+// base64Body encodes each chunk read from the wrapped body on its own. The
+// gRPC-Web text format allows padding between chunks, so streamed messages
+// reach the client without waiting for the whole response.
+type base64Body struct {
+	io.ReadCloser
+	pending []byte
+	buf     []byte
+}
+
+func newBase64Body(body io.ReadCloser) *base64Body {
+	return &base64Body{ReadCloser: body, buf: make([]byte, 3*1024)}
+}
+
+func (b *base64Body) Read(p []byte) (int, error) {
+	for len(b.pending) == 0 {
+		n, err := b.ReadCloser.Read(b.buf)
+		if n > 0 {
+			b.pending = base64.StdEncoding.AppendEncode(nil, b.buf[:n])
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+// decodeGRPCWebText decodes a base64 body that may be a concatenation of
+// separately padded chunks, by decoding one 4-byte quantum at a time
+func decodeGRPCWebText(encoded []byte) ([]byte, error) {
+	encoded = bytes.Join(bytes.Fields(encoded), nil)
+	if len(encoded)%4 != 0 {
+		return nil, base64.CorruptInputError(len(encoded))
+	}
+	decoded := make([]byte, 0, len(encoded)/4*3)
+	var quantum [3]byte
+	for i := 0; i < len(encoded); i += 4 {
+		n, err := base64.StdEncoding.Decode(quantum[:], encoded[i:i+4])
+		if err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, quantum[:n]...)
+	}
+	return decoded, nil
+}
+
+func isGRPCWebText(contentType string) bool {
+	return strings.HasPrefix(contentType, grpcWebTextContentType)
+}
+
+// toGRPCContentType maps application/grpc-web[-text][+codec] to
+// application/grpc[+codec]
+func toGRPCContentType(contentType string) string {
+	return "application/grpc" + grpcCodec(contentType)
+}
+
+// toGRPCWebContentType maps application/grpc[+codec] back to the gRPC-Web
+// flavour the client used
+func toGRPCWebContentType(contentType string, text bool) string {
+	if text {
+		return grpcWebTextContentType + grpcCodec(contentType)
+	}
+	return grpcWebContentType + grpcCodec(contentType)
+}
+
+// grpcCodec returns the "+codec" suffix of a gRPC media type, if any
+func grpcCodec(contentType string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	if i := strings.IndexByte(mediaType, '+'); i >= 0 {
+		return strings.TrimSpace(mediaType[i:])
+	}
+	return ""
+}
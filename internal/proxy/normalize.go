@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"net/url"
+	"strings"
+)
+
+// normalizeUpstreamPath rewrites u's path for backends sensitive to encoding:
+// percent-encoded unreserved characters (letters, digits, "-", ".", "_", "~")
+// are decoded, remaining escapes get upper-case hex, and with lowercase set
+// the path is lower-cased. Repeated slashes collapse to one, for callers that
+// don't route through a ServeMux (which redirects them to the cleaned path).
+// Escapes of reserved characters such as %2F are kept so segment boundaries
+// don't move.
+func normalizeUpstreamPath(u *url.URL, lowercase bool) {
+	escaped := u.EscapedPath()
+	var b strings.Builder
+	b.Grow(len(escaped))
+	var last byte
+
+	for i := 0; i < len(escaped); i++ {
+		c := escaped[i]
+		if c == '%' && i+2 < len(escaped) && isHex(escaped[i+1]) && isHex(escaped[i+2]) {
+			decoded := unhex(escaped[i+1])<<4 | unhex(escaped[i+2])
+			if isUnreserved(decoded) {
+				c = decoded
+			} else {
+				b.WriteByte('%')
+				b.WriteString(strings.ToUpper(escaped[i+1 : i+3]))
+				last = '%'
+				i += 2
+				continue
+			}
+			i += 2
+		}
+		if lowercase && 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c == '/' && last == '/' {
+			continue
+		}
+		b.WriteByte(c)
+		last = c
+	}
+
+	normalized := b.String()
+	if normalized == escaped {
+		return
+	}
+	path, err := url.PathUnescape(normalized)
+	if err != nil {
+		return
+	}
+	u.Path = path
+	u.RawPath = normalized
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
@@ -2,64 +2,239 @@ package proxy
 
 import (
 	"bytes"
-	"io"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math"
+	"mime"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bimakw/api-gateway/config"
 	"github.com/bimakw/api-gateway/internal/circuitbreaker"
 	"github.com/bimakw/api-gateway/internal/loadbalancer"
 	"github.com/bimakw/api-gateway/internal/metrics"
+	"github.com/bimakw/api-gateway/internal/middleware"
 	"github.com/bimakw/api-gateway/internal/retry"
+	"github.com/bimakw/api-gateway/internal/unixsocket"
 )
 
 type ReverseProxy struct {
-	services   map[string]*serviceProxy
-	cbRegistry *circuitbreaker.Registry
-	retryer    *retry.Retryer
-	logger     *slog.Logger
-	mu         sync.RWMutex
+	services       map[string]*serviceProxy
+	prefixes       []string      // service prefixes, longest first
+	defaultService *serviceProxy // receives unmatched requests, may be nil
+	cbRegistry     *circuitbreaker.Registry
+	retryer        *retry.Retryer
+	diagnostics    bool // add X-Gateway-* diagnostic headers to responses
+	circuitState   bool // add X-Circuit-State to responses
+	errors         *errorWriter
+	logger         *slog.Logger
+	mu             sync.RWMutex
+
+	bodyBufferThreshold int64  // request bodies above this size spill to disk
+	bodySpillDir        string // temp directory for spilled bodies ("" = os.TempDir)
 }
 
 type serviceProxy struct {
 	config       config.ServiceConfig
 	loadBalancer *loadbalancer.LoadBalancer
 	proxies      map[string]*httputil.ReverseProxy // key: backend URL string
+	retryer      *retry.Retryer
+	// variants are alternate backends chosen by config.VersionParam
+	variants map[string]*serviceProxy
+	// disabled takes the service offline without touching its config
+	disabled atomic.Bool
+	// removed is set under rp.mu once RemoveService drops the service
+	removed atomic.Bool
+	// retryBodyMatch retries responses with config.RetryOnBodyStatus whose
+	// body matches (nil = status-based retries only)
+	retryBodyMatch *regexp.Regexp
+	// stale holds last good GET responses for config.ServeStale (nil = off)
+	stale *staleCache
+	// connLimit caps requests in flight per backend (nil = unlimited)
+	connLimit *connLimiter
+}
+
+// variantFor returns the backend variant selected by the request's version
+// query parameter, or sp itself when the value is absent or unknown
+func (sp *serviceProxy) variantFor(r *http.Request) *serviceProxy {
+	if len(sp.variants) == 0 {
+		return sp
+	}
+	if variant, ok := sp.variants[r.URL.Query().Get(sp.config.VersionParam)]; ok {
+		return variant
+	}
+	return sp
 }
 
-func New(services []config.ServiceConfig, cbConfig circuitbreaker.Config, retryConfig retry.Config, logger *slog.Logger) (*ReverseProxy, error) {
+func (sp *serviceProxy) setRetryer(retryer *retry.Retryer) {
+	sp.retryer = retryer
+	for _, variant := range sp.variants {
+		variant.retryer = retryer
+	}
+}
+
+func New(services []config.ServiceConfig, proxyConfig config.ProxyConfig, cbConfig circuitbreaker.Config, retryConfig retry.Config, logger *slog.Logger) (*ReverseProxy, error) {
 	rp := &ReverseProxy{
-		services:   make(map[string]*serviceProxy),
-		cbRegistry: circuitbreaker.NewRegistry(cbConfig),
-		retryer:    retry.New(retryConfig),
-		logger:     logger,
+		services:    make(map[string]*serviceProxy),
+		cbRegistry:  circuitbreaker.NewRegistry(cbConfig),
+		retryer:     retry.New(retryConfig),
+		diagnostics: !proxyConfig.DisableDiagnosticHeaders,
+		logger:      logger,
+
+		circuitState: proxyConfig.ExposeCircuitState,
+
+		bodyBufferThreshold: proxyConfig.BodyBufferThreshold,
+		bodySpillDir:        proxyConfig.BodySpillDir,
+	}
+	if rp.bodyBufferThreshold <= 0 {
+		rp.bodyBufferThreshold = DefaultBodyBufferThreshold
 	}
+	errs, err := newErrorWriter(proxyConfig)
+	if err != nil {
+		return nil, err
+	}
+	rp.errors = errs
 
 	for _, svc := range services {
-		svcProxy, err := createServiceProxy(svc, logger)
+		svcProxy, err := createServiceProxy(svc, proxyConfig, rp.errors, logger)
 		if err != nil {
 			return nil, err
 		}
+		if svcProxy == nil {
+			logger.Warn("Service has no backends, skipping", "service", svc.Name, "path", svc.PathPrefix)
+			continue
+		}
+		svcProxy.setRetryer(rp.retryerFor(svc, retryConfig))
+		rp.registerBreaker(svc, cbConfig)
 		rp.services[svc.PathPrefix] = svcProxy
+		rp.prefixes = append(rp.prefixes, svc.PathPrefix)
 
 		logger.Info("Service configured",
 			"service", svc.Name,
 			"path", svc.PathPrefix,
 			"backends", len(svcProxy.proxies),
-			"strategy", svc.GetStrategy(),
+			"strategy", svcProxy.config.GetStrategy(),
+			"retries", !svc.DisableRetry,
+		)
+	}
+
+	// Longest prefix first so explicit, more specific routes win
+	sort.SliceStable(rp.prefixes, func(i, j int) bool {
+		return len(rp.prefixes[i]) > len(rp.prefixes[j])
+	})
+
+	if proxyConfig.DefaultService != nil {
+		svcProxy, err := createServiceProxy(*proxyConfig.DefaultService, proxyConfig, rp.errors, logger)
+		if err != nil {
+			return nil, err
+		}
+		if svcProxy != nil {
+			svcProxy.setRetryer(rp.retryerFor(*proxyConfig.DefaultService, retryConfig))
+			rp.registerBreaker(*proxyConfig.DefaultService, cbConfig)
+		}
+		rp.defaultService = svcProxy
+	}
+
+	if rp.defaultService != nil {
+		logger.Info("Default service configured",
+			"service", rp.defaultService.config.Name,
+			"backends", len(rp.defaultService.proxies),
+			"strategy", rp.defaultService.config.GetStrategy(),
 		)
 	}
 
+	if !rp.hasServices() {
+		logger.Warn("No services configured, all proxied requests will return 503")
+	}
+
 	return rp, nil
 }
 
-func createServiceProxy(svc config.ServiceConfig, logger *slog.Logger) (*serviceProxy, error) {
+// retryerFor returns the shared retryer, or a zero-retry one for services that opt out
+func (rp *ReverseProxy) retryerFor(svc config.ServiceConfig, retryConfig retry.Config) *retry.Retryer {
+	if !svc.DisableRetry {
+		return rp.retryer
+	}
+	retryConfig.MaxRetries = 0
+	return retry.New(retryConfig)
+}
+
+// registerBreaker creates the service's circuit breaker up front when it has a
+// warm-up period, so the period starts with the gateway rather than on the
+// first request
+func (rp *ReverseProxy) registerBreaker(svc config.ServiceConfig, cbConfig circuitbreaker.Config) {
+	if svc.CBWarmUpSeconds > 0 {
+		cbConfig.WarmUp = time.Duration(svc.CBWarmUpSeconds) * time.Second
+	}
+	if cbConfig.WarmUp > 0 {
+		rp.cbRegistry.Register(svc.Name, cbConfig)
+	}
+}
+
+// createServiceProxy builds the backends and load balancer for svc. Services
+// without their own strategy or response limit inherit the proxy-wide ones.
+func createServiceProxy(svc config.ServiceConfig, defaults config.ProxyConfig, errs *errorWriter, logger *slog.Logger) (*serviceProxy, error) {
+	if svc.Strategy == "" {
+		svc.Strategy = defaults.LoadBalanceStrategy
+	}
+	if svc.MaxResponseBytes == 0 {
+		svc.MaxResponseBytes = defaults.MaxResponseBytes
+	}
+	if svc.MaxBackendConns == 0 {
+		svc.MaxBackendConns = defaults.MaxBackendConns
+	}
+	authorization, err := upstreamAuthorization(svc.UpstreamAuth)
+	if err != nil {
+		return nil, fmt.Errorf("service %s: %w", svc.Name, err)
+	}
+	if len(svc.AllowedMethods) > 0 {
+		methods := make([]string, len(svc.AllowedMethods))
+		for i, m := range svc.AllowedMethods {
+			methods[i] = strings.ToUpper(m)
+		}
+		svc.AllowedMethods = methods
+	}
+	for _, patterns := range [][]string{svc.AllowedPaths, svc.BlockedPaths} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("service %s: invalid path pattern %q: %w", svc.Name, pattern, err)
+			}
+		}
+	}
+	if !validTrailingSlash(svc.TrailingSlash) {
+		return nil, fmt.Errorf("service %s: unknown trailing slash mode %q", svc.Name, svc.TrailingSlash)
+	}
+	if !knownMethods[svc.GetHealthCheckMethod()] {
+		return nil, fmt.Errorf("service %s: unknown health check method %q", svc.Name, svc.HealthCheckMethod)
+	}
+	var retryBodyMatch *regexp.Regexp
+	if svc.RetryOnBodyMatch != "" {
+		if retryBodyMatch, err = regexp.Compile(svc.RetryOnBodyMatch); err != nil {
+			return nil, fmt.Errorf("service %s: invalid retry body pattern: %w", svc.Name, err)
+		}
+		if svc.RetryOnBodyStatus == 0 {
+			svc.RetryOnBodyStatus = http.StatusOK
+		}
+	}
+	if !loadbalancer.ValidStrategy(svc.GetStrategy()) {
+		return nil, fmt.Errorf("service %s: unknown load balancing strategy %q (want one of %s)",
+			svc.Name, svc.Strategy, strings.Join(loadbalancer.Strategies, ", "))
+	}
+
 	backendConfigs := svc.GetBackends()
 	if len(backendConfigs) == 0 {
 		return nil, nil
@@ -82,143 +257,670 @@ func createServiceProxy(svc config.ServiceConfig, logger *slog.Logger) (*service
 		}
 		backends = append(backends, backend)
 
-		// Create reverse proxy for this backend
-		proxy := httputil.NewSingleHostReverseProxy(targetURL)
+		// Create reverse proxy for this backend; unix sockets are reached
+		// through a placeholder http host and a socket-dialing transport
+		dialURL := targetURL
+		socketPath, isUnix := unixsocket.SocketPath(targetURL)
+		if isUnix {
+			dialURL = unixsocket.HTTPURL()
+		}
+
+		proxy := httputil.NewSingleHostReverseProxy(dialURL)
+		if isUnix {
+			proxy.Transport = unixsocket.Transport(socketPath)
+		}
+		if svc.GRPCWeb {
+			base, ok := proxy.Transport.(*http.Transport)
+			if !ok {
+				base = http.DefaultTransport.(*http.Transport)
+			}
+			proxy.Transport = grpcTransport(base)
+		}
 
 		// Customize the director to handle path manipulation
 		originalDirector := proxy.Director
 		proxy.Director = func(req *http.Request) {
+			if svc.RewriteRedirects {
+				// Remember the client-facing host before it's replaced below
+				*req = *req.WithContext(context.WithValue(req.Context(), gatewayHostKey{}, req.Host))
+			}
+
 			originalDirector(req)
 
+			if svc.TrailingSlash == TrailingSlashStrip {
+				trimTrailingSlash(req.URL)
+			}
+
 			if svc.StripPath {
-				req.URL.Path = strings.TrimPrefix(req.URL.Path, svc.PathPrefix)
-				if req.URL.Path == "" {
-					req.URL.Path = "/"
+				req.URL.Path = stripPathPrefix(req.URL.Path, svc.PathPrefix)
+				if req.URL.RawPath != "" {
+					req.URL.RawPath = stripPathPrefix(req.URL.RawPath, svc.PathPrefix)
 				}
 			}
 
-			req.Host = targetURL.Host
+			if svc.NormalizePath {
+				normalizeUpstreamPath(req.URL, svc.LowercasePath)
+			}
+
+			if svc.GRPCWeb && isGRPCWebRequest(req) {
+				translateGRPCWebRequest(req)
+			}
+
+			switch {
+			case svc.HostHeader != "":
+				req.Host = svc.HostHeader
+			case svc.PreserveHost:
+				// Keep the Host the client sent
+			default:
+				req.Host = dialURL.Host
+			}
+
+			// Backend credentials replace, never merge with, the client's
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
 		}
 
-		// Custom error handler
-		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-			logger.Warn("Backend error",
-				"service", svc.Name,
-				"backend", targetURL.String(),
-				"error", err.Error(),
-			)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadGateway)
-			w.Write([]byte(`{"error":"Service unavailable","message":"` + err.Error() + `"}`))
+		if svc.RewriteRedirects || svc.MaxResponseBytes > 0 || svc.GRPCWeb || svc.NegotiateEncoding {
+			proxy.ModifyResponse = func(resp *http.Response) error {
+				if svc.RewriteRedirects {
+					rewriteLocation(resp, targetURL, svc)
+				}
+				if svc.NegotiateEncoding {
+					negotiateEncoding(resp)
+				}
+				if svc.GRPCWeb {
+					translateGRPCWebResponse(resp)
+				}
+				if svc.MaxResponseBytes > 0 {
+					resp.Body = &cappedBody{ReadCloser: resp.Body, remaining: svc.MaxResponseBytes + 1}
+				}
+				return nil
+			}
 		}
 
+		proxy.ErrorHandler = newErrorHandler(logger, errs, svc.Name, targetURL)
+
 		proxies[targetURL.String()] = proxy
 	}
 
 	lb := loadbalancer.New(svc.GetStrategy(), backends)
+	lb.SetSlowStart(defaults.SlowStart)
+
+	sp := &serviceProxy{
+		config:         svc,
+		loadBalancer:   lb,
+		proxies:        proxies,
+		retryBodyMatch: retryBodyMatch,
+		connLimit:      newConnLimiter(svc.Name, backends, svc.MaxBackendConns, defaults.BackendConnWait),
+	}
+	if svc.ServeStale {
+		sp.stale = newStaleCache()
+	}
+
+	// Each version variant is a single-backend copy of the service, sharing
+	// its name so breaker and metrics stay per service
+	if svc.VersionParam != "" && len(svc.VersionBackends) > 0 {
+		sp.variants = make(map[string]*serviceProxy, len(svc.VersionBackends))
+		for value, backendURL := range svc.VersionBackends {
+			variantConfig := svc
+			variantConfig.TargetURL = backendURL
+			variantConfig.Backends = nil
+			variantConfig.VersionParam = ""
+			variantConfig.VersionBackends = nil
+			variant, err := createServiceProxy(variantConfig, defaults, errs, logger)
+			if err != nil {
+				return nil, err
+			}
+			if variant == nil {
+				return nil, fmt.Errorf("service %s: version %q has no backend URL", svc.Name, value)
+			}
+			sp.variants[value] = variant
+		}
+	}
 
-	return &serviceProxy{
-		config:       svc,
-		loadBalancer: lb,
-		proxies:      proxies,
-	}, nil
+	return sp, nil
 }
 
 func (rp *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !rp.hasServices() {
+		rp.writeError(w, gatewayError{
+			Status:  http.StatusServiceUnavailable,
+			Error:   "Service unavailable",
+			Message: "No services configured",
+		})
+		return
+	}
+
 	// Find matching service
-	for prefix, svc := range rp.services {
-		if strings.HasPrefix(r.URL.Path, prefix) {
-			rp.proxyWithRetry(w, r, svc)
+	if svc := rp.match(r.URL.Path); svc != nil {
+		rp.proxyWithRetry(w, r, svc)
+		return
+	}
+
+	// Fall back to the catch-all service if configured
+	if rp.defaultService != nil {
+		rp.proxyWithRetry(w, r, rp.defaultService)
+		return
+	}
+
+	// No matching service found
+	rp.writeError(w, gatewayError{
+		Status:  http.StatusNotFound,
+		Error:   "Not found",
+		Message: "No service matches the requested path",
+	})
+}
+
+type gatewayHostKey struct{}
+
+// idempotentMethod reports whether repeating a request with method has the
+// same effect as sending it once (RFC 9110, section 9.2.2)
+func idempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// knownMethods are the HTTP methods a health check may use
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// rewriteLocation points a backend redirect back at the gateway: the backend host
+// is replaced with the client-facing host and a stripped path prefix is restored.
+// Redirects to other hosts are left untouched.
+func rewriteLocation(resp *http.Response, target *url.URL, svc config.ServiceConfig) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return
+	}
+
+	loc, err := url.Parse(location)
+	if err != nil {
+		return
+	}
+
+	if loc.IsAbs() {
+		if loc.Host != target.Host {
 			return
 		}
+		if host, ok := resp.Request.Context().Value(gatewayHostKey{}).(string); ok && host != "" {
+			loc.Host = host
+		}
+		loc.Scheme = "http"
+		if resp.Request.TLS != nil {
+			loc.Scheme = "https"
+		}
+	} else if loc.Host != "" || !strings.HasPrefix(loc.Path, "/") {
+		// Protocol-relative or document-relative; nothing to fix
+		return
 	}
 
-	// No matching service found
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusNotFound)
-	w.Write([]byte(`{"error":"Not found","message":"No service matches the requested path"}`))
+	if svc.StripPath {
+		loc.Path = strings.TrimSuffix(svc.PathPrefix, "/") + loc.Path
+		if loc.RawPath != "" {
+			loc.RawPath = strings.TrimSuffix(svc.PathPrefix, "/") + loc.RawPath
+		}
+	}
+
+	resp.Header.Set("Location", loc.String())
+}
+
+// upstreamAuthorization builds the Authorization header sent to a backend,
+// or "" when the client's header should pass through
+func upstreamAuthorization(auth config.UpstreamAuth) (string, error) {
+	switch auth.Type {
+	case "":
+		return "", nil
+	case "bearer":
+		if auth.Token == "" {
+			return "", errors.New("bearer upstream auth requires a token")
+		}
+		return "Bearer " + auth.Token, nil
+	case "basic":
+		if auth.Username == "" {
+			return "", errors.New("basic upstream auth requires a username")
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth.Username+":"+auth.Password)), nil
+	default:
+		return "", fmt.Errorf("unknown upstream auth type %q (want bearer or basic)", auth.Type)
+	}
+}
+
+// methodAllowed reports whether method is in allowed; an empty list allows all
+func methodAllowed(method string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, m := range allowed {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathAccess applies a service's path lists to the full request path. Blocked
+// paths get 403; with an allowlist, paths matching none of it get 404. It
+// returns 0 when the request may proceed.
+func pathAccess(p string, allowed, blocked []string) int {
+	for _, pattern := range blocked {
+		if pathMatches(p, pattern) {
+			return http.StatusForbidden
+		}
+	}
+	if len(allowed) == 0 {
+		return 0
+	}
+	for _, pattern := range allowed {
+		if pathMatches(p, pattern) {
+			return 0
+		}
+	}
+	return http.StatusNotFound
+}
+
+// forwardedPathAccess applies cfg's path lists to the path the director will
+// forward u as, so variants it rewrites away (a stripped trailing slash, upper
+// case with LowercasePath) can't slip past a blocked path. Blocked paths also
+// match the path as sent.
+func forwardedPathAccess(u *url.URL, cfg *config.ServiceConfig) int {
+	forwarded := *u
+	if cfg.TrailingSlash == TrailingSlashStrip {
+		trimTrailingSlash(&forwarded)
+	}
+	if cfg.NormalizePath {
+		normalizeUpstreamPath(&forwarded, cfg.LowercasePath)
+	}
+	if forwarded.Path != u.Path && pathAccess(u.Path, nil, cfg.BlockedPaths) != 0 {
+		return http.StatusForbidden
+	}
+	return pathAccess(forwarded.Path, cfg.AllowedPaths, cfg.BlockedPaths)
+}
+
+// pathMatches treats patterns containing glob metacharacters as path.Match
+// globs (where * stays within one segment) and anything else as a path prefix
+func pathMatches(p, pattern string) bool {
+	if strings.ContainsAny(pattern, "*?[") {
+		ok, _ := path.Match(pattern, p)
+		return ok
+	}
+	return hasPathPrefix(p, pattern)
+}
+
+// contentTypeAllowed reports whether a request body's media type is in allowed.
+// Requests without a body and services without restrictions always pass.
+func contentTypeAllowed(r *http.Request, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	// A POST without a body (e.g. an action endpoint) has nothing to check
+	hasBody := r.ContentLength > 0 || len(r.TransferEncoding) > 0
+	if !hasBody {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(mediaType, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// injectJSONFields sets fields on a JSON object body and returns the rewritten
+// body. Non-JSON, empty or non-object bodies are returned unchanged.
+func injectJSONFields(body []byte, contentType string, fields map[string]string) ([]byte, bool) {
+	if len(fields) == 0 || len(body) == 0 || !jsonMediaType(contentType) {
+		return body, false
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(body, &object); err != nil || object == nil {
+		return body, false
+	}
+	for k, v := range fields {
+		encoded, _ := json.Marshal(v)
+		object[k] = encoded
+	}
+
+	rewritten, err := json.Marshal(object)
+	if err != nil {
+		return body, false
+	}
+	return rewritten, true
+}
+
+// jsonMediaType reports whether contentType names application/json
+func jsonMediaType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
+}
+
+// clientKey identifies the client for key-based balancing such as ip-hash.
+// It's the client IP the middleware resolved, so behind a PROXY protocol load
+// balancer a client can't pick its backend with a forged X-Forwarded-For.
+func clientKey(r *http.Request) string {
+	return middleware.ClientIP(r)
+}
+
+// hasServices reports whether any service, including the default, can receive traffic
+func (rp *ReverseProxy) hasServices() bool {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+	return len(rp.services) > 0 || rp.defaultService != nil
+}
+
+// match returns the service with the longest prefix matching path, or nil
+func (rp *ReverseProxy) match(path string) *serviceProxy {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+	for _, prefix := range rp.prefixes {
+		if hasPathPrefix(path, prefix) {
+			return rp.services[prefix]
+		}
+	}
+	return nil
+}
+
+// hasPathPrefix reports whether path is prefix or lies below it, so that
+// "/api/users" matches "/api/users" and "/api/users/1" but not "/api/usersfoo"
+func hasPathPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || path[len(prefix)] == '/'
+}
+
+// stripPathPrefix removes prefix from path, always returning an absolute path.
+// A trailing slash after the prefix is preserved ("/api/users/" -> "/").
+func stripPathPrefix(path, prefix string) string {
+	stripped := strings.TrimPrefix(path, strings.TrimSuffix(prefix, "/"))
+	if !strings.HasPrefix(stripped, "/") {
+		stripped = "/" + stripped
+	}
+	return stripped
+}
+
+// CORSOriginsFor returns the allowed CORS origins of the service that would
+// handle path, if that service overrides the global policy
+func (rp *ReverseProxy) CORSOriginsFor(path string) ([]string, bool) {
+	svc := rp.match(path)
+	if svc == nil {
+		svc = rp.defaultService
+	}
+	if svc == nil || len(svc.config.CORSAllowedOrigins) == 0 {
+		return nil, false
+	}
+	return svc.config.CORSAllowedOrigins, true
+}
+
+// ServiceNameFor returns the name of the service that would handle path
+func (rp *ReverseProxy) ServiceNameFor(path string) (string, bool) {
+	if svc := rp.match(path); svc != nil {
+		return svc.config.Name, true
+	}
+	if rp.defaultService != nil {
+		return rp.defaultService.config.Name, true
+	}
+	return "", false
 }
 
 func (rp *ReverseProxy) proxyWithRetry(w http.ResponseWriter, r *http.Request, svc *serviceProxy) {
-	// Get circuit breaker for this service
+	if svc.disabled.Load() {
+		rp.writeError(w, gatewayError{
+			Status:  http.StatusServiceUnavailable,
+			Error:   "Service unavailable",
+			Message: svc.config.Name + " is disabled",
+			Service: svc.config.Name,
+		})
+		return
+	}
+
+	svc = svc.variantFor(r)
+
+	if rp.diagnostics {
+		w.Header().Set("X-Gateway-Service", svc.config.Name)
+	}
+
+	if svc.config.TrailingSlash == TrailingSlashRedirect {
+		canonical := *r.URL
+		if trimTrailingSlash(&canonical) {
+			// A leading "//" would make the Location another host's URL
+			location := "/" + strings.TrimLeft(canonical.RequestURI(), "/")
+			// 308 keeps the method and body, unlike 301
+			http.Redirect(w, r, location, http.StatusPermanentRedirect)
+			return
+		}
+	}
+
+	if !methodAllowed(r.Method, svc.config.AllowedMethods) {
+		w.Header().Set("Allow", strings.Join(svc.config.AllowedMethods, ", "))
+		rp.writeError(w, gatewayError{
+			Status:  http.StatusMethodNotAllowed,
+			Error:   "Method not allowed",
+			Message: r.Method + " is not accepted by " + svc.config.Name,
+			Service: svc.config.Name,
+		})
+		return
+	}
+
+	switch forwardedPathAccess(r.URL, &svc.config) {
+	case http.StatusForbidden:
+		rp.writeError(w, gatewayError{
+			Status:  http.StatusForbidden,
+			Error:   "Forbidden",
+			Message: "Path is not exposed by " + svc.config.Name,
+			Service: svc.config.Name,
+		})
+		return
+	case http.StatusNotFound:
+		rp.writeError(w, gatewayError{
+			Status:  http.StatusNotFound,
+			Error:   "Not found",
+			Message: "No service matches the requested path",
+			Service: svc.config.Name,
+		})
+		return
+	}
+
+	// Reject unsupported bodies before they count against the backend
+	if !contentTypeAllowed(r, svc.config.AllowedContentTypes) {
+		rp.writeError(w, gatewayError{
+			Status:  http.StatusUnsupportedMediaType,
+			Error:   "Unsupported media type",
+			Message: "Content-Type not accepted by " + svc.config.Name,
+			Service: svc.config.Name,
+		})
+		return
+	}
+
+	// Get circuit breaker for this service. The lookup holds rp.mu so a
+	// request racing RemoveService can't create the breaker again.
+	rp.mu.RLock()
+	if svc.removed.Load() {
+		rp.mu.RUnlock()
+		rp.writeError(w, gatewayError{
+			Status:  http.StatusNotFound,
+			Error:   "Not found",
+			Message: "No service matches the requested path",
+		})
+		return
+	}
 	cb := rp.cbRegistry.Get(svc.config.Name)
+	rp.mu.RUnlock()
 
-	// Check if circuit is open
+	// Check if circuit is open. Every early return below must release the
+	// admitted slot or record an outcome so half-open probes aren't leaked.
 	if !cb.AllowRequest() {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusServiceUnavailable)
-		w.Write([]byte(`{"error":"Service unavailable","message":"Circuit breaker is open for ` + svc.config.Name + `"}`))
+		metrics.Get().IncrementCircuitBreakerShortCircuits(svc.config.Name)
+		rp.setCircuitState(w, cb)
+		if !svc.serveStale(w, r) {
+			rp.writeCircuitOpen(w, svc.config.Name, cb)
+		}
 		return
 	}
 
 	// Select a healthy backend
-	backend := svc.loadBalancer.Select()
+	backend := svc.loadBalancer.SelectFor(clientKey(r))
 	if backend == nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusServiceUnavailable)
-		w.Write([]byte(`{"error":"Service unavailable","message":"No healthy backends available for ` + svc.config.Name + `"}`))
+		cb.Release()
+		rp.writeError(w, gatewayError{
+			Status:  http.StatusServiceUnavailable,
+			Error:   "Service unavailable",
+			Message: "No healthy backends available for " + svc.config.Name,
+			Service: svc.config.Name,
+		})
 		return
 	}
 
 	// Get proxy for selected backend
 	proxy := svc.proxies[backend.URL.String()]
 	if proxy == nil {
+		cb.Release()
 		rp.logger.Error("No proxy found for backend",
 			"service", svc.config.Name,
 			"backend", backend.URL.String(),
 		)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error":"Internal error","message":"Backend proxy not found"}`))
+		rp.writeError(w, gatewayError{
+			Status:  http.StatusInternalServerError,
+			Error:   "Internal error",
+			Message: "Backend proxy not found",
+			Service: svc.config.Name,
+		})
 		return
 	}
 
-	// Buffer request body for potential retries (only for methods with body)
-	var bodyBytes []byte
+	// Buffer request body for potential retries (only for methods with body).
+	// Large bodies spill to disk instead of being held in memory.
+	var body *bufferedBody
 	if r.Body != nil && r.Method != http.MethodGet && r.Method != http.MethodHead {
 		var err error
-		bodyBytes, err = io.ReadAll(r.Body)
+		body, err = bufferBody(r.Body, rp.bodyBufferThreshold, rp.bodySpillDir)
 		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(`{"error":"Failed to read request body","message":"` + err.Error() + `"}`))
+			// A client-side failure says nothing about the backend
+			cb.Release()
+			rp.writeError(w, gatewayError{
+				Status:  http.StatusBadRequest,
+				Error:   "Failed to read request body",
+				Message: err.Error(),
+				Service: svc.config.Name,
+			})
 			return
 		}
 		r.Body.Close()
+		defer body.Close()
+
+		if len(svc.config.InjectFields) > 0 && body.spilled() && jsonMediaType(r.Header.Get("Content-Type")) {
+			// Forwarding the body untouched would skip the configured fields
+			cb.Release()
+			rp.writeError(w, gatewayError{
+				Status:  http.StatusRequestEntityTooLarge,
+				Error:   "Request entity too large",
+				Message: "Body exceeds the size " + svc.config.Name + " can transform",
+				Service: svc.config.Name,
+			})
+			return
+		}
+
+		if transformed, ok := injectJSONFields(body.data, r.Header.Get("Content-Type"), svc.config.InjectFields); ok {
+			body = &bufferedBody{data: transformed, size: int64(len(transformed))}
+			r.ContentLength = body.size
+			r.Header.Set("Content-Length", strconv.Itoa(len(transformed)))
+			r.TransferEncoding = nil
+		}
 	}
 
+	// Count the request against the backend until every attempt is done
+	metrics.Get().IncrementServiceInFlight(svc.config.Name)
+	defer metrics.Get().DecrementServiceInFlight(svc.config.Name)
+
 	start := time.Now()
 	var lastRecorder *retryableResponseRecorder
 	attempt := 0
 	selectedBackend := backend
+	circuitOpened := false
+	saturated := false
 
-	result := rp.retryer.Execute(r.Context(), func() (int, error) {
+	result := svc.retryer.Execute(r.Context(), func() (int, error) {
 		attempt++
 
-		// On retry, try to select a different backend if available
 		if attempt > 1 {
-			newBackend := svc.loadBalancer.Select()
+			// Stop retrying once earlier attempts have tripped the breaker
+			if !cb.AllowRequest() {
+				circuitOpened = true
+				return http.StatusServiceUnavailable, circuitbreaker.ErrCircuitOpen
+			}
+
+			// On retry, try to select a different backend if available
+			newBackend := svc.loadBalancer.SelectFor(clientKey(r))
 			if newBackend != nil {
 				selectedBackend = newBackend
 				proxy = svc.proxies[selectedBackend.URL.String()]
 			}
 		}
 
+		// Wait briefly for a connection slot; a backend at its limit isn't
+		// failing, so the breaker isn't charged
+		releaseConn, ok := svc.connLimit.acquire(r.Context(), selectedBackend)
+		if !ok {
+			cb.Release()
+			saturated = true
+			return http.StatusServiceUnavailable, errBackendSaturated
+		}
+		defer releaseConn()
+
 		// Restore body for retry
-		if bodyBytes != nil {
-			r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		if body != nil {
+			r.Body = body.reader()
 		}
 
 		// Create a retryable response recorder
 		lastRecorder = &retryableResponseRecorder{
-			headers:    make(http.Header),
-			body:       &bytes.Buffer{},
-			statusCode: http.StatusOK,
+			headers:     make(http.Header),
+			body:        &bytes.Buffer{},
+			statusCode:  http.StatusOK,
+			client:      w,
+			backend:     selectedBackend.URL.Host,
+			attempt:     attempt,
+			diagnostics: rp.diagnostics,
+			maxBytes:    svc.config.MaxResponseBytes,
+		}
+		if rp.circuitState {
+			lastRecorder.circuitState = cb
 		}
 
 		// Execute proxy
 		proxy.ServeHTTP(lastRecorder, r)
 
+		// A transient error reported in the body of an otherwise successful response
+		bodyMatched := svc.retryableBody(lastRecorder)
+
+		// Record every attempt so each failure counts toward tripping the breaker
+		if bodyMatched {
+			cb.RecordFailure()
+		} else {
+			cb.RecordStatus(lastRecorder.statusCode)
+		}
+
 		// Log retry attempt
 		if attempt > 1 {
 			rp.logger.Info("retry attempt",
@@ -230,42 +932,155 @@ func (rp *ReverseProxy) proxyWithRetry(w http.ResponseWriter, r *http.Request, s
 			)
 		}
 
+		// A draining backend may have processed part of the request, so
+		// only requests that are safe to repeat are replayed
+		if retry.IsGoAway(lastRecorder.backendErr) && !idempotentMethod(r.Method) {
+			return lastRecorder.statusCode, errNotIdempotent
+		}
+
+		// An oversized response would be just as large on another attempt
+		if lastRecorder.tooLarge {
+			return http.StatusBadGateway, errResponseTooLarge
+		}
+
+		// Bytes already reached the client; a retry would duplicate output
+		if lastRecorder.committed {
+			return lastRecorder.statusCode, errResponseCommitted
+		}
+
+		// The error handler's 502/504 stands in for a transport error; hand
+		// the retryer the error itself so it retries what's transient (a
+		// refused dial, a timeout) regardless of the retryable statuses
+		if lastRecorder.backendErr != nil {
+			return lastRecorder.statusCode, lastRecorder.backendErr
+		}
+
+		// Even when buffered, a retried stream could replay events or a
+		// partial download the client already acted on
+		if lastRecorder.streaming() {
+			return lastRecorder.statusCode, errStreamingResponse
+		}
+
+		if bodyMatched {
+			return lastRecorder.statusCode, errRetryableBody
+		}
+
 		return lastRecorder.statusCode, nil
 	})
 
 	// Record metrics
 	latency := time.Since(start)
-	metrics.Get().RecordServiceRequest(svc.config.Name, result.StatusCode, latency)
+	statusCode := result.StatusCode
+	if circuitOpened {
+		// The aborted attempt never reached the backend; report the last real response
+		statusCode = lastRecorder.statusCode
+		metrics.Get().IncrementCircuitBreakerShortCircuits(svc.config.Name)
+	}
+	if saturated {
+		metrics.Get().IncrementBackendConnLimitRejections(svc.config.Name)
+		if lastRecorder != nil {
+			statusCode = lastRecorder.statusCode
+		}
+	}
+	metrics.Get().RecordServiceRequest(svc.config.Name, statusCode, latency)
+	rp.setCircuitState(w, cb)
 
-	// Record circuit breaker result
-	if result.StatusCode >= 500 {
-		cb.RecordFailure()
-	} else {
-		cb.RecordSuccess()
+	// Shed before reaching the backend; a retry that found no slot falls
+	// through to the last real response instead
+	if saturated && lastRecorder == nil {
+		rp.logger.Warn("Backend connection limit reached",
+			"service", svc.config.Name,
+			"backend", selectedBackend.URL.Redacted(),
+			"limit", svc.config.MaxBackendConns,
+			"path", r.URL.Path,
+		)
+		w.Header().Set("Retry-After", "1")
+		rp.writeError(w, gatewayError{
+			Status:  http.StatusServiceUnavailable,
+			Error:   "Service unavailable",
+			Code:    "upstream_saturated",
+			Message: "Too many concurrent requests to " + svc.config.Name,
+			Service: svc.config.Name,
+		})
+		return
 	}
 
-	// Write the final response
-	if lastRecorder != nil {
-		// Copy headers
-		for key, values := range lastRecorder.headers {
-			for _, value := range values {
-				w.Header().Add(key, value)
-			}
+	if circuitOpened {
+		rp.logger.Warn("Circuit breaker opened during retries",
+			"service", svc.config.Name,
+			"attempts", attempt-1,
+			"path", r.URL.Path,
+		)
+		if !svc.serveStale(w, r) {
+			rp.writeCircuitOpen(w, svc.config.Name, cb)
 		}
+		return
+	}
 
-		// Add retry info headers
-		if result.Retried {
-			w.Header().Set("X-Retry-Count", strconv.Itoa(result.Attempts-1))
+	if lastRecorder != nil && lastRecorder.tooLarge {
+		rp.logger.Warn("Backend response exceeded size limit",
+			"service", svc.config.Name,
+			"backend", selectedBackend.URL.String(),
+			"limit_bytes", svc.config.MaxResponseBytes,
+			"streamed", lastRecorder.committed,
+			"path", r.URL.Path,
+		)
+		// A stream was already cut off at the limit; otherwise replace the response
+		if !lastRecorder.committed {
+			rp.writeError(w, gatewayError{
+				Status:  http.StatusBadGateway,
+				Error:   "Bad gateway",
+				Message: "Response from " + svc.config.Name + " exceeds the size limit",
+				Service: svc.config.Name,
+			})
 		}
+		return
+	}
 
-		// Add backend info header
-		w.Header().Set("X-Backend", selectedBackend.URL.Host)
+	// Write the final response, unless it has already been streamed. A
+	// backend failure falls back to the last good response when enabled.
+	if lastRecorder != nil && !lastRecorder.committed {
+		if lastRecorder.statusCode == http.StatusBadGateway && svc.serveStale(w, r) {
+			return
+		}
+		if svc.stale != nil {
+			svc.stale.store(r, lastRecorder)
+		}
+		lastRecorder.commit()
+	}
+}
 
-		w.WriteHeader(lastRecorder.statusCode)
-		w.Write(lastRecorder.body.Bytes())
+// writeError answers with an error produced by the gateway itself, in the
+// configured error format
+func (rp *ReverseProxy) writeError(w http.ResponseWriter, e gatewayError) {
+	rp.errors.write(w, e)
+}
+
+// setCircuitState reports cb's state in X-Circuit-State when enabled
+func (rp *ReverseProxy) setCircuitState(w http.ResponseWriter, cb *circuitbreaker.CircuitBreaker) {
+	if rp.circuitState {
+		w.Header().Set("X-Circuit-State", cb.GetState().String())
 	}
 }
 
+// writeCircuitOpen rejects a request short-circuited by cb, telling the client
+// to retry once the breaker will admit a probe
+func (rp *ReverseProxy) writeCircuitOpen(w http.ResponseWriter, serviceName string, cb *circuitbreaker.CircuitBreaker) {
+	// Round up so clients don't come back while the breaker is still open;
+	// a saturated half-open breaker reports 0 and gets the 1s minimum
+	retryAfter := int(math.Ceil(cb.OpenRemaining().Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	rp.writeError(w, gatewayError{
+		Status:  http.StatusServiceUnavailable,
+		Error:   "Service unavailable",
+		Message: "Circuit breaker is open for " + serviceName,
+		Service: serviceName,
+	})
+}
+
 // responseRecorder wraps http.ResponseWriter to capture status code
 type responseRecorder struct {
 	http.ResponseWriter
@@ -277,11 +1092,67 @@ func (r *responseRecorder) WriteHeader(code int) {
 	r.ResponseWriter.WriteHeader(code)
 }
 
-// retryableResponseRecorder buffers the response for potential retries
+var (
+	// errRetryableBody retries a response whose body signals a transient error
+	errRetryableBody = fmt.Errorf("response body matched retry pattern: %w", retry.ErrTransient)
+	// errResponseCommitted stops retries once part of a response reached the client
+	errResponseCommitted = errors.New("response already sent to client")
+	// errStreamingResponse stops retries of responses with a streaming content type
+	errStreamingResponse = errors.New("streaming response is not retried")
+	// errNotIdempotent stops retries of requests the backend may have acted on
+	errNotIdempotent = errors.New("non-idempotent request is not retried")
+	// errResponseTooLarge stops retries once a response exceeds the size limit
+	errResponseTooLarge = errors.New("response exceeds size limit")
+	// errBackendSaturated stops retries when a backend has no free connection slot
+	errBackendSaturated = errors.New("backend connection limit reached")
+)
+
+// maxRetryBodyInspect bounds how much of a response body RetryOnBodyMatch sees
+const maxRetryBodyInspect = 8 << 10
+
+// retryableBody reports whether a buffered response signals a transient error
+// through config.RetryOnBodyMatch. Streamed responses can't be retried.
+func (sp *serviceProxy) retryableBody(rec *retryableResponseRecorder) bool {
+	if sp.retryBodyMatch == nil || rec.committed || rec.statusCode != sp.config.RetryOnBodyStatus {
+		return false
+	}
+	body := rec.body.Bytes()
+	if len(body) > maxRetryBodyInspect {
+		body = body[:maxRetryBodyInspect]
+	}
+	return sp.retryBodyMatch.Match(body)
+}
+
+// streamingMediaTypes are response content types that are never retried
+var streamingMediaTypes = map[string]bool{
+	"text/event-stream":         true,
+	"application/x-ndjson":      true,
+	"application/stream+json":   true,
+	"multipart/x-mixed-replace": true,
+}
+
+// retryableResponseRecorder buffers the response for potential retries.
+// Streaming (SSE) responses are committed to the client on their first flush;
+// after that writes pass straight through and the attempt can't be retried.
 type retryableResponseRecorder struct {
 	headers    http.Header
 	body       *bytes.Buffer
 	statusCode int
+	client     http.ResponseWriter
+	backend    string
+	attempt    int
+	committed  bool
+	// diagnostics adds upstream and retry headers on commit
+	diagnostics bool
+	// circuitState, if set, is reported in X-Circuit-State on commit
+	circuitState *circuitbreaker.CircuitBreaker
+	// maxBytes caps the response body (0 = unlimited); past it the buffer is
+	// dropped, or a committed stream is cut off, and tooLarge is set
+	maxBytes int64
+	size     int64
+	tooLarge bool
+	// backendErr is the transport error reported by the error handler
+	backendErr error
 }
 
 func (r *retryableResponseRecorder) Header() http.Header {
@@ -289,6 +1160,23 @@ func (r *retryableResponseRecorder) Header() http.Header {
 }
 
 func (r *retryableResponseRecorder) Write(b []byte) (int, error) {
+	if r.tooLarge {
+		return len(b), nil
+	}
+	if r.maxBytes > 0 && r.size+int64(len(b)) > r.maxBytes {
+		r.tooLarge = true
+		if r.committed {
+			r.client.Write(b[:r.maxBytes-r.size])
+		}
+		r.body.Reset()
+		r.size = r.maxBytes
+		return len(b), nil
+	}
+
+	r.size += int64(len(b))
+	if r.committed {
+		return r.client.Write(b)
+	}
 	return r.body.Write(b)
 }
 
@@ -296,10 +1184,60 @@ func (r *retryableResponseRecorder) WriteHeader(code int) {
 	r.statusCode = code
 }
 
+// streaming reports whether the response has a streaming content type
+func (r *retryableResponseRecorder) streaming() bool {
+	mediaType, _, _ := mime.ParseMediaType(r.headers.Get("Content-Type"))
+	return streamingMediaTypes[mediaType]
+}
+
+func (r *retryableResponseRecorder) Flush() {
+	if !r.committed {
+		mediaType, _, _ := mime.ParseMediaType(r.headers.Get("Content-Type"))
+		if mediaType != "text/event-stream" {
+			return
+		}
+		r.commit()
+	}
+	http.NewResponseController(r.client).Flush()
+}
+
+// commit writes the buffered status, headers and body to the client
+func (r *retryableResponseRecorder) commit() {
+	for key, values := range r.headers {
+		for _, value := range values {
+			r.client.Header().Add(key, value)
+		}
+	}
+
+	if r.diagnostics {
+		r.client.Header().Set("X-Gateway-Upstream", r.backend)
+		r.client.Header().Set("X-Retry-Count", strconv.Itoa(r.attempt-1))
+		// X-Backend predates X-Gateway-Upstream and is kept for existing clients
+		r.client.Header().Set("X-Backend", r.backend)
+	}
+	if r.circuitState != nil {
+		// Reported as of commit, which for streams precedes the attempt's outcome
+		r.client.Header().Set("X-Circuit-State", r.circuitState.GetState().String())
+	}
+
+	r.client.WriteHeader(r.statusCode)
+	r.client.Write(r.body.Bytes())
+	r.body.Reset()
+	r.committed = true
+}
+
+// GetServices returns the services currently routed, longest prefix first,
+// followed by the default service if any
 func (rp *ReverseProxy) GetServices() []config.ServiceConfig {
-	services := make([]config.ServiceConfig, 0, len(rp.services))
-	for _, svc := range rp.services {
-		services = append(services, svc.config)
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+
+	services := make([]config.ServiceConfig, 0, len(rp.services)+1)
+	for _, prefix := range rp.prefixes {
+		services = append(services, rp.services[prefix].config)
+	}
+	if rp.defaultService != nil {
+		services = append(services, rp.defaultService.config)
 	}
 	return services
 }
@@ -316,6 +1254,90 @@ func (rp *ReverseProxy) ResetAllCircuitBreakers() {
 	rp.cbRegistry.Reset()
 }
 
+// SetServiceDisabled takes a service offline (503 for all its requests) or
+// brings it back. It reports false if no service has that name.
+func (rp *ReverseProxy) SetServiceDisabled(serviceName string, disabled bool) bool {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+
+	svc := rp.serviceNamed(serviceName)
+	if svc == nil {
+		return false
+	}
+	if svc.disabled.Swap(disabled) != disabled {
+		rp.logger.Info("Service routing changed", "service", serviceName, "disabled", disabled)
+	}
+	return true
+}
+
+// SetDisabledServices disables exactly the named services and enables the
+// rest, e.g. to apply state shared between replicas
+func (rp *ReverseProxy) SetDisabledServices(names []string) {
+	disabled := make(map[string]bool, len(names))
+	for _, name := range names {
+		disabled[name] = true
+	}
+
+	rp.mu.RLock()
+	all := make([]string, 0, len(rp.services)+1)
+	for _, svc := range rp.services {
+		all = append(all, svc.config.Name)
+	}
+	if rp.defaultService != nil {
+		all = append(all, rp.defaultService.config.Name)
+	}
+	rp.mu.RUnlock()
+
+	for _, name := range all {
+		rp.SetServiceDisabled(name, disabled[name])
+	}
+}
+
+// RemoveService stops routing to a service and drops its circuit breaker and
+// metrics, so a service that's gone doesn't linger in stats. Requests already
+// dispatched to it finish normally. It reports false if no routed service has
+// that name; the default service can't be removed.
+func (rp *ReverseProxy) RemoveService(serviceName string) bool {
+	rp.mu.Lock()
+	prefix, found := "", false
+	for p, svc := range rp.services {
+		if svc.config.Name == serviceName {
+			prefix, found = p, true
+			break
+		}
+	}
+	if !found {
+		rp.mu.Unlock()
+		return false
+	}
+	svc := rp.services[prefix]
+	svc.removed.Store(true)
+	for _, variant := range svc.variants {
+		variant.removed.Store(true)
+	}
+	delete(rp.services, prefix)
+	rp.prefixes = slices.DeleteFunc(rp.prefixes, func(p string) bool { return p == prefix })
+	rp.cbRegistry.Remove(serviceName)
+	rp.mu.Unlock()
+
+	metrics.Get().RemoveService(serviceName)
+	rp.logger.Info("Service removed", "service", serviceName, "path", prefix)
+	return true
+}
+
+// serviceNamed finds a service by name. Callers must hold rp.mu.
+func (rp *ReverseProxy) serviceNamed(name string) *serviceProxy {
+	for _, svc := range rp.services {
+		if svc.config.Name == name {
+			return svc
+		}
+	}
+	if rp.defaultService != nil && rp.defaultService.config.Name == name {
+		return rp.defaultService
+	}
+	return nil
+}
+
 func (rp *ReverseProxy) UpdateBackendHealth(serviceName, instanceURL string, healthy bool) {
 	rp.mu.RLock()
 	defer rp.mu.RUnlock()
@@ -331,6 +1353,10 @@ func (rp *ReverseProxy) UpdateBackendHealth(serviceName, instanceURL string, hea
 			return
 		}
 	}
+
+	if rp.defaultService != nil && rp.defaultService.config.Name == serviceName {
+		rp.defaultService.loadBalancer.SetHealthy(instanceURL, healthy)
+	}
 }
 
 func (rp *ReverseProxy) GetBackendStats(serviceName string) []BackendStats {
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"log/slog"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// Route is the resolved routing and resilience configuration of one service
+type Route struct {
+	Service    string
+	PathPrefix string
+	Default    bool // catch-all for unmatched paths
+	Backends   []string
+	Strategy   string
+	StripPath  bool
+	MaxRetries int
+
+	// Effective circuit breaker settings
+	BreakerMaxFailures  int
+	BreakerResetTimeout time.Duration
+	BreakerWarmUp       time.Duration
+}
+
+// Routes lists every service, most specific prefix first, with the default
+// service last. Backend URLs have credentials redacted.
+func (rp *ReverseProxy) Routes() []Route {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+
+	routes := make([]Route, 0, len(rp.prefixes)+1)
+	for _, prefix := range rp.prefixes {
+		routes = append(routes, rp.route(rp.services[prefix], false))
+	}
+	if rp.defaultService != nil {
+		routes = append(routes, rp.route(rp.defaultService, true))
+	}
+	return routes
+}
+
+func (rp *ReverseProxy) route(svc *serviceProxy, isDefault bool) Route {
+	backends := make([]string, 0, len(svc.proxies))
+	for _, b := range svc.config.GetBackends() {
+		backends = append(backends, redactedURL(b.URL))
+	}
+	sort.Strings(backends)
+
+	cb := rp.cbRegistry.ConfigFor(svc.config.Name)
+	return Route{
+		Service:             svc.config.Name,
+		PathPrefix:          svc.config.PathPrefix,
+		Default:             isDefault,
+		Backends:            backends,
+		Strategy:            svc.config.GetStrategy(),
+		StripPath:           svc.config.StripPath,
+		MaxRetries:          svc.retryer.MaxRetries(),
+		BreakerMaxFailures:  cb.MaxFailures,
+		BreakerResetTimeout: cb.ResetTimeout,
+		BreakerWarmUp:       cb.WarmUp,
+	}
+}
+
+// LogRoutes writes one structured line per route so the resolved route table
+// can be checked at startup
+func LogRoutes(logger *slog.Logger, routes []Route) {
+	for _, r := range routes {
+		logger.Info("Route",
+			"service", r.Service,
+			"path", r.PathPrefix,
+			"default", r.Default,
+			"backends", r.Backends,
+			"strategy", r.Strategy,
+			"strip_path", r.StripPath,
+			"max_retries", r.MaxRetries,
+			slog.Group("circuit_breaker",
+				"max_failures", r.BreakerMaxFailures,
+				"reset_timeout", r.BreakerResetTimeout.String(),
+				"warm_up", r.BreakerWarmUp.String(),
+			),
+		)
+	}
+}
+
+func redactedURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
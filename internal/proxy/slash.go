@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Trailing slash modes accepted by config.ServiceConfig.TrailingSlash
+const (
+	// TrailingSlashPreserve forwards the path as the client sent it (the default)
+	TrailingSlashPreserve = "preserve"
+	// TrailingSlashStrip forwards "/api/users/" to the backend as "/api/users"
+	TrailingSlashStrip = "strip"
+	// TrailingSlashRedirect answers "/api/users/" with a 308 to "/api/users"
+	TrailingSlashRedirect = "redirect"
+)
+
+func validTrailingSlash(mode string) bool {
+	switch mode {
+	case "", TrailingSlashPreserve, TrailingSlashStrip, TrailingSlashRedirect:
+		return true
+	}
+	return false
+}
+
+// trimTrailingSlash removes trailing slashes from u's path, leaving the root
+// path alone. It reports whether the path changed.
+func trimTrailingSlash(u *url.URL) bool {
+	if len(u.Path) <= 1 || !strings.HasSuffix(u.Path, "/") {
+		return false
+	}
+	u.Path = withoutTrailingSlash(u.Path)
+	if u.RawPath != "" {
+		u.RawPath = withoutTrailingSlash(u.RawPath)
+	}
+	return true
+}
+
+func withoutTrailingSlash(p string) string {
+	if trimmed := strings.TrimRight(p, "/"); trimmed != "" {
+		return trimmed
+	}
+	return "/"
+}
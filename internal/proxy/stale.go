@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxStaleEntries bounds the responses kept per service for ServeStale
+	maxStaleEntries = 1000
+	// maxStaleBodyBytes skips keeping responses larger than this
+	maxStaleBodyBytes = 1 << 20
+)
+
+// staleResponse is the last successful response for one request URI
+type staleResponse struct {
+	header http.Header
+	body   []byte
+	stored time.Time
+	// vary holds the request's values of the headers named by the response's
+	// Vary, which later requests must match
+	vary map[string]string
+}
+
+// staleCache keeps the last successful response of cacheable GETs so they
+// can be served when the backend is unavailable. It is never used to answer
+// requests while the backend works.
+type staleCache struct {
+	mu      sync.Mutex
+	entries map[string]*staleResponse // request URI -> last 200 response
+}
+
+func newStaleCache() *staleCache {
+	return &staleCache{entries: make(map[string]*staleResponse)}
+}
+
+// staleCacheable reports whether a request may share responses with other
+// clients: GETs without credentials or an API key the backend could
+// personalize on
+func staleCacheable(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		r.Header.Get("Authorization") == "" &&
+		r.Header.Get("Cookie") == "" &&
+		r.Header.Get("X-API-Key") == ""
+}
+
+// varyFields lists the request headers named by header's Vary. ok is false
+// for "Vary: *", which no other request can match.
+func varyFields(header http.Header) (fields []string, ok bool) {
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			switch field {
+			case "":
+			case "*":
+				return nil, false
+			default:
+				fields = append(fields, http.CanonicalHeaderKey(field))
+			}
+		}
+	}
+	return fields, true
+}
+
+// matches reports whether r sends the same values for the entry's Vary
+// headers as the request that produced it
+func (e *staleResponse) matches(r *http.Request) bool {
+	for name, value := range e.vary {
+		if strings.Join(r.Header.Values(name), ", ") != value {
+			return false
+		}
+	}
+	return true
+}
+
+// store remembers a buffered 200 response unless the backend marked it as
+// not shareable
+func (c *staleCache) store(r *http.Request, rec *retryableResponseRecorder) {
+	if !staleCacheable(r) || rec.statusCode != http.StatusOK || rec.committed || rec.tooLarge {
+		return
+	}
+	if rec.body.Len() > maxStaleBodyBytes || rec.headers.Get("Set-Cookie") != "" {
+		return
+	}
+	cacheControl := strings.ToLower(rec.headers.Get("Cache-Control"))
+	if strings.Contains(cacheControl, "no-store") || strings.Contains(cacheControl, "private") {
+		return
+	}
+	fields, ok := varyFields(rec.headers)
+	if !ok {
+		return
+	}
+
+	entry := &staleResponse{
+		header: rec.headers.Clone(),
+		body:   append([]byte(nil), rec.body.Bytes()...),
+		stored: time.Now(),
+		vary:   make(map[string]string, len(fields)),
+	}
+	for _, name := range fields {
+		entry.vary[name] = strings.Join(r.Header.Values(name), ", ")
+	}
+	key := r.URL.RequestURI()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= maxStaleEntries {
+		// Make room by dropping an arbitrary entry
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = entry
+}
+
+// serve writes the stored response for r with a stale warning, reporting
+// false when there is none
+func (c *staleCache) serve(w http.ResponseWriter, r *http.Request) bool {
+	if !staleCacheable(r) {
+		return false
+	}
+	c.mu.Lock()
+	entry, ok := c.entries[r.URL.RequestURI()]
+	c.mu.Unlock()
+	if !ok || !entry.matches(r) {
+		return false
+	}
+
+	for key, values := range entry.header {
+		w.Header()[key] = append([]string(nil), values...)
+	}
+	w.Header().Set("Warning", `110 - "Response is Stale"`)
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(entry.stored).Seconds())))
+	w.WriteHeader(http.StatusOK)
+	w.Write(entry.body)
+	return true
+}
+
+// serveStale answers r from the stale cache when ServeStale is enabled
+func (sp *serviceProxy) serveStale(w http.ResponseWriter, r *http.Request) bool {
+	return sp.stale != nil && sp.stale.serve(w, r)
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bimakw/api-gateway/internal/unixsocket"
+)
+
+// WarmUp opens perBackend keep-alive connections to every backend so the
+// first proxied requests don't pay for TCP and TLS setup. Each connection is
+// made by a concurrent request to the backend's /health endpoint, with the
+// method and path the health checker already probes, through the backend's
+// own transport; once the response is drained the connection stays in the
+// idle pool. It returns how many connections were warmed. Failures are only
+// logged, since a cold backend still works. Unix socket backends are skipped.
+func (rp *ReverseProxy) WarmUp(ctx context.Context, perBackend int) int {
+	if perBackend <= 0 {
+		return 0
+	}
+
+	rp.mu.RLock()
+	services := make([]*serviceProxy, 0, len(rp.services)+1)
+	for _, svc := range rp.services {
+		services = append(services, svc)
+	}
+	if rp.defaultService != nil {
+		services = append(services, rp.defaultService)
+	}
+	rp.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	var warmed atomic.Int64
+	for _, svc := range services {
+		for _, sp := range append([]*serviceProxy{svc}, variantsOf(svc)...) {
+			for backendURL, proxy := range sp.proxies {
+				target, err := url.Parse(backendURL)
+				if err != nil {
+					continue
+				}
+				if _, isUnix := unixsocket.SocketPath(target); isUnix {
+					continue
+				}
+				for i := 0; i < perBackend; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						if err := warmConnection(ctx, transportOf(proxy), sp.config.GetHealthCheckMethod(), target); err != nil {
+							rp.logger.Warn("Backend warm-up failed",
+								"service", sp.config.Name,
+								"backend", backendURL,
+								"error", err,
+							)
+							return
+						}
+						warmed.Add(1)
+					}()
+				}
+			}
+		}
+	}
+	wg.Wait()
+	return int(warmed.Load())
+}
+
+func variantsOf(sp *serviceProxy) []*serviceProxy {
+	variants := make([]*serviceProxy, 0, len(sp.variants))
+	for _, variant := range sp.variants {
+		variants = append(variants, variant)
+	}
+	return variants
+}
+
+func transportOf(proxy *httputil.ReverseProxy) http.RoundTripper {
+	if proxy.Transport != nil {
+		return proxy.Transport
+	}
+	return http.DefaultTransport
+}
+
+// warmConnection sends one health request and drains the response so its
+// connection is returned to the transport's idle pool
+func warmConnection(ctx context.Context, transport http.RoundTripper, method string, target *url.URL) error {
+	req, err := http.NewRequestWithContext(ctx, method, target.String()+"/health", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
@@ -0,0 +1,253 @@
+// Package proxyproto parses PROXY protocol v1/v2 headers sent by L4 load
+// balancers (AWS NLB, HAProxy in TCP mode) so the original client address is
+// reported as the connection's RemoteAddr.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderTimeout bounds how long a connection may take to send its header
+const DefaultHeaderTimeout = 5 * time.Second
+
+const (
+	v1Prefix    = "PROXY "
+	v1MaxLength = 107 // including CRLF, per the spec
+
+	v2HeaderLength = 16
+	v2CmdLocal     = 0x0
+	v2CmdProxy     = 0x1
+	v2FamilyTCP4   = 0x11
+	v2FamilyTCP6   = 0x21
+)
+
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ErrInvalidHeader is returned by reads on a connection that didn't start with
+// a valid PROXY protocol header
+var ErrInvalidHeader = errors.New("invalid PROXY protocol header")
+
+// Listener wraps a listener whose connections all start with a PROXY protocol
+// header. Connections without one are rejected rather than trusted as-is.
+type Listener struct {
+	net.Listener
+	// HeaderTimeout bounds reading the header (0 = DefaultHeaderTimeout)
+	HeaderTimeout time.Duration
+}
+
+// NewListener wraps ln so accepted connections report the client address
+// carried in their PROXY protocol header
+func NewListener(ln net.Listener, headerTimeout time.Duration) *Listener {
+	return &Listener{Listener: ln, HeaderTimeout: headerTimeout}
+}
+
+// Accept returns the next connection. The header is read lazily on first use,
+// so a slow client doesn't hold up the accept loop.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	timeout := l.HeaderTimeout
+	if timeout <= 0 {
+		timeout = DefaultHeaderTimeout
+	}
+	return &Conn{Conn: c, reader: bufio.NewReader(c), headerTimeout: timeout}, nil
+}
+
+// Conn is a connection whose RemoteAddr is the address from its PROXY header
+type Conn struct {
+	net.Conn
+	reader        *bufio.Reader
+	headerTimeout time.Duration
+
+	once       sync.Once
+	remoteAddr net.Addr
+	headerErr  error
+
+	mu sync.Mutex
+	// readDeadline is the last read deadline set by the caller, put back once
+	// the header has been read
+	readDeadline time.Time
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.headerErr != nil {
+		return 0, c.headerErr
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address from the header, or the peer address
+// for LOCAL/UNKNOWN headers (e.g. load balancer health checks)
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) readHeader() {
+	c.mu.Lock()
+	deadline := time.Now().Add(c.headerTimeout)
+	if !c.readDeadline.IsZero() && c.readDeadline.Before(deadline) {
+		deadline = c.readDeadline
+	}
+	c.Conn.SetReadDeadline(deadline)
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.Conn.SetReadDeadline(c.readDeadline)
+	}()
+
+	c.remoteAddr, c.headerErr = ReadHeader(c.reader)
+	if c.headerErr != nil {
+		c.Conn.Close()
+	}
+}
+
+// proxyHeaderKey marks contexts of connections accepted by a Listener
+type proxyHeaderKey struct{}
+
+// ConnContext is an http.Server ConnContext hook for servers accepting through
+// a Listener. It records that request RemoteAddrs come from PROXY headers, see
+// FromProxyHeader.
+func ConnContext(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, proxyHeaderKey{}, true)
+}
+
+// FromProxyHeader reports whether ctx belongs to a request whose RemoteAddr
+// was set by the load balancer's PROXY header. Such an address is
+// authoritative, unlike client-supplied X-Forwarded-For.
+func FromProxyHeader(ctx context.Context) bool {
+	marked, _ := ctx.Value(proxyHeaderKey{}).(bool)
+	return marked
+}
+
+// ReadHeader consumes a v1 or v2 header from r and returns the source address
+// it carries. A nil address means the header didn't name one (LOCAL, UNKNOWN,
+// or a non-TCP family).
+func ReadHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+	if string(sig) == v1Prefix {
+		return readV1(r)
+	}
+
+	sig, err = r.Peek(len(v2Signature))
+	if err != nil || !bytes.Equal(sig, v2Signature) {
+		return nil, ErrInvalidHeader
+	}
+	return readV2(r)
+}
+
+// readV1 parses "PROXY TCP4|TCP6|UNKNOWN src dst sport dport\r\n"
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: v1 header too long", ErrInvalidHeader)
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: malformed v1 header", ErrInvalidHeader)
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("%w: bad source address %q", ErrInvalidHeader, fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad source port %q", ErrInvalidHeader, fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readV2 parses the binary header: signature, version/command, family,
+// length, then the address block
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [v2HeaderLength]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidHeader, hdr[12]>>4)
+	}
+	cmd := hdr[12] & 0x0f
+	if cmd != v2CmdLocal && cmd != v2CmdProxy {
+		return nil, fmt.Errorf("%w: unsupported command %d", ErrInvalidHeader, cmd)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+	if cmd == v2CmdLocal {
+		return nil, nil
+	}
+
+	switch hdr[13] {
+	case v2FamilyTCP4:
+		if len(payload) < 12 {
+			return nil, fmt.Errorf("%w: short TCP4 address block", ErrInvalidHeader)
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:4]),
+			Port: int(binary.BigEndian.Uint16(payload[8:10])),
+		}, nil
+	case v2FamilyTCP6:
+		if len(payload) < 36 {
+			return nil, fmt.Errorf("%w: short TCP6 address block", ErrInvalidHeader)
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:16]),
+			Port: int(binary.BigEndian.Uint16(payload[32:34])),
+		}, nil
+	}
+	// UDP and unix sockets carry no TCP client address to report
+	return nil, nil
+}
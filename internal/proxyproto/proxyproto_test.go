@@ -0,0 +1,184 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveRemoteAddr serves HTTP on a PROXY protocol listener, echoing r.RemoteAddr
+func serveRemoteAddr(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.RemoteAddr)
+	})}
+	go srv.Serve(NewListener(ln, time.Second))
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+// roundTrip writes header followed by a GET and returns the response body
+func roundTrip(t *testing.T, addr string, header []byte) (string, error) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	conn.Write(header)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: gateway\r\nConnection: close\r\n\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func v2Header(cmd, family byte, addrs []byte) []byte {
+	h := append([]byte{}, v2Signature...)
+	h = append(h, 0x20|cmd, family, 0, 0)
+	binary.BigEndian.PutUint16(h[14:16], uint16(len(addrs)))
+	return append(h, addrs...)
+}
+
+func TestListenerRecoversClientAddr(t *testing.T) {
+	addr := serveRemoteAddr(t)
+
+	tcp4 := []byte{203, 0, 113, 7, 10, 0, 0, 1, 0xc3, 0x50, 0x1f, 0x90} // :50000 -> :8080
+	tcp6 := make([]byte, 36)
+	copy(tcp6, net.ParseIP("2001:db8::1"))
+	binary.BigEndian.PutUint16(tcp6[32:], 443)
+
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{"v1 TCP4", []byte("PROXY TCP4 203.0.113.7 10.0.0.1 50000 8080\r\n"), "203.0.113.7:50000"},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 443 8080\r\n"), "[2001:db8::1]:443"},
+		{"v2 TCP4", v2Header(v2CmdProxy, v2FamilyTCP4, tcp4), "203.0.113.7:50000"},
+		{"v2 TCP6", v2Header(v2CmdProxy, v2FamilyTCP6, tcp6), "[2001:db8::1]:443"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := roundTrip(t, addr, tt.header)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("RemoteAddr = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListenerKeepsPeerAddrForLocalHeaders(t *testing.T) {
+	addr := serveRemoteAddr(t)
+
+	for name, header := range map[string][]byte{
+		"v1 UNKNOWN": []byte("PROXY UNKNOWN\r\n"),
+		"v2 LOCAL":   v2Header(v2CmdLocal, 0, nil),
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := roundTrip(t, addr, header)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			if !strings.HasPrefix(got, "127.0.0.1:") {
+				t.Errorf("RemoteAddr = %q, want the peer address", got)
+			}
+		})
+	}
+}
+
+func TestListenerRejectsMissingHeader(t *testing.T) {
+	addr := serveRemoteAddr(t)
+
+	for name, header := range map[string][]byte{
+		"none":       nil,
+		"bad v1":     []byte("PROXY TCP4 not-an-ip 10.0.0.1 1 2\r\n"),
+		"bad v2 ver": append(append([]byte{}, v2Signature...), 0x11, v2FamilyTCP4, 0, 0),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if body, err := roundTrip(t, addr, header); err == nil {
+				t.Errorf("request succeeded with body %q, want connection rejected", body)
+			}
+		})
+	}
+}
+
+// deadlineConn records the read deadlines set on it
+type deadlineConn struct {
+	net.Conn
+	deadlines []time.Time
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.deadlines = append(c.deadlines, t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func TestHeaderReadRestoresDeadline(t *testing.T) {
+	tests := []struct {
+		name          string
+		deadline      time.Duration // set before the header is read, 0 = none
+		headerTimeout time.Duration
+		wantHeaderMax time.Duration
+	}{
+		{"no deadline", 0, time.Second, time.Second},
+		{"later deadline", time.Hour, time.Second, time.Second},
+		{"earlier deadline", time.Second, time.Hour, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			underlying := &deadlineConn{Conn: server}
+			conn := &Conn{Conn: underlying, reader: bufio.NewReader(server), headerTimeout: tt.headerTimeout}
+			defer conn.Close()
+
+			var deadline time.Time
+			if tt.deadline > 0 {
+				deadline = time.Now().Add(tt.deadline)
+				conn.SetReadDeadline(deadline)
+			}
+			go io.WriteString(client, "PROXY TCP4 203.0.113.7 10.0.0.1 50000 8080\r\nGET")
+
+			buf := make([]byte, 3)
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if got := conn.RemoteAddr().String(); got != "203.0.113.7:50000" {
+				t.Errorf("RemoteAddr = %q, want 203.0.113.7:50000", got)
+			}
+
+			n := len(underlying.deadlines)
+			if n < 2 {
+				t.Fatalf("deadlines set = %v, want the header deadline and the restored one", underlying.deadlines)
+			}
+			header := underlying.deadlines[n-2]
+			if header.IsZero() || time.Until(header) > tt.wantHeaderMax {
+				t.Errorf("header deadline in %v, want at most %v", time.Until(header), tt.wantHeaderMax)
+			}
+			if restored := underlying.deadlines[n-1]; !restored.Equal(deadline) {
+				t.Errorf("restored deadline = %v, want %v", restored, deadline)
+			}
+		})
+	}
+}
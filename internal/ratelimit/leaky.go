@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// leakyBucketScript schedules a request in a client's queue. The key holds
+// the time (in microseconds) at which the queue drains; each admitted
+// request pushes it back by one leak interval. A request that would find
+// capacity or more requests ahead of it is rejected. Returns whether the
+// request was admitted and how long it waits in the queue.
+//
+// KEYS[1] queue key
+// ARGV[1] now, ARGV[2] leak interval, ARGV[3] capacity (all µs but capacity)
+var leakyBucketScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+
+local drained = tonumber(redis.call("GET", KEYS[1]) or now)
+if drained < now then
+	drained = now
+end
+local wait = drained - now
+if wait >= capacity * interval then
+	return {0, wait}
+end
+
+redis.call("SET", KEYS[1], drained + interval, "PX", math.ceil((wait + interval) / 1000) + 1000)
+return {1, wait}
+`)
+
+// allowLeaky admits a request into the client's queue of burstSize
+// requests, which leaks requestsPerWindow times per window. The returned
+// Delay spaces admitted requests out evenly instead of letting a burst
+// through at once.
+func (rl *RateLimiter) allowLeaky(ctx context.Context, key string, requestsPerWindow, burstSize int) (*Result, error) {
+	interval := max(rl.window/time.Duration(max(requestsPerWindow, 1)), time.Microsecond)
+	capacity := max(burstSize, 1)
+
+	reply, err := leakyBucketScript.Run(ctx, rl.client, []string{"ratelimit:leaky:" + key},
+		rl.clock.Now().UnixMicro(), interval.Microseconds(), capacity).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute rate limit check: %w", err)
+	}
+	allowed := reply[0] == 1
+	wait := time.Duration(reply[1]) * time.Microsecond
+
+	// Requests ahead of this one, rounded up since one may be partly drained
+	ahead := int((wait + interval - 1) / interval)
+	if !allowed {
+		// The queue has room again once it's shorter than capacity
+		return &Result{
+			Remaining:  0,
+			ResetAfter: wait - time.Duration(capacity)*interval + time.Microsecond,
+		}, nil
+	}
+	return &Result{
+		Allowed:    true,
+		Remaining:  max(capacity-ahead-1, 0),
+		ResetAfter: wait + interval,
+		Delay:      wait,
+	}, nil
+}
@@ -5,31 +5,73 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/bimakw/api-gateway/internal/clock"
 	"github.com/redis/go-redis/v9"
 )
 
+// Algorithms selectable with SetAlgorithm for AllowWithBurst and AllowWithRate
+const (
+	// AlgorithmTokenBucket admits bursts up to the bucket size at once
+	AlgorithmTokenBucket = "token-bucket"
+	// AlgorithmLeakyBucket queues up to the bucket size and releases
+	// requests at a constant rate, see Result.Delay
+	AlgorithmLeakyBucket = "leaky"
+)
+
 type RateLimiter struct {
-	client   *redis.Client
-	requests int
-	window   time.Duration
+	client    redis.UniversalClient
+	requests  int
+	window    time.Duration
+	clock     clock.Clock
+	algorithm string
 }
 
 type Result struct {
 	Allowed    bool
 	Remaining  int
 	ResetAfter time.Duration
+	// Delay is how long an allowed request must wait before it proceeds, so
+	// that queued requests leave at the leak rate (leaky bucket only)
+	Delay time.Duration
 }
 
-func New(client *redis.Client, requestsPerWindow int, window time.Duration) *RateLimiter {
+func New(client redis.UniversalClient, requestsPerWindow int, window time.Duration) *RateLimiter {
 	return &RateLimiter{
-		client:   client,
-		requests: requestsPerWindow,
-		window:   window,
+		client:    client,
+		requests:  requestsPerWindow,
+		window:    window,
+		clock:     clock.Real,
+		algorithm: AlgorithmTokenBucket,
 	}
 }
 
+// SetAlgorithm selects the algorithm behind AllowWithBurst and
+// AllowWithRate (empty = token bucket)
+func (rl *RateLimiter) SetAlgorithm(algorithm string) error {
+	switch algorithm {
+	case "":
+		algorithm = AlgorithmTokenBucket
+	case AlgorithmTokenBucket, AlgorithmLeakyBucket:
+	default:
+		return fmt.Errorf("unknown rate limit algorithm %q (want %s or %s)", algorithm, AlgorithmTokenBucket, AlgorithmLeakyBucket)
+	}
+	rl.algorithm = algorithm
+	return nil
+}
+
+// SetClock replaces the time source used for windows and token refill
+func (rl *RateLimiter) SetClock(c clock.Clock) {
+	rl.clock = clock.OrReal(c)
+}
+
+// Clock returns the limiter's time source, on which callers wait out a
+// Result's Delay
+func (rl *RateLimiter) Clock() clock.Clock {
+	return rl.clock
+}
+
 func (rl *RateLimiter) Allow(ctx context.Context, key string) (*Result, error) {
-	now := time.Now()
+	now := rl.clock.Now()
 	windowStart := now.Truncate(rl.window)
 	windowKey := fmt.Sprintf("ratelimit:%s:%d", key, windowStart.Unix())
 
@@ -48,7 +90,7 @@ func (rl *RateLimiter) Allow(ctx context.Context, key string) (*Result, error) {
 		remaining = 0
 	}
 
-	resetAfter := rl.window - time.Since(windowStart)
+	resetAfter := rl.window - now.Sub(windowStart)
 
 	return &Result{
 		Allowed:    count <= rl.requests,
@@ -58,7 +100,17 @@ func (rl *RateLimiter) Allow(ctx context.Context, key string) (*Result, error) {
 }
 
 func (rl *RateLimiter) AllowWithBurst(ctx context.Context, key string, burstSize int) (*Result, error) {
-	now := time.Now()
+	return rl.AllowWithRate(ctx, key, rl.requests, burstSize)
+}
+
+// AllowWithRate is AllowWithBurst with the bucket refilling at
+// requestsPerWindow instead of the limiter's default rate
+func (rl *RateLimiter) AllowWithRate(ctx context.Context, key string, requestsPerWindow, burstSize int) (*Result, error) {
+	if rl.algorithm == AlgorithmLeakyBucket {
+		return rl.allowLeaky(ctx, key, requestsPerWindow, burstSize)
+	}
+
+	now := rl.clock.Now()
 	bucketKey := fmt.Sprintf("ratelimit:bucket:%s", key)
 	lastKey := fmt.Sprintf("ratelimit:last:%s", key)
 
@@ -87,7 +139,7 @@ func (rl *RateLimiter) AllowWithBurst(ctx context.Context, key string, burstSize
 
 	// Calculate tokens to add based on time elapsed
 	elapsed := now.Sub(lastUpdate)
-	tokensPerSecond := float64(rl.requests) / rl.window.Seconds()
+	tokensPerSecond := float64(requestsPerWindow) / rl.window.Seconds()
 	tokensToAdd := elapsed.Seconds() * tokensPerSecond
 	tokens = min(float64(burstSize), tokens+tokensToAdd)
 
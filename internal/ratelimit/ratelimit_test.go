@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bimakw/api-gateway/internal/clock"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestAllowWithBurstRefillsOnClock(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+	rl := New(client, 60, time.Minute) // one token per second
+	rl.SetClock(clk)
+	ctx := context.Background()
+
+	if res, err := rl.AllowWithBurst(ctx, "client", 1); err != nil || !res.Allowed {
+		t.Fatalf("first request = %+v, %v; want allowed", res, err)
+	}
+	if res, _ := rl.AllowWithBurst(ctx, "client", 1); res.Allowed {
+		t.Fatal("second request should exhaust the bucket")
+	}
+
+	clk.Advance(time.Second)
+	if res, _ := rl.AllowWithBurst(ctx, "client", 1); !res.Allowed {
+		t.Error("a token should be refilled after one second")
+	}
+}
+
+func TestLeakyBucketSpacesRequests(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+	rl := New(client, 60, time.Minute) // leaks one request per second
+	rl.SetClock(clk)
+	if err := rl.SetAlgorithm(AlgorithmLeakyBucket); err != nil {
+		t.Fatalf("SetAlgorithm() error = %v", err)
+	}
+	ctx := context.Background()
+
+	// A burst is queued one interval apart up to the capacity
+	for i, wantDelay := range []time.Duration{0, time.Second, 2 * time.Second} {
+		res, err := rl.AllowWithBurst(ctx, "client", 3)
+		if err != nil {
+			t.Fatalf("request %d error = %v", i+1, err)
+		}
+		if !res.Allowed || res.Delay != wantDelay {
+			t.Errorf("request %d = %+v, want allowed after %v", i+1, res, wantDelay)
+		}
+		if wantRemaining := 2 - i; res.Remaining != wantRemaining {
+			t.Errorf("request %d remaining = %d, want %d", i+1, res.Remaining, wantRemaining)
+		}
+	}
+	res, err := rl.AllowWithBurst(ctx, "client", 3)
+	if err != nil {
+		t.Fatalf("overflow request error = %v", err)
+	}
+	if res.Allowed {
+		t.Fatalf("request beyond the queue capacity allowed: %+v", res)
+	}
+	if res.ResetAfter <= 0 || res.ResetAfter > time.Second {
+		t.Errorf("ResetAfter = %v, want within the next leak", res.ResetAfter)
+	}
+
+	// Other clients have their own queue
+	if res, _ := rl.AllowWithBurst(ctx, "other", 3); !res.Allowed || res.Delay != 0 {
+		t.Errorf("other client = %+v, want allowed immediately", res)
+	}
+
+	// One leak frees one place, at the back of the queue
+	clk.Advance(time.Second)
+	if res, _ := rl.AllowWithBurst(ctx, "client", 3); !res.Allowed || res.Delay != 2*time.Second {
+		t.Errorf("request after a leak = %+v, want allowed after 2s", res)
+	}
+	if res, _ := rl.AllowWithBurst(ctx, "client", 3); res.Allowed {
+		t.Error("queue should be full again")
+	}
+
+	// A drained queue admits immediately
+	clk.Advance(time.Minute)
+	if res, _ := rl.AllowWithBurst(ctx, "client", 3); !res.Allowed || res.Delay != 0 {
+		t.Errorf("request after draining = %+v, want allowed immediately", res)
+	}
+}
+
+func TestSetAlgorithmRejectsUnknown(t *testing.T) {
+	rl := New(nil, 60, time.Minute)
+	if err := rl.SetAlgorithm("sliding-window"); err == nil {
+		t.Error("SetAlgorithm(sliding-window) succeeded, want error")
+	}
+}
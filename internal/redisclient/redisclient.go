@@ -0,0 +1,79 @@
+package redisclient
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/bimakw/api-gateway/config"
+	"github.com/bimakw/api-gateway/internal/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+// New builds a Redis client for the configured deployment mode
+func New(cfg config.RedisConfig) (redis.UniversalClient, error) {
+	switch cfg.Mode {
+	case "", ModeStandalone:
+		return redis.NewClient(&redis.Options{
+			Addr:     net.JoinHostPort(cfg.Host, cfg.Port),
+			Password: cfg.Password,
+			DB:       cfg.DB,
+
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+		}), nil
+
+	case ModeSentinel:
+		if len(cfg.SentinelAddrs) == 0 {
+			return nil, fmt.Errorf("sentinel mode requires REDIS_SENTINEL_ADDRS")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    cfg.SentinelMaster,
+			SentinelAddrs: cfg.SentinelAddrs,
+			Password:      cfg.Password,
+			DB:            cfg.DB,
+
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+		}), nil
+
+	case ModeCluster:
+		if len(cfg.ClusterAddrs) == 0 {
+			return nil, fmt.Errorf("cluster mode requires REDIS_CLUSTER_ADDRS")
+		}
+		// Cluster mode has no database selection; DB is ignored
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    cfg.ClusterAddrs,
+			Password: cfg.Password,
+
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+		}), nil
+	}
+
+	return nil, fmt.Errorf("unknown redis mode %q", cfg.Mode)
+}
+
+// PoolStats converts the client's connection pool counters for /metrics.
+// Cluster clients report the sum across node pools.
+func PoolStats(client redis.UniversalClient) metrics.RedisPoolStats {
+	s := client.PoolStats()
+	return metrics.RedisPoolStats{
+		Hits:       s.Hits,
+		Misses:     s.Misses,
+		Timeouts:   s.Timeouts,
+		TotalConns: s.TotalConns,
+		IdleConns:  s.IdleConns,
+	}
+}
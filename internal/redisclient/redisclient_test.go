@@ -0,0 +1,132 @@
+package redisclient
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bimakw/api-gateway/config"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestNewStandalone(t *testing.T) {
+	for _, mode := range []string{"", ModeStandalone} {
+		client, err := New(config.RedisConfig{Mode: mode, Host: "localhost", Port: "6379", DB: 2})
+		if err != nil {
+			t.Fatalf("New(%q) error = %v", mode, err)
+		}
+		defer client.Close()
+
+		c, ok := client.(*redis.Client)
+		if !ok {
+			t.Fatalf("New(%q) = %T, want *redis.Client", mode, client)
+		}
+		if c.Options().Addr != "localhost:6379" || c.Options().DB != 2 {
+			t.Errorf("options = %s db=%d, want localhost:6379 db=2", c.Options().Addr, c.Options().DB)
+		}
+	}
+}
+
+func TestNewSentinel(t *testing.T) {
+	client, err := New(config.RedisConfig{
+		Mode:           ModeSentinel,
+		SentinelAddrs:  []string{"sentinel-1:26379", "sentinel-2:26379"},
+		SentinelMaster: "mymaster",
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	c, ok := client.(*redis.Client)
+	if !ok {
+		t.Fatalf("New() = %T, want *redis.Client", client)
+	}
+	// go-redis marks failover clients with a sentinel address placeholder
+	if c.Options().Addr != "FailoverClient" {
+		t.Errorf("Addr = %q, want FailoverClient", c.Options().Addr)
+	}
+}
+
+func TestNewCluster(t *testing.T) {
+	client, err := New(config.RedisConfig{
+		Mode:         ModeCluster,
+		ClusterAddrs: []string{"node-1:6379", "node-2:6379", "node-3:6379"},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	c, ok := client.(*redis.ClusterClient)
+	if !ok {
+		t.Fatalf("New() = %T, want *redis.ClusterClient", client)
+	}
+	if len(c.Options().Addrs) != 3 {
+		t.Errorf("Addrs = %v, want 3 nodes", c.Options().Addrs)
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	tests := []config.RedisConfig{
+		{Mode: "replicated"},
+		{Mode: ModeSentinel},
+		{Mode: ModeCluster},
+	}
+
+	for _, cfg := range tests {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("New(%+v) expected error", cfg)
+		}
+	}
+}
+
+func TestPoolConfigApplied(t *testing.T) {
+	client, err := New(config.RedisConfig{
+		Host:         "localhost",
+		Port:         "6379",
+		PoolSize:     25,
+		MinIdleConns: 4,
+		DialTimeout:  750 * time.Millisecond,
+		ReadTimeout:  2 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	opts := client.(*redis.Client).Options()
+	if opts.PoolSize != 25 || opts.MinIdleConns != 4 {
+		t.Errorf("pool size/min idle = %d/%d, want 25/4", opts.PoolSize, opts.MinIdleConns)
+	}
+	if opts.DialTimeout != 750*time.Millisecond || opts.ReadTimeout != 2*time.Second {
+		t.Errorf("dial/read timeout = %v/%v, want 750ms/2s", opts.DialTimeout, opts.ReadTimeout)
+	}
+}
+
+func TestPoolStatsReported(t *testing.T) {
+	mr := miniredis.RunT(t)
+	host, port, _ := net.SplitHostPort(mr.Addr())
+
+	client, err := New(config.RedisConfig{Host: host, Port: port})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := client.Ping(context.Background()).Err(); err != nil {
+			t.Fatalf("Ping() error = %v", err)
+		}
+	}
+
+	stats := PoolStats(client)
+	if stats.TotalConns != 1 || stats.IdleConns != 1 {
+		t.Errorf("total/idle conns = %d/%d, want 1/1", stats.TotalConns, stats.IdleConns)
+	}
+	if stats.Misses != 1 || stats.Hits != 2 {
+		t.Errorf("hits/misses = %d/%d, want 2/1", stats.Hits, stats.Misses)
+	}
+}
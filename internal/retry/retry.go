@@ -5,8 +5,12 @@ import (
 	"errors"
 	"math"
 	"math/rand"
+	"net"
 	"net/http"
+	"slices"
 	"time"
+
+	"github.com/bimakw/api-gateway/internal/clock"
 )
 
 type Config struct {
@@ -22,11 +26,44 @@ type Config struct {
 	// Multiplier is the factor to multiply delay by after each retry
 	Multiplier float64
 
-	// JitterFactor adds randomness to prevent thundering herd (0.0-1.0)
+	// JitterFactor adds randomness to prevent thundering herd (0.0-1.0).
+	// Only used by JitterSymmetric.
 	JitterFactor float64
 
+	// JitterMode selects how randomness is applied to each delay
+	JitterMode JitterMode
+
 	// RetryableStatusCodes are HTTP status codes that should trigger a retry
 	RetryableStatusCodes []int
+
+	// RetryOnTooEarly adds 425 Too Early, sent when a server rejects TLS
+	// early data, to the retryable status codes
+	RetryOnTooEarly bool
+
+	// Clock times the wait between attempts (nil = wall clock)
+	Clock clock.Clock
+}
+
+// JitterMode controls how GetDelay randomizes the computed backoff delay
+type JitterMode string
+
+const (
+	// JitterSymmetric varies the delay by ±JitterFactor
+	JitterSymmetric JitterMode = "symmetric"
+	// JitterNone uses the computed delay as-is
+	JitterNone JitterMode = "none"
+	// JitterFull picks uniformly from [0, delay]
+	JitterFull JitterMode = "full"
+	// JitterEqual keeps half the delay and randomizes the other half: [delay/2, delay]
+	JitterEqual JitterMode = "equal"
+)
+
+func (m JitterMode) valid() bool {
+	switch m {
+	case JitterSymmetric, JitterNone, JitterFull, JitterEqual:
+		return true
+	}
+	return false
 }
 
 func DefaultConfig() Config {
@@ -36,14 +73,20 @@ func DefaultConfig() Config {
 		MaxDelay:     5 * time.Second,
 		Multiplier:   2.0,
 		JitterFactor: 0.1,
+		JitterMode:   JitterSymmetric,
 		RetryableStatusCodes: []int{
 			http.StatusBadGateway,         // 502
 			http.StatusServiceUnavailable, // 503
 			http.StatusGatewayTimeout,     // 504
 		},
+		RetryOnTooEarly: true,
 	}
 }
 
+// ErrTransient marks an error as worth retrying; wrap it to retry failures
+// that aren't network errors or retryable status codes
+var ErrTransient = errors.New("transient failure")
+
 type Retryer struct {
 	config Config
 }
@@ -64,9 +107,16 @@ func New(cfg Config) *Retryer {
 	if cfg.JitterFactor < 0 || cfg.JitterFactor > 1 {
 		cfg.JitterFactor = 0.1
 	}
+	if !cfg.JitterMode.valid() {
+		cfg.JitterMode = JitterSymmetric
+	}
 	if len(cfg.RetryableStatusCodes) == 0 {
 		cfg.RetryableStatusCodes = DefaultConfig().RetryableStatusCodes
 	}
+	if cfg.RetryOnTooEarly && !slices.Contains(cfg.RetryableStatusCodes, http.StatusTooEarly) {
+		cfg.RetryableStatusCodes = append(slices.Clone(cfg.RetryableStatusCodes), http.StatusTooEarly)
+	}
+	cfg.Clock = clock.OrReal(cfg.Clock)
 
 	return &Retryer{config: cfg}
 }
@@ -98,25 +148,37 @@ func (r *Retryer) ShouldRetry(statusCode int) bool {
 
 // GetDelay calculates the delay before the next retry attempt
 func (r *Retryer) GetDelay(attempt int) time.Duration {
-	if attempt <= 0 {
-		return r.config.InitialDelay
+	delay := float64(r.config.InitialDelay)
+	if attempt > 0 {
+		// Calculate exponential delay
+		delay *= math.Pow(r.config.Multiplier, float64(attempt))
+
+		// Apply max delay cap
+		if delay > float64(r.config.MaxDelay) {
+			delay = float64(r.config.MaxDelay)
+		}
 	}
 
-	// Calculate exponential delay
-	delay := float64(r.config.InitialDelay) * math.Pow(r.config.Multiplier, float64(attempt))
+	return time.Duration(r.applyJitter(delay, attempt))
+}
 
-	// Apply max delay cap
-	if delay > float64(r.config.MaxDelay) {
-		delay = float64(r.config.MaxDelay)
+// applyJitter randomizes delay according to the configured mode to prevent
+// thundering herd
+func (r *Retryer) applyJitter(delay float64, attempt int) float64 {
+	switch r.config.JitterMode {
+	case JitterNone:
+		return delay
+	case JitterFull:
+		return rand.Float64() * delay
+	case JitterEqual:
+		return delay/2 + rand.Float64()*delay/2
 	}
 
-	// Apply jitter to prevent thundering herd
-	if r.config.JitterFactor > 0 {
-		jitter := delay * r.config.JitterFactor * (rand.Float64()*2 - 1) // -jitter to +jitter
-		delay += jitter
+	// Symmetric jitter has always left the initial delay untouched
+	if attempt <= 0 || r.config.JitterFactor <= 0 {
+		return delay
 	}
-
-	return time.Duration(delay)
+	return delay + delay*r.config.JitterFactor*(rand.Float64()*2-1) // -jitter to +jitter
 }
 
 func (r *Retryer) MaxRetries() int {
@@ -148,7 +210,7 @@ func (r *Retryer) Execute(ctx context.Context, fn func() (int, error)) Result {
 			case <-ctx.Done():
 				result.LastError = ctx.Err()
 				return result
-			case <-time.After(delay):
+			case <-r.config.Clock.After(delay):
 				// Continue with retry
 			}
 		}
@@ -190,11 +252,26 @@ func isTransientError(err error) bool {
 		return false
 	}
 
+	if errors.Is(err, ErrTransient) {
+		return true
+	}
+
 	// Check for context errors - don't retry these
 	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 		return false
 	}
 
+	// Transport timeouts (e.g. awaiting response headers) and failed dials,
+	// which never reached the backend
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+
 	// Network errors are generally transient
 	errStr := err.Error()
 	transientPatterns := []string{
@@ -206,6 +283,7 @@ func isTransientError(err error) bool {
 		"network is unreachable",
 		"connection timed out",
 		"EOF",
+		"GOAWAY",
 	}
 
 	for _, pattern := range transientPatterns {
@@ -217,6 +295,13 @@ func isTransientError(err error) bool {
 	return false
 }
 
+// IsGoAway reports whether err comes from an HTTP/2 backend that sent GOAWAY
+// to drain its connections, typically during a rollout. The request may
+// have been partly processed, so only idempotent requests should be replayed.
+func IsGoAway(err error) bool {
+	return err != nil && containsIgnoreCase(err.Error(), "GOAWAY")
+}
+
 func containsIgnoreCase(s, substr string) bool {
 	sLower := toLower(s)
 	substrLower := toLower(substr)
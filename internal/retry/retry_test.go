@@ -3,9 +3,14 @@ package retry
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/bimakw/api-gateway/internal/clock"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -128,6 +133,35 @@ func TestShouldRetryWithCustomCodes(t *testing.T) {
 	}
 }
 
+func TestRetryOnTooEarly(t *testing.T) {
+	if !New(DefaultConfig()).ShouldRetry(http.StatusTooEarly) {
+		t.Error("default config should retry on 425")
+	}
+
+	codes := []int{http.StatusBadGateway}
+	r := New(Config{RetryableStatusCodes: codes, RetryOnTooEarly: true})
+	if !r.ShouldRetry(http.StatusTooEarly) || !r.ShouldRetry(http.StatusBadGateway) {
+		t.Error("RetryOnTooEarly should add 425 to the configured codes")
+	}
+	if len(codes) != 1 {
+		t.Errorf("caller's codes modified: %v", codes)
+	}
+
+	if New(Config{RetryableStatusCodes: codes}).ShouldRetry(http.StatusTooEarly) {
+		t.Error("should not retry on 425 unless enabled")
+	}
+}
+
+func TestIsGoAway(t *testing.T) {
+	goAway := fmt.Errorf("proxy: %w", errors.New(`http2: server sent GOAWAY and closed the connection; LastStreamID=3, ErrCode=NO_ERROR, debug=""`))
+	if !IsGoAway(goAway) {
+		t.Error("IsGoAway should match a wrapped GOAWAY error")
+	}
+	if IsGoAway(errors.New("connection refused")) || IsGoAway(nil) {
+		t.Error("IsGoAway matched a non-GOAWAY error")
+	}
+}
+
 func TestGetDelay(t *testing.T) {
 	r := New(Config{
 		InitialDelay: 100 * time.Millisecond,
@@ -188,6 +222,48 @@ func TestGetDelayWithJitter(t *testing.T) {
 	}
 }
 
+func TestGetDelayJitterModes(t *testing.T) {
+	base := Config{
+		InitialDelay: 100 * time.Millisecond,
+		MaxDelay:     1 * time.Second,
+		Multiplier:   2.0,
+	}
+
+	tests := []struct {
+		mode     JitterMode
+		min, max func(delay time.Duration) time.Duration
+	}{
+		{JitterFull, func(time.Duration) time.Duration { return 0 }, func(d time.Duration) time.Duration { return d }},
+		{JitterEqual, func(d time.Duration) time.Duration { return d / 2 }, func(d time.Duration) time.Duration { return d }},
+		{JitterNone, func(d time.Duration) time.Duration { return d }, func(d time.Duration) time.Duration { return d }},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			cfg := base
+			cfg.JitterMode = tt.mode
+			r := New(cfg)
+
+			// Uncapped delays for attempts 0-3, then the 1s cap
+			for attempt, delay := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second} {
+				for i := 0; i < 100; i++ {
+					got := r.GetDelay(attempt)
+					if got < tt.min(delay) || got > tt.max(delay) {
+						t.Fatalf("GetDelay(%d) = %v, want within [%v, %v]", attempt, got, tt.min(delay), tt.max(delay))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNewWithUnknownJitterMode(t *testing.T) {
+	r := New(Config{JitterMode: "sideways"})
+	if r.config.JitterMode != JitterSymmetric {
+		t.Errorf("JitterMode = %q, want %q (default)", r.config.JitterMode, JitterSymmetric)
+	}
+}
+
 func TestGetDelayNegativeAttempt(t *testing.T) {
 	r := New(Config{
 		InitialDelay: 100 * time.Millisecond,
@@ -261,6 +337,46 @@ func TestExecuteRetryOnStatus(t *testing.T) {
 	}
 }
 
+func TestExecuteWaitsOnClock(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	r := New(Config{
+		MaxRetries:           1,
+		InitialDelay:         time.Hour,
+		MaxDelay:             time.Hour,
+		JitterMode:           JitterNone,
+		RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+		Clock:                clk,
+	})
+
+	var calls atomic.Int32
+	done := make(chan Result)
+	go func() {
+		done <- r.Execute(context.Background(), func() (int, error) {
+			if calls.Add(1) == 1 {
+				return http.StatusServiceUnavailable, nil
+			}
+			return http.StatusOK, nil
+		})
+	}()
+
+	// The retry waits on the fake clock rather than real time
+	for deadline := time.Now().Add(time.Second); clk.Timers() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("retry never scheduled a wait")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("calls before the delay elapsed = %d, want 1", got)
+	}
+
+	clk.Advance(time.Hour)
+	result := <-done
+	if result.Attempts != 2 || result.StatusCode != http.StatusOK {
+		t.Errorf("result = %+v, want success on attempt 2", result)
+	}
+}
+
 func TestExecuteRetryOnTransientError(t *testing.T) {
 	r := New(Config{
 		MaxRetries:   3,
@@ -408,6 +524,11 @@ func TestIsTransientError(t *testing.T) {
 		{"network unreachable", errors.New("network is unreachable"), true},
 		{"connection timed out", errors.New("connection timed out"), true},
 		{"EOF", errors.New("EOF"), true},
+		{"http2 GOAWAY", errors.New(`http2: server sent GOAWAY and closed the connection; LastStreamID=1, ErrCode=NO_ERROR, debug=""`), true},
+		{"wrapped ErrTransient", fmt.Errorf("body matched: %w", ErrTransient), true},
+		{"dial error", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("host down")}, true},
+		{"transport timeout", fmt.Errorf("round trip: %w", timeoutError{}), true},
+		{"failed write", &net.OpError{Op: "write", Net: "tcp", Err: errors.New("broken pipe")}, false},
 		{"permanent error", errors.New("invalid request"), false},
 		{"unknown error", errors.New("something went wrong"), false},
 	}
@@ -421,6 +542,13 @@ func TestIsTransientError(t *testing.T) {
 	}
 }
 
+// timeoutError is a net.Error like net/http's "timeout awaiting response headers"
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout awaiting response headers" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
 func TestContainsIgnoreCase(t *testing.T) {
 	tests := []struct {
 		s        string
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/bimakw/api-gateway/config"
+	"github.com/bimakw/api-gateway/internal/proxyproto"
+)
+
+// New creates the gateway HTTP server for the given handler
+func New(cfg config.ServerConfig, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:         net.JoinHostPort(cfg.Host, cfg.Port),
+		Handler:      handler,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 25 * time.Second,
+		IdleTimeout:  90 * time.Second,
+	}
+
+	// net/http answers past its own limit with a bare 431; keep it above the
+	// gateway's so oversized headers get the JSON response
+	if cfg.MaxHeaderBytes > http.DefaultMaxHeaderBytes {
+		srv.MaxHeaderBytes = cfg.MaxHeaderBytes
+	}
+
+	if cfg.ProxyProtocol {
+		// Client addresses come from the PROXY header, not forwarded headers
+		srv.ConnContext = proxyproto.ConnContext
+	}
+
+	if cfg.TLSEnabled() {
+		srv.TLSConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	if cfg.H2C {
+		// net/http only speaks h2c with prior knowledge: an "Upgrade: h2c"
+		// request is answered over HTTP/1.1. HTTP/1.1 stays enabled so other
+		// Upgrade requests (e.g. WebSocket) keep working.
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		srv.Protocols = protocols
+	}
+
+	return srv
+}
+
+// Serve accepts connections on ln, terminating TLS when a cert/key pair is configured
+func Serve(srv *http.Server, ln net.Listener, cfg config.ServerConfig) error {
+	if cfg.TLSEnabled() {
+		return srv.ServeTLS(ln, cfg.TLSCertFile, cfg.TLSKeyFile)
+	}
+	return srv.Serve(ln)
+}
+
+// NewRedirectServer creates a plain HTTP server that redirects every request to HTTPS.
+// Returns nil when TLS is disabled or no redirect port is configured.
+func NewRedirectServer(cfg config.ServerConfig) *http.Server {
+	if !cfg.TLSEnabled() || cfg.HTTPRedirectPort == "" {
+		return nil
+	}
+
+	return &http.Server{
+		Addr:         net.JoinHostPort(cfg.Host, cfg.HTTPRedirectPort),
+		Handler:      RedirectHandler(cfg.Port),
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+}
+
+// RedirectHandler redirects requests to the same host and path on the HTTPS port
+func RedirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
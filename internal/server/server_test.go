@@ -0,0 +1,302 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bimakw/api-gateway/config"
+	"github.com/bimakw/api-gateway/internal/middleware"
+	"github.com/bimakw/api-gateway/internal/proxyproto"
+)
+
+// writeSelfSignedCert writes a localhost certificate and key to dir
+func writeSelfSignedCert(t *testing.T, dir string) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() error = %v", err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	cert, _ := x509.ParseCertificate(der)
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+func TestTLSEnabled(t *testing.T) {
+	tests := []struct {
+		cfg  config.ServerConfig
+		want bool
+	}{
+		{config.ServerConfig{}, false},
+		{config.ServerConfig{TLSCertFile: "cert.pem"}, false},
+		{config.ServerConfig{TLSKeyFile: "key.pem"}, false},
+		{config.ServerConfig{TLSCertFile: "cert.pem", TLSKeyFile: "key.pem"}, true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.cfg.TLSEnabled(); got != tt.want {
+			t.Errorf("TLSEnabled() with %+v = %v, want %v", tt.cfg, got, tt.want)
+		}
+	}
+}
+
+func TestServeTLSHandshake(t *testing.T) {
+	certFile, keyFile, pool := writeSelfSignedCert(t, t.TempDir())
+	cfg := config.ServerConfig{Host: "127.0.0.1", Port: "0", TLSCertFile: certFile, TLSKeyFile: keyFile}
+
+	srv := New(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure"))
+	}))
+	if srv.TLSConfig == nil || srv.TLSConfig.MinVersion != tls.VersionTLS12 {
+		t.Fatal("expected TLS config with minimum version TLS 1.2")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	go Serve(srv, ln, cfg)
+	defer srv.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		Timeout:   5 * time.Second,
+	}
+
+	resp, err := client.Get("https://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.TLS == nil || !resp.TLS.HandshakeComplete {
+		t.Fatal("expected completed TLS handshake")
+	}
+	if resp.TLS.Version < tls.VersionTLS12 {
+		t.Errorf("TLS version = %x, want >= TLS 1.2", resp.TLS.Version)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "secure" {
+		t.Errorf("body = %q, want secure", body)
+	}
+
+	// Clients limited to TLS 1.1 must be rejected
+	oldClient := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MaxVersion: tls.VersionTLS11}},
+		Timeout:   5 * time.Second,
+	}
+	if _, err := oldClient.Get("https://" + ln.Addr().String() + "/"); err == nil {
+		t.Error("expected handshake failure for TLS 1.1 client")
+	}
+}
+
+func TestServePlainHTTPByDefault(t *testing.T) {
+	cfg := config.ServerConfig{Host: "127.0.0.1", Port: "0"}
+	srv := New(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("plain"))
+	}))
+	if srv.TLSConfig != nil {
+		t.Error("expected no TLS config when cert/key are unset")
+	}
+	if NewRedirectServer(cfg) != nil {
+		t.Error("expected no redirect server when TLS is disabled")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	go Serve(srv, ln, cfg)
+	defer srv.Close()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.TLS != nil {
+		t.Error("expected plain HTTP response")
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		port string
+		want string
+	}{
+		{"8443", "https://example.com:8443/api/users?id=1"},
+		{"443", "https://example.com/api/users?id=1"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com:8080/api/users?id=1", nil)
+		rec := httptest.NewRecorder()
+		RedirectHandler(tt.port).ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusPermanentRedirect {
+			t.Errorf("status = %d, want 308", rec.Code)
+		}
+		if got := rec.Header().Get("Location"); got != tt.want {
+			t.Errorf("Location = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestServeH2C(t *testing.T) {
+	cfg := config.ServerConfig{Host: "127.0.0.1", Port: "0", H2C: true}
+
+	// Wrap in a middleware-style handler to make sure the chain is preserved
+	var sawProto string
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sawProto = r.Proto
+		w.Header().Set("X-Middleware", "applied")
+		inner.ServeHTTP(w, r)
+	})
+
+	srv := New(cfg, handler)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	go Serve(srv, ln, cfg)
+	defer srv.Close()
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	h2cClient := &http.Client{
+		Transport: &http.Transport{Protocols: protocols},
+		Timeout:   5 * time.Second,
+	}
+
+	resp, err := h2cClient.Get("http://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("h2c GET error = %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("ProtoMajor = %d, want 2", resp.ProtoMajor)
+	}
+	if sawProto != "HTTP/2.0" {
+		t.Errorf("handler saw proto %q, want HTTP/2.0", sawProto)
+	}
+	if resp.Header.Get("X-Middleware") != "applied" {
+		t.Error("expected middleware header on h2c response")
+	}
+
+	// HTTP/1.1 clients must still be served
+	resp, err = http.Get("http://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("HTTP/1.1 GET error = %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.ProtoMajor != 1 {
+		t.Errorf("ProtoMajor = %d, want 1", resp.ProtoMajor)
+	}
+
+	// Only prior knowledge is supported: an h2c upgrade is ignored and the
+	// request answered over HTTP/1.1
+	req, _ := http.NewRequest(http.MethodGet, "http://"+ln.Addr().String()+"/", nil)
+	req.Header.Set("Connection", "Upgrade, HTTP2-Settings")
+	req.Header.Set("Upgrade", "h2c")
+	req.Header.Set("HTTP2-Settings", "AAMAAABkAARAAAAAAAIAAAAA")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Upgrade: h2c GET error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 1 || string(body) != "HTTP/1.1" {
+		t.Errorf("Upgrade: h2c response = %d %s %q, want 200 served over HTTP/1.1", resp.StatusCode, resp.Proto, body)
+	}
+}
+
+func TestServeH2CDisabledByDefault(t *testing.T) {
+	srv := New(config.ServerConfig{Host: "127.0.0.1", Port: "0"}, http.NotFoundHandler())
+	if srv.Protocols != nil {
+		t.Error("expected default protocols when h2c is disabled")
+	}
+}
+
+func TestServeProxyProtocolClientIP(t *testing.T) {
+	cfg := config.ServerConfig{Host: "127.0.0.1", Port: "0", ProxyProtocol: true}
+	srv := New(cfg, middleware.Attributes(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, middleware.ClientIP(r))
+	})))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	go Serve(srv, proxyproto.NewListener(ln, time.Second), cfg)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// The load balancer's header wins over the client's forwarded headers
+	io.WriteString(conn, "PROXY TCP4 203.0.113.7 10.0.0.1 50000 8080\r\n"+
+		"GET / HTTP/1.1\r\nHost: gateway\r\nX-Forwarded-For: 192.0.2.66\r\nX-Real-IP: 192.0.2.67\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "203.0.113.7" {
+		t.Errorf("client IP = %q, want 203.0.113.7", body)
+	}
+}
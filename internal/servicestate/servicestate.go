@@ -0,0 +1,58 @@
+package servicestate
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultSyncInterval is how often replicas reload the shared state
+const DefaultSyncInterval = 5 * time.Second
+
+const disabledKey = "services:disabled"
+
+// Store persists which services operators have taken offline, so every
+// gateway replica sharing the Redis instance routes the same way
+type Store struct {
+	client redis.UniversalClient
+}
+
+func NewStore(client redis.UniversalClient) *Store {
+	return &Store{client: client}
+}
+
+// SetDisabled records a service as disabled or enabled
+func (s *Store) SetDisabled(ctx context.Context, name string, disabled bool) error {
+	if disabled {
+		return s.client.SAdd(ctx, disabledKey, name).Err()
+	}
+	return s.client.SRem(ctx, disabledKey, name).Err()
+}
+
+// Disabled returns the names of all disabled services
+func (s *Store) Disabled(ctx context.Context) ([]string, error) {
+	return s.client.SMembers(ctx, disabledKey).Result()
+}
+
+// Sync passes the disabled set to apply immediately and then every interval
+// until ctx is done. Failed reads keep the last applied state.
+func (s *Store) Sync(ctx context.Context, interval time.Duration, apply func(disabled []string), logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if disabled, err := s.Disabled(ctx); err != nil {
+			logger.Warn("Failed to load disabled services", "error", err)
+		} else {
+			apply(disabled)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
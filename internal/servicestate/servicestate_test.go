@@ -0,0 +1,50 @@
+package servicestate
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestSyncAppliesSharedState(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	// Two stores stand in for two replicas sharing Redis
+	writer := NewStore(client)
+	reader := NewStore(client)
+
+	ctx := context.Background()
+	if err := writer.SetDisabled(ctx, "user-service", true); err != nil {
+		t.Fatalf("SetDisabled() error = %v", err)
+	}
+	if err := writer.SetDisabled(ctx, "auth-service", true); err != nil {
+		t.Fatalf("SetDisabled() error = %v", err)
+	}
+	if err := writer.SetDisabled(ctx, "auth-service", false); err != nil {
+		t.Fatalf("SetDisabled() error = %v", err)
+	}
+
+	applied := make(chan []string, 1)
+	syncCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go reader.Sync(syncCtx, time.Hour, func(disabled []string) {
+		applied <- disabled
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	select {
+	case got := <-applied:
+		if !slices.Equal(got, []string{"user-service"}) {
+			t.Errorf("disabled = %v, want [user-service]", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Sync did not apply the state on start")
+	}
+}
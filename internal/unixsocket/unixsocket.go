@@ -0,0 +1,38 @@
+package unixsocket
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Scheme marks backend URLs of the form unix:///path/to.sock
+const Scheme = "unix"
+
+// PlaceholderHost is used as the request host when talking to a socket
+const PlaceholderHost = "localhost"
+
+// SocketPath returns the socket path of a unix:// URL
+func SocketPath(u *url.URL) (string, bool) {
+	if u.Scheme != Scheme || u.Path == "" {
+		return "", false
+	}
+	return u.Path, true
+}
+
+// HTTPURL returns the placeholder http:// URL requests to a socket are addressed to
+func HTTPURL() *url.URL {
+	return &url.URL{Scheme: "http", Host: PlaceholderHost}
+}
+
+// Transport returns an HTTP transport that dials socketPath for every connection
+func Transport(socketPath string) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socketPath)
+	}
+	return transport
+}
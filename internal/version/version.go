@@ -0,0 +1,25 @@
+package version
+
+// Build metadata, injected at build time via -ldflags, e.g.
+//
+//	go build -ldflags "-X github.com/bimakw/api-gateway/internal/version.Version=1.2.0"
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// Info describes the running gateway build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+}
+
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+	}
+}